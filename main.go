@@ -23,6 +23,7 @@ var (
 	addr       = flag.String("metrics-address", ":2112", "The address to listen on for HTTP requests.")
 	kubeconfig = flag.String("kubeconfig", "", "Path to the kubeconfig file to use.")
 	tlsConf    = flag.String("metrics-tls-config", "", "The TLS config file for your metrics.")
+	reloadConf = flag.Duration("conf-reload-interval", 10*time.Second, "How often to check the config file for changes to reload it, 0 disables it. SIGHUP always reloads.")
 )
 
 var levels = map[string]slog.Level{
@@ -36,14 +37,7 @@ func main() {
 	flag.Parse()
 
 	log.Info().Msg("Reading config file " + *conf)
-	configBytes, err := os.ReadFile(*conf)
-	if err != nil {
-		log.Fatal().Err(err).Msg("cannot read config file")
-	}
-
-	configBytes = []byte(os.ExpandEnv(string(configBytes)))
-
-	cfg, err := setup.ParseConfigFromBytes(configBytes)
+	cfg, err := setup.ReadConfigFile(*conf)
 	if err != nil {
 		log.Fatal().Msg(err.Error())
 	}
@@ -92,7 +86,7 @@ func main() {
 	metrics.Init(*addr, *tlsConf)
 	metricsStore := metrics.NewMetricsStore(cfg.MetricsNamePrefix)
 
-	engine, err := exporter.NewEngine(&cfg, &exporter.ChannelBasedReceiverRegistry{MetricsStore: metricsStore})
+	engine, err := exporter.NewEngine(&cfg, &exporter.ChannelBasedReceiverRegistry{MetricsStore: metricsStore}, metricsStore)
 	if err != nil {
 		log.Fatal().Err(err).Msg(err.Error())
 	}
@@ -111,6 +105,8 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
+	go watchConfig(ctx, engine, metricsStore)
+
 	if cfg.LeaderElection.Enabled {
 		var wasLeader bool
 		slog.Info("leader election enabled")
@@ -166,3 +162,52 @@ func main() {
 	w.Stop()
 	engine.Stop()
 }
+
+// watchConfig reloads the configuration when the config file changes or a SIGHUP is received. Only the route and the
+// receivers are reloaded, other settings require a restart.
+func watchConfig(ctx context.Context, engine *exporter.Engine, metricsStore *metrics.Store) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var changes <-chan struct{}
+	if *reloadConf > 0 {
+		changes = setup.WatchConfigFile(ctx, *conf, *reloadConf)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("Received SIGHUP, reloading config file " + *conf)
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+			slog.Info("Config file changed, reloading " + *conf)
+		}
+
+		if err := reloadConfig(engine); err != nil {
+			metricsStore.ConfigReloadErrors.Inc()
+			slog.With("err", err).Error("Config reload failed, keeping the running config")
+			continue
+		}
+		metricsStore.ConfigReloads.Inc()
+		slog.Info("Config reloaded")
+	}
+}
+
+func reloadConfig(engine *exporter.Engine) error {
+	cfg, err := setup.ReadConfigFile(*conf)
+	if err != nil {
+		return err
+	}
+
+	cfg.SetDefaults()
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	return engine.Reload(&cfg)
+}
@@ -2,6 +2,7 @@ package batch
 
 import (
 	"context"
+	"log/slog"
 	"time"
 )
 
@@ -55,7 +56,12 @@ func (w *Writer) Start() {
 			case item := <-w.items:
 				if w.len >= w.cfg.BatchSize {
 					w.processBuffer(context.Background())
-					w.len = 0
+				}
+				if w.len >= w.cfg.BatchSize {
+					// All the items failed, the oldest one is given up to make room for the new one
+					slog.With("attempt", w.buffer[0].attempt).Warn("Batch buffer is full of failed items, dropping the oldest")
+					copy(w.buffer, w.buffer[1:w.len])
+					w.len--
 				}
 
 				w.buffer[w.len] = bufferItem{v: item, attempt: 0}
@@ -83,6 +89,12 @@ func (w *Writer) processBuffer(ctx context.Context) {
 		slice[i] = w.buffer[i].v
 	}
 
+	if w.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.cfg.Timeout)
+		defer cancel()
+	}
+
 	// Call the actual method
 	responses := w.Handler(ctx, slice)
 
@@ -107,7 +119,6 @@ func (w *Writer) processBuffer(ctx context.Context) {
 	}
 
 	w.len = newItemsCount
-	// TODO(makin) an edge case, if all items fail, and the buffer is full, new item cannot be added to buffer.
 }
 
 // Used to signal writer to stop processing items and exit.
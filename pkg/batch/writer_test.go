@@ -200,3 +200,75 @@ func TestRetry(t *testing.T) {
 	assert.Equal(t, allItems[2], []any{2})
 	assert.Equal(t, allItems[3], []any{2})
 }
+
+func TestRetryWhenBatchIsFull(t *testing.T) {
+	cfg := WriterConfig{
+		BatchSize:  2,
+		MaxRetries: 1,
+		Interval:   time.Hour,
+	}
+
+	allItems := make([][]any, 0)
+	w := NewWriter(cfg, func(ctx context.Context, items []any) []bool {
+		resp := make([]bool, len(items))
+		for idx := range resp {
+			resp[idx] = items[idx] != 2
+		}
+
+		allItems = append(allItems, items)
+		return resp
+	})
+
+	w.Start()
+	w.Submit(1, 2, 3, 4, 5)
+	w.Stop()
+
+	// The failed item is retried with the next batch instead of being dropped when the buffer is full
+	assert.Equal(t, [][]any{{1, 2}, {2, 3}, {4, 5}}, allItems)
+}
+
+func TestDropOldestWhenBufferIsFullOfFailures(t *testing.T) {
+	cfg := WriterConfig{
+		BatchSize:  2,
+		MaxRetries: 5,
+		Interval:   time.Hour,
+	}
+
+	allItems := make([][]any, 0)
+	w := NewWriter(cfg, func(ctx context.Context, items []any) []bool {
+		resp := make([]bool, len(items))
+		for idx := range resp {
+			resp[idx] = items[idx].(int) > 2
+		}
+
+		allItems = append(allItems, items)
+		return resp
+	})
+
+	w.Start()
+	w.Submit(1, 2, 3)
+	w.Stop()
+
+	assert.Equal(t, [][]any{{1, 2}, {2, 3}}, allItems)
+}
+
+func TestTimeout(t *testing.T) {
+	cfg := WriterConfig{
+		BatchSize:  2,
+		MaxRetries: 1,
+		Interval:   time.Hour,
+		Timeout:    time.Millisecond,
+	}
+
+	var deadline bool
+	w := NewWriter(cfg, func(ctx context.Context, items []any) []bool {
+		_, deadline = ctx.Deadline()
+		return make([]bool, len(items))
+	})
+
+	w.Start()
+	w.Submit(1)
+	w.Stop()
+
+	assert.True(t, deadline)
+}
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"sync"
 
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/metrics"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/sinks"
@@ -15,71 +16,179 @@ import (
 // This might not be the best way to implement such feature. A ring buffer can be better
 // and we might need a mechanism to drop the vents
 // On closing, the registry sends a signal on all exit channels, and then waits for all to complete.
+// Registering a name twice swaps the sink atomically: new events go to the new sink while the old one is drained of
+// the events already handed to it and closed in the background.
 type ChannelBasedReceiverRegistry struct {
-	ch           map[string]chan kube.EnhancedEvent
-	exitCh       map[string]chan any
-	wg           *sync.WaitGroup
+	mu           sync.RWMutex
+	receivers    map[string]*channelReceiver
+	wg           sync.WaitGroup
 	MetricsStore *metrics.Store
 }
 
+// channelReceiver is the per sink state of the ChannelBasedReceiverRegistry. inflight counts the events which are
+// handed to the receiver but not yet picked up by its loop, so that the receiver can be drained before closing.
+type channelReceiver struct {
+	ch       chan kube.EnhancedEvent
+	exitCh   chan any
+	inflight sync.WaitGroup
+}
+
 func (r *ChannelBasedReceiverRegistry) SendEvent(name string, event *kube.EnhancedEvent) {
-	ch := r.ch[name]
-	if ch == nil {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rcv := r.receivers[name]
+	if rcv == nil {
 		slog.With("name", name).Error("There is no channel")
+		return
 	}
 
+	ev := *event
+	rcv.inflight.Add(1)
 	go func() {
-		ch <- *event
+		defer rcv.inflight.Done()
+		rcv.ch <- ev
 	}()
 }
 
 func (r *ChannelBasedReceiverRegistry) Register(name string, receiver sinks.Sink) {
-	if r.ch == nil {
-		r.ch = make(map[string]chan kube.EnhancedEvent)
-		r.exitCh = make(map[string]chan any)
+	rcv := &channelReceiver{
+		ch:     make(chan kube.EnhancedEvent),
+		exitCh: make(chan any),
 	}
 
-	ch := make(chan kube.EnhancedEvent)
-	exitCh := make(chan any)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.run(name, rcv, receiver)
+	}()
 
-	r.ch[name] = ch
-	r.exitCh[name] = exitCh
+	r.mu.Lock()
+	if r.receivers == nil {
+		r.receivers = make(map[string]*channelReceiver)
+	}
+	old := r.receivers[name]
+	r.receivers[name] = rcv
+	r.mu.Unlock()
 
-	if r.wg == nil {
-		r.wg = &sync.WaitGroup{}
+	if old != nil {
+		slog.With("sink", name).Info("Replacing the sink")
+		go old.stop()
 	}
-	r.wg.Add(1)
+}
 
-	go func() {
-		l := slog.With("sink", name)
-	Loop:
-		for {
-			select {
-			case ev := <-ch:
-				l := l.With(slog.String("event", ev.Message))
-				l.Debug("sending event to sink")
-				err := receiver.Send(context.Background(), &ev)
-				if err != nil {
-					r.MetricsStore.SendErrors.Inc()
-					l.With(slog.Any("err", err)).Error("Cannot send event")
-				}
-			case <-exitCh:
-				l.Info("Closing the sink")
-				break Loop
+// Unregister removes the sink from the registry. The events already sent to it are delivered before it is closed.
+func (r *ChannelBasedReceiverRegistry) Unregister(name string) {
+	r.mu.Lock()
+	old := r.receivers[name]
+	delete(r.receivers, name)
+	r.mu.Unlock()
+
+	if old != nil {
+		go old.stop()
+	}
+}
+
+func (r *ChannelBasedReceiverRegistry) run(name string, rcv *channelReceiver, receiver sinks.Sink) {
+	l := slog.With("sink", name)
+
+	// The events of a batch sink are buffered and sent by the writer
+	var writer *batch.Writer
+	if bs, ok := receiver.(sinks.BatchSink); ok {
+		writer = batch.NewWriter(bs.BatchConfig(), func(ctx context.Context, items []any) []bool {
+			return r.sendBatch(ctx, name, l, bs, items)
+		})
+		writer.Start()
+	}
+
+Loop:
+	for {
+		select {
+		case ev := <-rcv.ch:
+			if writer != nil {
+				writer.Submit(&ev)
+				continue
 			}
+
+			l := l.With(slog.String("event", ev.Message))
+			l.Debug("sending event to sink")
+			err := receiver.Send(context.Background(), &ev)
+			if err != nil {
+				r.MetricsStore.SendErrors.Inc()
+				l.With(slog.Any("err", err)).Error("Cannot send event")
+			}
+		case <-rcv.exitCh:
+			l.Info("Closing the sink")
+			break Loop
 		}
-		receiver.Close()
-		l.Info("Closed")
-		r.wg.Done()
-	}()
+	}
+	if writer != nil {
+		writer.Stop()
+	}
+	receiver.Close()
+	l.Info("Closed")
+}
+
+// sendBatch sends a batch of events with the batch sink and tells which ones succeeded. The failed events are retried
+// by the writer, except for the permanently failed ones which are given up.
+func (r *ChannelBasedReceiverRegistry) sendBatch(ctx context.Context, name string, l *slog.Logger, bs sinks.BatchSink, items []any) []bool {
+	evs := make([]*kube.EnhancedEvent, len(items))
+	for i, item := range items {
+		evs[i] = item.(*kube.EnhancedEvent)
+	}
+
+	l.With(slog.Int("events", len(evs))).Debug("sending batch to sink")
+	errs := bs.SendBatch(ctx, evs)
+	results := make([]bool, len(evs))
+	failed := 0
+	for i, err := range errs {
+		if err == nil {
+			results[i] = true
+			continue
+		}
+
+		failed++
+		r.MetricsStore.SendErrors.Inc()
+		l.With(
+			slog.String("event", evs[i].Message),
+			slog.Any("err", err),
+		).Error("Cannot send event")
+		results[i] = sinks.IsPermanent(err)
+		if results[i] {
+			r.MetricsStore.BatchEventsRejected.WithLabelValues(name).Inc()
+		} else {
+			r.MetricsStore.BatchEventsRetried.WithLabelValues(name).Inc()
+		}
+	}
+
+	result := "partial"
+	switch failed {
+	case 0:
+		result = "success"
+	case len(evs):
+		result = "failure"
+	}
+	r.MetricsStore.BatchesSent.WithLabelValues(name, result).Inc()
+	return results
+}
+
+// stop waits until all the events handed to the receiver are picked up and then signals its loop to exit.
+func (c *channelReceiver) stop() {
+	c.inflight.Wait()
+	close(c.exitCh)
 }
 
 // Close signals closing to all sinks and waits for them to complete.
 // The wait could block indefinitely depending on the sink implementations.
 func (r *ChannelBasedReceiverRegistry) Close() {
-	// Send exit command and wait for exit of all sinks
-	for _, ec := range r.exitCh {
-		ec <- 1
+	r.mu.Lock()
+	receivers := r.receivers
+	r.receivers = nil
+	r.mu.Unlock()
+
+	// Send exit command and wait for exit of all sinks, including the ones replaced earlier
+	for _, rcv := range receivers {
+		go rcv.stop()
 	}
 	r.wg.Wait()
 }
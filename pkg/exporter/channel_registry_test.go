@@ -0,0 +1,142 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/metrics"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/sinks"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingSink counts the events it receives, it is safe to use from multiple goroutines
+type countingSink struct {
+	mu     sync.Mutex
+	count  int
+	closed bool
+}
+
+func (c *countingSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count++
+	return nil
+}
+
+func (c *countingSink) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+}
+
+func TestChannelRegistryReplaceDrainsOldSink(t *testing.T) {
+	metricsStore := metrics.NewMetricsStore("test_")
+	defer metrics.DestroyMetricsStore(metricsStore)
+
+	r := &ChannelBasedReceiverRegistry{MetricsStore: metricsStore}
+	old := &countingSink{}
+	replacement := &countingSink{}
+
+	r.Register("sink", old)
+	for i := 0; i < 100; i++ {
+		r.SendEvent("sink", &kube.EnhancedEvent{})
+	}
+
+	r.Register("sink", replacement)
+	for i := 0; i < 10; i++ {
+		r.SendEvent("sink", &kube.EnhancedEvent{})
+	}
+
+	r.Close()
+
+	assert.Equal(t, 100, old.count)
+	assert.True(t, old.closed)
+	assert.Equal(t, 10, replacement.count)
+	assert.True(t, replacement.closed)
+}
+
+func TestChannelRegistryUnregister(t *testing.T) {
+	metricsStore := metrics.NewMetricsStore("test_")
+	defer metrics.DestroyMetricsStore(metricsStore)
+
+	r := &ChannelBasedReceiverRegistry{MetricsStore: metricsStore}
+	sink := &countingSink{}
+
+	r.Register("sink", sink)
+	r.SendEvent("sink", &kube.EnhancedEvent{})
+	r.Unregister("sink")
+	r.SendEvent("sink", &kube.EnhancedEvent{})
+	r.Close()
+
+	assert.Equal(t, 1, sink.count)
+	assert.True(t, sink.closed)
+}
+
+// batchingSink fails the events with the messages in failures once, and permanently the ones in rejected
+type batchingSink struct {
+	countingSink
+	batches  [][]string
+	failures map[string]bool
+	rejected map[string]bool
+}
+
+func (b *batchingSink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	errs := make([]error, len(evs))
+	batch := make([]string, 0, len(evs))
+	for i, ev := range evs {
+		batch = append(batch, ev.Message)
+		if b.failures[ev.Message] {
+			delete(b.failures, ev.Message)
+			errs[i] = errors.New("try again")
+		}
+		if b.rejected[ev.Message] {
+			errs[i] = sinks.Permanent(errors.New("rejected"))
+		}
+	}
+	b.batches = append(b.batches, batch)
+	return errs
+}
+
+func (b *batchingSink) BatchConfig() batch.WriterConfig {
+	return batch.WriterConfig{BatchSize: 3, MaxRetries: 3, Interval: time.Hour}
+}
+
+func TestChannelRegistryBatchSink(t *testing.T) {
+	metricsStore := metrics.NewMetricsStore("test_")
+	defer metrics.DestroyMetricsStore(metricsStore)
+
+	r := &ChannelBasedReceiverRegistry{MetricsStore: metricsStore}
+	sink := &batchingSink{
+		failures: map[string]bool{"b": true},
+		rejected: map[string]bool{"c": true},
+	}
+	r.Register("sink", sink)
+
+	for _, message := range []string{"a", "b", "c", "d"} {
+		ev := &kube.EnhancedEvent{}
+		ev.Message = message
+		r.SendEvent("sink", ev)
+		// The events are handed over concurrently, wait to keep their order for the assertions
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.Close()
+
+	// The failed event is retried with the next batch, the rejected one is not
+	assert.Equal(t, [][]string{{"a", "b", "c"}, {"b", "d"}}, sink.batches)
+	assert.Equal(t, 0, sink.count)
+	assert.True(t, sink.closed)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metricsStore.SendErrors))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metricsStore.BatchesSent.WithLabelValues("sink", "partial")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metricsStore.BatchesSent.WithLabelValues("sink", "success")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metricsStore.BatchEventsRetried.WithLabelValues("sink")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metricsStore.BatchEventsRejected.WithLabelValues("sink")))
+}
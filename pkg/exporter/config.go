@@ -29,6 +29,9 @@ type Config struct {
 	Namespace          string                    `yaml:"namespace"`
 	LeaderElection     kube.LeaderElectionConfig `yaml:"leaderElection"`
 	Route              Route                     `yaml:"route"`
+	TimeIntervals      []TimeInterval            `yaml:"timeIntervals"`
+	Transforms         sinks.Transforms          `yaml:"transforms"`
+	Redaction          *Redaction                `yaml:"redaction"`
 	Receivers          []sinks.ReceiverConfig    `yaml:"receivers"`
 	KubeQPS            float32                   `yaml:"kubeQPS,omitempty"`
 	KubeBurst          int                       `yaml:"kubeBurst,omitempty"`
@@ -61,13 +64,57 @@ func (c *Config) Validate() error {
 	if err := c.validateMetricsNamePrefix(); err != nil {
 		return err
 	}
+	if err := c.Route.Validate(); err != nil {
+		return fmt.Errorf("invalid route: %w", err)
+	}
+	intervals, err := c.timeIntervals()
+	if err != nil {
+		return err
+	}
+	if err := c.Route.resolveTimeIntervals(intervals); err != nil {
+		return fmt.Errorf("invalid route: %w", err)
+	}
+
+	if c.Redaction != nil {
+		if err := c.Redaction.compile(); err != nil {
+			return fmt.Errorf("invalid redaction: %w", err)
+		}
+	}
+	if err := c.Transforms.Validate(); err != nil {
+		return fmt.Errorf("invalid transforms: %w", err)
+	}
 
 	// No duplicate receivers
 	// Receivers individually
-	// Routers recursive
+	for _, r := range c.Receivers {
+		if err := r.Transforms.Validate(); err != nil {
+			return fmt.Errorf("invalid receiver %s: %w", r.Name, err)
+		}
+		if r.Group != nil {
+			if err := r.Group.Validate(); err != nil {
+				return fmt.Errorf("invalid receiver %s: %w", r.Name, err)
+			}
+		}
+	}
 	return nil
 }
 
+// timeIntervals compiles the time intervals and returns them by name
+func (c *Config) timeIntervals() (map[string]*TimeInterval, error) {
+	intervals := make(map[string]*TimeInterval, len(c.TimeIntervals))
+	for i := range c.TimeIntervals {
+		interval := &c.TimeIntervals[i]
+		if err := interval.compile(); err != nil {
+			return nil, err
+		}
+		if _, ok := intervals[interval.Name]; ok {
+			return nil, fmt.Errorf("duplicate time interval %s", interval.Name)
+		}
+		intervals[interval.Name] = interval
+	}
+	return intervals, nil
+}
+
 func (c *Config) validateDefaults() error {
 	if err := c.validateMaxEventAgeSeconds(); err != nil {
 		return err
@@ -161,3 +161,22 @@ func TestSetDefaults(t *testing.T) {
 	require.Equal(t, rest.DefaultQPS, config.KubeQPS)
 	require.Equal(t, rest.DefaultBurst, config.KubeBurst)
 }
+
+func Test_ParseConfigRouteContinue(t *testing.T) {
+	const yml = `
+route:
+  routes:
+    - match:
+        - receiver: pager
+      continue: false
+    - match:
+        - receiver: dump
+`
+
+	cfg := readConfig(t, yml)
+
+	require.Len(t, cfg.Route.Routes, 2)
+	require.NotNil(t, cfg.Route.Routes[0].Continue)
+	assert.False(t, *cfg.Route.Routes[0].Continue)
+	assert.Nil(t, cfg.Route.Routes[1].Continue)
+}
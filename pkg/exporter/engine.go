@@ -2,21 +2,67 @@ package exporter
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
 	"reflect"
+	"sync"
 
+	"github.com/goccy/go-yaml"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/metrics"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/sinks"
 )
 
 // Engine is responsible for initializing the receivers from sinks
 type Engine struct {
-	Route    Route
-	Registry ReceiverRegistry
+	// Route is guarded by mu since it can be swapped by Reload while events are processed
+	Route        Route
+	Registry     ReceiverRegistry
+	MetricsStore *metrics.Store
+
+	mu sync.RWMutex
+	// receivers holds the serialized configuration of the registered receivers, keyed by name, so that Reload can
+	// tell which ones changed. Sinks may modify their configuration while initializing, so it is serialized before.
+	receivers map[string]string
+	// redaction and transforms are applied to all the events before routing, receiverTransforms before sending them
+	// to a receiver
+	redaction          *Redaction
+	transforms         sinks.Transforms
+	receiverTransforms map[string]sinks.Transforms
 }
 
-func NewEngine(config *Config, registry ReceiverRegistry) (*Engine, error) {
+func NewEngine(config *Config, registry ReceiverRegistry, metricsStore *metrics.Store) (*Engine, error) {
+	intervals, err := config.timeIntervals()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Engine{
+		Route:              config.Route,
+		Registry:           registry,
+		MetricsStore:       metricsStore,
+		receivers:          make(map[string]string),
+		redaction:          config.Redaction,
+		transforms:         config.Transforms,
+		receiverTransforms: receiverTransforms(config),
+	}
+	if e.redaction != nil {
+		if err := e.redaction.init(metricsStore); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.Route.resolveTimeIntervals(intervals); err != nil {
+		return nil, err
+	}
+	e.Route.init(metricsStore)
+
 	for _, v := range config.Receivers {
-		sink, err := v.GetSink()
+		fingerprint, err := receiverFingerprint(v)
+		if err != nil {
+			return nil, err
+		}
+
+		sink, err := v.GetSink(metricsStore)
 		if err != nil {
 			return nil, errors.New("Cannot initialize sink " + v.Name)
 		}
@@ -27,17 +73,129 @@ func NewEngine(config *Config, registry ReceiverRegistry) (*Engine, error) {
 		).Info("Registering sink")
 
 		registry.Register(v.Name, sink)
+		e.receivers[v.Name] = fingerprint
+	}
+
+	return e, nil
+}
+
+// Reload applies the route and the receivers of a new configuration to the running engine. Receivers with an
+// unchanged configuration keep running, even when only their transforms changed. Changed ones are swapped in the
+// registry and removed ones are unregistered.
+// If any of the new sinks cannot be initialized, nothing is changed and the old configuration keeps running.
+// Reload is not safe for concurrent use, the caller is expected to serialize the reloads.
+func (e *Engine) Reload(config *Config) error {
+	intervals, err := config.timeIntervals()
+	if err != nil {
+		return err
+	}
+	route := config.Route
+	if err := route.resolveTimeIntervals(intervals); err != nil {
+		return err
+	}
+	route.init(e.MetricsStore)
+	if config.Redaction != nil {
+		if err := config.Redaction.init(e.MetricsStore); err != nil {
+			return err
+		}
+	}
+
+	fingerprints := make(map[string]string, len(config.Receivers))
+	created := make(map[string]sinks.Sink)
+	closeCreated := func() {
+		for _, sink := range created {
+			sink.Close()
+		}
+	}
+
+	e.mu.RLock()
+	current := e.receivers
+	e.mu.RUnlock()
+
+	for _, v := range config.Receivers {
+		fingerprint, err := receiverFingerprint(v)
+		if err != nil {
+			closeCreated()
+			return err
+		}
+		fingerprints[v.Name] = fingerprint
+
+		if old, ok := current[v.Name]; ok && old == fingerprint {
+			continue
+		}
+
+		sink, err := v.GetSink(e.MetricsStore)
+		if err != nil {
+			closeCreated()
+			return fmt.Errorf("cannot initialize sink %s: %w", v.Name, err)
+		}
+		created[v.Name] = sink
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for name, sink := range created {
+		slog.With(
+			"name", name,
+			"type", reflect.TypeOf(sink).String(),
+		).Info("Registering sink")
+		e.Registry.Register(name, sink)
+	}
+
+	for name := range e.receivers {
+		if _, ok := fingerprints[name]; !ok {
+			slog.With("name", name).Info("Unregistering sink")
+			e.Registry.Unregister(name)
+		}
 	}
 
-	return &Engine{
-		Route:    config.Route,
-		Registry: registry,
-	}, nil
+	e.receivers = fingerprints
+	e.Route = route
+	e.redaction = config.Redaction
+	e.transforms = config.Transforms
+	e.receiverTransforms = receiverTransforms(config)
+	return nil
+}
+
+func receiverTransforms(config *Config) map[string]sinks.Transforms {
+	transforms := make(map[string]sinks.Transforms)
+	for _, v := range config.Receivers {
+		if len(v.Transforms) > 0 {
+			transforms[v.Name] = v.Transforms
+		}
+	}
+	return transforms
+}
+
+// receiverFingerprint serializes the configuration of the sink of a receiver. The transforms are left out since they
+// are applied before the sink and swapped without recreating it.
+func receiverFingerprint(cfg sinks.ReceiverConfig) (string, error) {
+	cfg.Transforms = nil
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("cannot serialize receiver %s: %w", cfg.Name, err)
+	}
+	return string(b), nil
 }
 
 // OnEvent does not care whether event is add or update. Prior filtering should be done in the controller/watcher
 func (e *Engine) OnEvent(event *kube.EnhancedEvent) {
-	e.Route.ProcessEvent(event, e.Registry)
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	ev := e.transforms.Apply(e.redaction.Apply(event))
+	e.Route.ProcessEvent(ev, transformingRegistry{ReceiverRegistry: e.Registry, transforms: e.receiverTransforms})
+}
+
+// transformingRegistry applies the transforms of the receivers to the events sent to them
+type transformingRegistry struct {
+	ReceiverRegistry
+	transforms map[string]sinks.Transforms
+}
+
+func (r transformingRegistry) SendEvent(name string, event *kube.EnhancedEvent) {
+	r.ReceiverRegistry.SendEvent(name, r.transforms[name].Apply(event))
 }
 
 // Stop stops all registered sinks
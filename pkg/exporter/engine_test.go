@@ -6,6 +6,7 @@ import (
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/sinks"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEngineNoRoutes(t *testing.T) {
@@ -14,7 +15,7 @@ func TestEngineNoRoutes(t *testing.T) {
 		Receivers: nil,
 	}
 
-	e, err := NewEngine(cfg, &SyncRegistry{})
+	e, err := NewEngine(cfg, &SyncRegistry{}, nil)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 		t.FailNow()
@@ -39,7 +40,7 @@ func TestEngineSimple(t *testing.T) {
 		}},
 	}
 
-	e, err := NewEngine(cfg, &SyncRegistry{})
+	e, err := NewEngine(cfg, &SyncRegistry{}, nil)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 		t.FailNow()
@@ -67,7 +68,7 @@ func TestEngineDropSimple(t *testing.T) {
 		}},
 	}
 
-	e, err := NewEngine(cfg, &SyncRegistry{})
+	e, err := NewEngine(cfg, &SyncRegistry{}, nil)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 		t.FailNow()
@@ -80,3 +81,161 @@ func TestEngineDropSimple(t *testing.T) {
 	assert.NotContains(t, config.Ref.Events, ev)
 	assert.Empty(t, config.Ref.Events)
 }
+
+func TestEngineReload(t *testing.T) {
+	kept := &sinks.InMemoryConfig{}
+	removed := &sinks.InMemoryConfig{}
+	cfg := &Config{
+		Route: Route{
+			Match: []Rule{{
+				Receiver: "kept",
+			}},
+		},
+		Receivers: []sinks.ReceiverConfig{{
+			Name:     "kept",
+			InMemory: kept,
+		}, {
+			Name:     "removed",
+			InMemory: removed,
+		}},
+	}
+
+	registry := &SyncRegistry{}
+	e, err := NewEngine(cfg, registry, nil)
+	require.NoError(t, err)
+
+	added := &sinks.InMemoryConfig{}
+	err = e.Reload(&Config{
+		Route: Route{
+			Match: []Rule{{
+				Receiver: "kept",
+			}, {
+				Receiver: "added",
+			}},
+		},
+		Receivers: []sinks.ReceiverConfig{{
+			Name:     "kept",
+			InMemory: &sinks.InMemoryConfig{},
+		}, {
+			Name:     "added",
+			InMemory: added,
+		}},
+	})
+	require.NoError(t, err)
+
+	ev := &kube.EnhancedEvent{}
+	e.OnEvent(ev)
+
+	// The unchanged receiver keeps its sink, so the event ends up in the sink created initially
+	assert.Contains(t, kept.Ref.Events, ev)
+	assert.Contains(t, added.Ref.Events, ev)
+	assert.Empty(t, removed.Ref.Events)
+	assert.NotContains(t, registry.reg, "removed")
+}
+
+func TestEngineReloadKeepsRunningConfigOnError(t *testing.T) {
+	config := &sinks.InMemoryConfig{}
+	cfg := &Config{
+		Route: Route{
+			Match: []Rule{{
+				Receiver: "in-mem",
+			}},
+		},
+		Receivers: []sinks.ReceiverConfig{{
+			Name:     "in-mem",
+			InMemory: config,
+		}},
+	}
+
+	e, err := NewEngine(cfg, &SyncRegistry{}, nil)
+	require.NoError(t, err)
+
+	err = e.Reload(&Config{
+		Route: Route{},
+		Receivers: []sinks.ReceiverConfig{{
+			// No sink is configured
+			Name: "broken",
+		}},
+	})
+	assert.Error(t, err)
+
+	ev := &kube.EnhancedEvent{}
+	e.OnEvent(ev)
+	assert.Contains(t, config.Ref.Events, ev)
+}
+
+func TestEngineTransforms(t *testing.T) {
+	cfg := readConfig(t, `
+transforms:
+  - dropAnnotations: [kubectl.kubernetes.io/last-applied-configuration]
+route:
+  match:
+    - receiver: raw
+    - receiver: short
+receivers:
+  - name: raw
+    inMemory: {}
+  - name: short
+    inMemory: {}
+    transforms:
+      - truncateMessage: 5
+`)
+	require.NoError(t, cfg.Validate())
+
+	e, err := NewEngine(&cfg, &SyncRegistry{}, nil)
+	require.NoError(t, err)
+
+	ev := &kube.EnhancedEvent{}
+	ev.Message = "Back-off restarting failed container"
+	ev.InvolvedObject.Annotations = map[string]string{"kubectl.kubernetes.io/last-applied-configuration": "{}"}
+	e.OnEvent(ev)
+
+	raw := cfg.Receivers[0].InMemory.Ref.Events
+	require.Len(t, raw, 1)
+	assert.Equal(t, "Back-off restarting failed container", raw[0].Message)
+	assert.Empty(t, raw[0].InvolvedObject.Annotations)
+
+	short := cfg.Receivers[1].InMemory.Ref.Events
+	require.Len(t, short, 1)
+	assert.Equal(t, "Back-", short[0].Message)
+	assert.Empty(t, short[0].InvolvedObject.Annotations)
+}
+
+func TestEngineReloadTransformsKeepsSink(t *testing.T) {
+	cfg := readConfig(t, `
+route:
+  match:
+    - receiver: short
+receivers:
+  - name: short
+    inMemory: {}
+    transforms:
+      - truncateMessage: 5
+`)
+	require.NoError(t, cfg.Validate())
+	e, err := NewEngine(&cfg, &SyncRegistry{}, nil)
+	require.NoError(t, err)
+
+	reloaded := readConfig(t, `
+route:
+  match:
+    - receiver: short
+receivers:
+  - name: short
+    inMemory: {}
+    transforms:
+      - truncateMessage: 8
+`)
+	require.NoError(t, reloaded.Validate())
+	require.NoError(t, e.Reload(&reloaded))
+
+	ev := &kube.EnhancedEvent{}
+	ev.Message = "Back-off restarting failed container"
+	e.OnEvent(ev)
+
+	// The sink created initially is kept and gets the events with the new transforms
+	events := cfg.Receivers[0].InMemory.Ref.Events
+	require.Len(t, events, 1)
+	assert.Equal(t, "Back-off", events[0].Message)
+	assert.Empty(t, reloaded.Receivers[0].InMemory.Ref)
+}
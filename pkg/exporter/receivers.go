@@ -5,9 +5,11 @@ import (
 	"github.com/resmoio/kubernetes-event-exporter/pkg/sinks"
 )
 
-// ReceiverRegistry registers a receiver with the appropriate sink
+// ReceiverRegistry registers a receiver with the appropriate sink. Registering an already registered name replaces
+// its sink, which allows reloading the receivers without restarting.
 type ReceiverRegistry interface {
 	SendEvent(string, *kube.EnhancedEvent)
 	Register(string, sinks.Sink)
+	Unregister(string)
 	Close()
 }
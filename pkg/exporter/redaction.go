@@ -0,0 +1,186 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/metrics"
+)
+
+const DefaultRedactionReplacement = "[REDACTED]"
+
+// builtinDetectors are the detectors which can be enabled by name. The replacements keep the non-secret parts, such
+// as the scheme of a URL, and $replacement stands for the configured replacement.
+var builtinDetectors = map[string][]struct {
+	regex       string
+	replacement string
+}{
+	"bearerToken": {
+		{regex: `(?i)(\bbearer\s+)[A-Za-z0-9\-._~+/]+=*`, replacement: "${1}$replacement"},
+	},
+	"awsKey": {
+		{regex: `\b(?:AKIA|ASIA|ABIA|ACCA)[0-9A-Z]{16}\b`, replacement: "$replacement"},
+		{regex: `(?i)(aws_secret_access_key["']?\s*[=:]\s*["']?)[A-Za-z0-9/+=]{40}`, replacement: "${1}$replacement"},
+	},
+	"urlUserinfo": {
+		{regex: `(\b[a-zA-Z][a-zA-Z0-9+.\-]*://)[^/\s@]+@`, replacement: "${1}$replacement@"},
+	},
+	"email": {
+		{regex: `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`, replacement: "$replacement"},
+	},
+}
+
+// builtinDetectorOrder is the order the detectors are applied in. The URLs go before the emails since the userinfo
+// of a URL looks like an email address.
+var builtinDetectorOrder = []string{"bearerToken", "awsKey", "urlUserinfo", "email"}
+
+// Redaction removes secrets and personal data from the message and the annotations of the events before they are
+// routed, so that no sink receives them.
+type Redaction struct {
+	// Detectors are the names of the built-in detectors to use, all of them by default
+	Detectors []string `yaml:"detectors"`
+	// Rules are custom regular expressions to redact
+	Rules []RedactionRule `yaml:"rules"`
+	// Replacement is what the redacted values are replaced with, [REDACTED] by default
+	Replacement string `yaml:"replacement"`
+
+	redactors    []redactor
+	metricsStore *metrics.Store
+}
+
+// RedactionRule redacts the matches of Regex. Replacement can refer to the groups such as ${1} and defaults to the
+// replacement of the redaction.
+type RedactionRule struct {
+	Name        string `yaml:"name"`
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+}
+
+type redactor struct {
+	name        string
+	re          *regexp.Regexp
+	replacement string
+}
+
+// compile builds the redactors of the detectors and rules
+func (r *Redaction) compile() error {
+	replacement := r.Replacement
+	if replacement == "" {
+		replacement = DefaultRedactionReplacement
+	}
+	// The dollar signs of the replacement are escaped, otherwise they would be expanded as groups
+	literal := strings.ReplaceAll(replacement, "$", "$$")
+	expand := func(s string) string {
+		return strings.ReplaceAll(s, "$replacement", literal)
+	}
+
+	detectors := r.Detectors
+	if detectors == nil {
+		detectors = builtinDetectorOrder
+	}
+
+	r.redactors = nil
+	for _, name := range builtinDetectorOrder {
+		if !slices.Contains(detectors, name) {
+			continue
+		}
+		for _, d := range builtinDetectors[name] {
+			r.redactors = append(r.redactors, redactor{
+				name:        name,
+				re:          regexp.MustCompile(d.regex),
+				replacement: expand(d.replacement),
+			})
+		}
+	}
+	for _, d := range detectors {
+		if _, ok := builtinDetectors[d]; !ok {
+			return fmt.Errorf("unknown redaction detector %q", d)
+		}
+	}
+
+	for _, rule := range r.Rules {
+		if rule.Name == "" {
+			return errors.New("redaction rule name cannot be empty")
+		}
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return fmt.Errorf("redaction rule %s: invalid regex: %w", rule.Name, err)
+		}
+		ruleReplacement := rule.Replacement
+		if ruleReplacement == "" {
+			ruleReplacement = literal
+		}
+		r.redactors = append(r.redactors, redactor{
+			name:        rule.Name,
+			re:          re,
+			replacement: ruleReplacement,
+		})
+	}
+	return nil
+}
+
+func (r *Redaction) init(metricsStore *metrics.Store) error {
+	r.metricsStore = metricsStore
+	return r.compile()
+}
+
+// Apply returns a redacted copy of the event, or the event itself if there is nothing to redact
+func (r *Redaction) Apply(ev *kube.EnhancedEvent) *kube.EnhancedEvent {
+	if r == nil || len(r.redactors) == 0 {
+		return ev
+	}
+
+	var c *kube.EnhancedEvent
+	copyEvent := func() {
+		if c == nil {
+			e := *ev
+			e.Annotations = maps.Clone(ev.Annotations)
+			e.InvolvedObject.Annotations = maps.Clone(ev.InvolvedObject.Annotations)
+			c = &e
+		}
+	}
+
+	if message, ok := r.redact(ev.Message); ok {
+		copyEvent()
+		c.Message = message
+	}
+	for key, value := range ev.Annotations {
+		if redacted, ok := r.redact(value); ok {
+			copyEvent()
+			c.Annotations[key] = redacted
+		}
+	}
+	for key, value := range ev.InvolvedObject.Annotations {
+		if redacted, ok := r.redact(value); ok {
+			copyEvent()
+			c.InvolvedObject.Annotations[key] = redacted
+		}
+	}
+
+	if c == nil {
+		return ev
+	}
+	return c
+}
+
+// redact applies all the redactors to the value and tells whether anything was redacted
+func (r *Redaction) redact(value string) (string, bool) {
+	redacted := false
+	for _, rd := range r.redactors {
+		matches := len(rd.re.FindAllStringIndex(value, -1))
+		if matches == 0 {
+			continue
+		}
+		value = rd.re.ReplaceAllString(value, rd.replacement)
+		redacted = true
+		if r.metricsStore != nil {
+			r.metricsStore.Redactions.WithLabelValues(rd.name).Add(float64(matches))
+		}
+	}
+	return value, redacted
+}
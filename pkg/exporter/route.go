@@ -1,6 +1,11 @@
 package exporter
 
-import "github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+import (
+	"fmt"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/metrics"
+)
 
 // Route allows using rules to drop events or match events to specific receivers.
 // It also allows using routes recursively for complex route building to fit
@@ -9,33 +14,148 @@ type Route struct {
 	Drop   []Rule
 	Match  []Rule
 	Routes []Route
+	// Continue tells whether the sibling routes after this one are evaluated when this route matches an event.
+	// It defaults to true, setting it to false gives the first-match semantics of the Alertmanager routing tree.
+	Continue *bool
+	// Throttle limits the events matching the route, both for its receivers and its sub-routes, and the events matching
+	// only some of its rules for the receivers of these rules
+	Throttle *Throttle
+	// ActiveTimeIntervals limits the route to the named time intervals, MuteTimeIntervals excludes them. Outside the
+	// active intervals, the route still matches the events but does not pass them on.
+	ActiveTimeIntervals []string `yaml:"activeTimeIntervals"`
+	MuteTimeIntervals   []string `yaml:"muteTimeIntervals"`
+
+	timeConditions timeConditions
 }
 
-func (r *Route) ProcessEvent(ev *kube.EnhancedEvent, registry ReceiverRegistry) {
+// ProcessEvent sends the event to the receivers of the matching rules and down to the sub-routes. It returns whether
+// the route matched the event, that is the event is not dropped and all the match rules are satisfied.
+func (r *Route) ProcessEvent(ev *kube.EnhancedEvent, registry ReceiverRegistry) bool {
 	// First determine whether we will drop the event: If any of the drop is matched, we break the loop
 	for _, v := range r.Drop {
 		if v.MatchesEvent(ev) {
-			return
+			return false
 		}
 	}
 
 	// It has match rules, it should go to the matchers
 	matchesAll := true
-	for _, rule := range r.Match {
-		if rule.MatchesEvent(ev) {
-			if rule.Receiver != "" {
-				registry.SendEvent(rule.Receiver, ev)
-				// Send the event down the hole
-			}
+	matched := make([]int, 0, len(r.Match))
+	for i := range r.Match {
+		if r.Match[i].MatchesEvent(ev) {
+			matched = append(matched, i)
 		} else {
 			matchesAll = false
 		}
 	}
 
+	// A muted route still matches the event, it just does not pass it on, not even to the rules matched
+	if !r.timeConditions.isActive() {
+		return matchesAll
+	}
+
+	// A throttled route still matches the event, it just does not pass it on, not even to the rules matched. The events
+	// which go nowhere are not counted.
+	if r.Throttle != nil && (matchesAll || len(matched) > 0) {
+		var allowed bool
+		if allowed, ev = r.Throttle.Allow(ev); !allowed {
+			return matchesAll
+		}
+	}
+
+	for _, i := range matched {
+		rule := r.Match[i]
+		if rule.Receiver == "" {
+			continue
+		}
+
+		toSend := ev
+		if rule.Throttle != nil {
+			var allowed bool
+			if allowed, toSend = rule.Throttle.Allow(ev); !allowed {
+				continue
+			}
+		}
+
+		// Send the event down the hole
+		registry.SendEvent(rule.Receiver, toSend)
+	}
+
 	// If all matches are satisfied, we can send them down to the rabbit hole
 	if matchesAll {
 		for _, subRoute := range r.Routes {
-			subRoute.ProcessEvent(ev, registry)
+			// Stop at the first matching sub-route which does not want the siblings to be evaluated
+			if subRoute.ProcessEvent(ev, registry) && !subRoute.continues() {
+				break
+			}
+		}
+	}
+
+	return matchesAll
+}
+
+func (r *Route) continues() bool {
+	return r.Continue == nil || *r.Continue
+}
+
+// init prepares the stateful parts of the route tree before processing events
+func (r *Route) init(metricsStore *metrics.Store) {
+	if r.Throttle != nil {
+		r.Throttle.init(metricsStore)
+	}
+	for _, rule := range r.Match {
+		if rule.Throttle != nil {
+			rule.Throttle.init(metricsStore)
+		}
+	}
+	for i := range r.Routes {
+		r.Routes[i].init(metricsStore)
+	}
+}
+
+// resolveTimeIntervals looks up the named time intervals of the route tree
+func (r *Route) resolveTimeIntervals(intervals map[string]*TimeInterval) error {
+	if err := r.timeConditions.resolve(intervals, r.ActiveTimeIntervals, r.MuteTimeIntervals); err != nil {
+		return err
+	}
+	for i := range r.Drop {
+		rule := &r.Drop[i]
+		if err := rule.timeConditions.resolve(intervals, rule.ActiveTimeIntervals, rule.MuteTimeIntervals); err != nil {
+			return fmt.Errorf("drop rule: %w", err)
+		}
+	}
+	for i := range r.Match {
+		rule := &r.Match[i]
+		if err := rule.timeConditions.resolve(intervals, rule.ActiveTimeIntervals, rule.MuteTimeIntervals); err != nil {
+			return fmt.Errorf("rule for receiver %q: %w", rule.Receiver, err)
+		}
+	}
+	for i := range r.Routes {
+		if err := r.Routes[i].resolveTimeIntervals(intervals); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the route tree recursively
+func (r *Route) Validate() error {
+	if r.Throttle != nil {
+		if err := r.Throttle.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, rule := range r.Match {
+		if rule.Throttle != nil {
+			if err := rule.Throttle.Validate(); err != nil {
+				return fmt.Errorf("rule for receiver %q: %w", rule.Receiver, err)
+			}
+		}
+	}
+	for i := range r.Routes {
+		if err := r.Routes[i].Validate(); err != nil {
+			return err
 		}
 	}
+	return nil
 }
@@ -1,10 +1,13 @@
 package exporter
 
 import (
+	"testing"
+
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/sinks"
 	"github.com/stretchr/testify/assert"
-	"testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // testReceiverRegistry just records the events to the registry so that tests can validate routing behavior
@@ -16,6 +19,10 @@ func (t *testReceiverRegistry) Register(string, sinks.Sink) {
 	panic("Why do you call this? It's for counting imaginary events for tests only")
 }
 
+func (t *testReceiverRegistry) Unregister(string) {
+	panic("Why do you call this? It's for counting imaginary events for tests only")
+}
+
 func (t *testReceiverRegistry) SendEvent(name string, event *kube.EnhancedEvent) {
 	if t.rcvd == nil {
 		t.rcvd = make(map[string][]*kube.EnhancedEvent)
@@ -223,3 +230,165 @@ func Test_GHIssue51(t *testing.T) {
 	assert.True(t, reg.isEventRcvd("elastic", &ev1))
 	assert.False(t, reg.isEventRcvd("elastic", &ev2))
 }
+
+func TestRouteContinue(t *testing.T) {
+	stop := false
+	proceed := true
+
+	tests := []struct {
+		name     string
+		ev       kube.EnhancedEvent
+		route    Route
+		expected map[string]int
+	}{
+		{
+			name: "siblings are evaluated by default",
+			route: Route{
+				Routes: []Route{
+					{Match: []Rule{{Receiver: "first"}}},
+					{Match: []Rule{{Receiver: "second"}}},
+				},
+			},
+			expected: map[string]int{"first": 1, "second": 1},
+		},
+		{
+			name: "explicit continue evaluates siblings",
+			route: Route{
+				Routes: []Route{
+					{Match: []Rule{{Receiver: "first"}}, Continue: &proceed},
+					{Match: []Rule{{Receiver: "second"}}},
+				},
+			},
+			expected: map[string]int{"first": 1, "second": 1},
+		},
+		{
+			name: "first match stops the siblings",
+			route: Route{
+				Routes: []Route{
+					{Match: []Rule{{Receiver: "first"}}, Continue: &stop},
+					{Match: []Rule{{Receiver: "second"}}},
+				},
+			},
+			expected: map[string]int{"first": 1},
+		},
+		{
+			name: "non matching route does not stop the siblings",
+			ev:   kube.EnhancedEvent{Event: corev1.Event{Type: "Normal"}},
+			route: Route{
+				Routes: []Route{
+					{Match: []Rule{{Type: "Warning", Receiver: "pager"}}, Continue: &stop},
+					{Match: []Rule{{Receiver: "chat"}}, Continue: &stop},
+					{Match: []Rule{{Receiver: "never"}}},
+				},
+			},
+			expected: map[string]int{"chat": 1},
+		},
+		{
+			name: "dropped route does not stop the siblings",
+			ev:   kube.EnhancedEvent{Event: corev1.Event{Type: "Normal"}},
+			route: Route{
+				Routes: []Route{
+					{Drop: []Rule{{Type: "Normal"}}, Match: []Rule{{Receiver: "pager"}}, Continue: &stop},
+					{Match: []Rule{{Receiver: "chat"}}},
+				},
+			},
+			expected: map[string]int{"chat": 1},
+		},
+		{
+			name: "partially matching route does not stop the siblings",
+			ev:   kube.EnhancedEvent{Event: corev1.Event{Type: "Normal"}},
+			route: Route{
+				Routes: []Route{
+					{Match: []Rule{{Receiver: "all"}, {Type: "Warning", Receiver: "pager"}}, Continue: &stop},
+					{Match: []Rule{{Receiver: "chat"}}},
+				},
+			},
+			expected: map[string]int{"all": 1, "chat": 1},
+		},
+		{
+			name: "stop only applies to the siblings in the same level",
+			ev:   kube.EnhancedEvent{Event: corev1.Event{Type: "Warning"}},
+			route: Route{
+				Routes: []Route{
+					{
+						Match: []Rule{{Type: "Warning"}},
+						Routes: []Route{
+							{Match: []Rule{{Receiver: "pager"}}, Continue: &stop},
+							{Match: []Rule{{Receiver: "never"}}},
+						},
+					},
+					{Match: []Rule{{Receiver: "dump"}}},
+				},
+			},
+			expected: map[string]int{"pager": 1, "dump": 1},
+		},
+		{
+			name: "nested tree with first match on every level",
+			ev: kube.EnhancedEvent{Event: corev1.Event{
+				Type:       "Warning",
+				Reason:     "BackOff",
+				ObjectMeta: metav1.ObjectMeta{Namespace: "payments"},
+			}},
+			route: Route{
+				Routes: []Route{
+					{
+						Match:    []Rule{{Namespace: "payments"}},
+						Continue: &stop,
+						Routes: []Route{
+							{Match: []Rule{{Reason: "FailedScheduling", Receiver: "payments-scheduling"}}, Continue: &stop},
+							{Match: []Rule{{Type: "Warning", Receiver: "payments-pager"}}, Continue: &stop},
+							{Match: []Rule{{Receiver: "payments-chat"}}},
+						},
+					},
+					{Match: []Rule{{Receiver: "default"}}},
+				},
+			},
+			expected: map[string]int{"payments-pager": 1},
+		},
+		{
+			name: "nested tree falls through to the default route",
+			ev: kube.EnhancedEvent{Event: corev1.Event{
+				Type:       "Warning",
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+			}},
+			route: Route{
+				Routes: []Route{
+					{
+						Match:    []Rule{{Namespace: "payments"}},
+						Continue: &stop,
+						Routes: []Route{
+							{Match: []Rule{{Receiver: "payments-chat"}}},
+						},
+					},
+					{Match: []Rule{{Receiver: "default"}}},
+				},
+			},
+			expected: map[string]int{"default": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := testReceiverRegistry{}
+			ev := tt.ev
+
+			tt.route.ProcessEvent(&ev, &reg)
+
+			actual := make(map[string]int)
+			for name := range reg.rcvd {
+				actual[name] = reg.count(name)
+			}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestProcessEventReturnsMatch(t *testing.T) {
+	ev := kube.EnhancedEvent{}
+	ev.Namespace = "kube-system"
+	reg := testReceiverRegistry{}
+
+	assert.True(t, (&Route{Match: []Rule{{Namespace: "kube-system"}}}).ProcessEvent(&ev, &reg))
+	assert.False(t, (&Route{Match: []Rule{{Namespace: "default"}}}).ProcessEvent(&ev, &reg))
+	assert.False(t, (&Route{Drop: []Rule{{Namespace: "kube-system"}}}).ProcessEvent(&ev, &reg))
+}
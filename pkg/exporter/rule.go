@@ -28,6 +28,13 @@ type Rule struct {
 	Component   string
 	Host        string
 	Receiver    string
+	// Throttle limits the events sent to the receiver of a match rule. It is not used for the drop rules.
+	Throttle *Throttle `yaml:"throttle"`
+	// ActiveTimeIntervals limits the rule to the named time intervals, MuteTimeIntervals excludes them
+	ActiveTimeIntervals []string `yaml:"activeTimeIntervals"`
+	MuteTimeIntervals   []string `yaml:"muteTimeIntervals"`
+
+	timeConditions timeConditions
 }
 
 // MatchesEvent compares the rule to an event and returns a boolean value to indicate
@@ -90,6 +97,10 @@ func (r *Rule) MatchesEvent(ev *kube.EnhancedEvent) bool {
 		return false
 	}
 
+	if !r.timeConditions.isActive() {
+		return false
+	}
+
 	// If it failed every step, it must match because our matchers are limiting
 	return true
 }
@@ -3,6 +3,7 @@ package exporter
 import (
 	"context"
 	"log/slog"
+	"sync"
 
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/sinks"
@@ -11,11 +12,21 @@ import (
 // SyncRegistry is for development purposes and performs poorly and blocks when an event is received so it is
 // not suited for high volume & production workloads
 type SyncRegistry struct {
+	mu  sync.RWMutex
 	reg map[string]sinks.Sink
 }
 
 func (s *SyncRegistry) SendEvent(name string, event *kube.EnhancedEvent) {
-	err := s.reg[name].Send(context.Background(), event)
+	s.mu.RLock()
+	sink := s.reg[name]
+	s.mu.RUnlock()
+
+	if sink == nil {
+		slog.With("sink", name).Error("There is no sink")
+		return
+	}
+
+	err := sink.Send(context.Background(), event)
 	if err != nil {
 		slog.With(
 			"sink", name,
@@ -26,14 +37,35 @@ func (s *SyncRegistry) SendEvent(name string, event *kube.EnhancedEvent) {
 }
 
 func (s *SyncRegistry) Register(name string, sink sinks.Sink) {
+	s.mu.Lock()
 	if s.reg == nil {
 		s.reg = make(map[string]sinks.Sink)
 	}
 
+	old := s.reg[name]
 	s.reg[name] = sink
+	s.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+}
+
+func (s *SyncRegistry) Unregister(name string) {
+	s.mu.Lock()
+	old := s.reg[name]
+	delete(s.reg, name)
+	s.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
 }
 
 func (s *SyncRegistry) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	for name, sink := range s.reg {
 		slog.With("sink", name).Info("Closing sink")
 		sink.Close()
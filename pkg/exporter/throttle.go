@@ -0,0 +1,121 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/metrics"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/sinks"
+)
+
+const (
+	DefaultThrottleKey       = "{{ .InvolvedObject.Namespace }}/{{ .InvolvedObject.Kind }}/{{ .InvolvedObject.Name }}/{{ .Reason }}"
+	DefaultThrottleCacheSize = 4096
+)
+
+// now is the clock used for the time based decisions, tests replace it to control the time
+var now = time.Now
+
+// Throttle limits the number of events with the same key which are let through within a window. The key is a
+// template rendered with the event, so that similar events can be deduplicated. The state is kept in an in-memory
+// LRU cache, so the least recently seen keys are forgotten when there are more keys than the cache size.
+type Throttle struct {
+	Key      string        `yaml:"key"`
+	Window   time.Duration `yaml:"window"`
+	MaxCount int           `yaml:"maxCount"`
+	// IncludeSuppressedCount sets the SuppressedCount of the first event let through after some are suppressed
+	IncludeSuppressedCount bool `yaml:"includeSuppressedCount"`
+	CacheSize              int  `yaml:"cacheSize"`
+
+	once         sync.Once
+	mu           sync.Mutex
+	state        *lru.Cache
+	metricsStore *metrics.Store
+}
+
+type throttleEntry struct {
+	windowStart time.Time
+	count       int
+	suppressed  int
+}
+
+func (t *Throttle) Validate() error {
+	if t.Window <= 0 {
+		return errors.New("throttle window must be positive")
+	}
+	if t.MaxCount <= 0 {
+		return errors.New("throttle maxCount must be positive")
+	}
+	if t.Key != "" {
+		if err := sinks.ValidateTemplate(t.Key); err != nil {
+			return fmt.Errorf("invalid throttle key: %w", err)
+		}
+	}
+	return nil
+}
+
+func (t *Throttle) init(metricsStore *metrics.Store) {
+	t.metricsStore = metricsStore
+}
+
+func (t *Throttle) setup() {
+	size := t.CacheSize
+	if size <= 0 {
+		size = DefaultThrottleCacheSize
+	}
+	// lru.New only fails for non-positive sizes
+	t.state, _ = lru.New(size)
+}
+
+// Allow tells whether the event is let through. When the suppressed events are requested to be included, the
+// returned event is a copy of the event with the SuppressedCount set, otherwise it is the event itself.
+func (t *Throttle) Allow(ev *kube.EnhancedEvent) (bool, *kube.EnhancedEvent) {
+	t.once.Do(t.setup)
+
+	keyTemplate := t.Key
+	if keyTemplate == "" {
+		keyTemplate = DefaultThrottleKey
+	}
+	key, err := sinks.GetString(ev, keyTemplate)
+	if err != nil {
+		// Better to be noisy than losing events
+		slog.With("err", err).Error("Cannot render the throttle key, not throttling")
+		return true, ev
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current := now()
+	entry := &throttleEntry{windowStart: current}
+	if v, ok := t.state.Get(key); ok {
+		entry = v.(*throttleEntry)
+	}
+	if current.Sub(entry.windowStart) >= t.Window {
+		entry.windowStart = current
+		entry.count = 0
+	}
+	t.state.Add(key, entry)
+
+	entry.count++
+	if entry.count > t.MaxCount {
+		entry.suppressed++
+		if t.metricsStore != nil {
+			t.metricsStore.EventsThrottled.Inc()
+		}
+		return false, ev
+	}
+
+	if t.IncludeSuppressedCount && entry.suppressed > 0 {
+		c := *ev
+		c.SuppressedCount = entry.suppressed
+		ev = &c
+	}
+	entry.suppressed = 0
+	return true, ev
+}
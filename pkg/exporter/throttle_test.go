@@ -0,0 +1,195 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setClock replaces the clock of the package until the end of the test
+func setClock(t *testing.T, current *time.Time) {
+	t.Cleanup(func() {
+		now = time.Now
+	})
+	now = func() time.Time {
+		return *current
+	}
+}
+
+func newThrottledEvent(name, reason string) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Reason = reason
+	ev.InvolvedObject.Namespace = "default"
+	ev.InvolvedObject.Kind = "Pod"
+	ev.InvolvedObject.Name = name
+	return ev
+}
+
+func TestThrottleWindow(t *testing.T) {
+	current := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	setClock(t, &current)
+
+	th := &Throttle{Window: time.Minute, MaxCount: 2}
+
+	for i := 0; i < 2; i++ {
+		allowed, _ := th.Allow(newThrottledEvent("web", "BackOff"))
+		assert.True(t, allowed)
+	}
+	allowed, _ := th.Allow(newThrottledEvent("web", "BackOff"))
+	assert.False(t, allowed)
+
+	// Other keys have their own budget
+	allowed, _ = th.Allow(newThrottledEvent("db", "BackOff"))
+	assert.True(t, allowed)
+	allowed, _ = th.Allow(newThrottledEvent("web", "Unhealthy"))
+	assert.True(t, allowed)
+
+	current = current.Add(time.Minute)
+	allowed, _ = th.Allow(newThrottledEvent("web", "BackOff"))
+	assert.True(t, allowed)
+}
+
+func TestThrottleIncludeSuppressedCount(t *testing.T) {
+	current := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	setClock(t, &current)
+
+	metricsStore := metrics.NewMetricsStore("test_")
+	defer metrics.DestroyMetricsStore(metricsStore)
+
+	th := &Throttle{
+		Key:                    "{{ .InvolvedObject.Name }}",
+		Window:                 time.Minute,
+		MaxCount:               1,
+		IncludeSuppressedCount: true,
+	}
+	th.init(metricsStore)
+
+	first := newThrottledEvent("web", "BackOff")
+	allowed, ev := th.Allow(first)
+	require.True(t, allowed)
+	assert.Same(t, first, ev)
+
+	for i := 0; i < 3; i++ {
+		allowed, _ = th.Allow(newThrottledEvent("web", "BackOff"))
+		assert.False(t, allowed)
+	}
+	assert.Equal(t, float64(3), testutil.ToFloat64(metricsStore.EventsThrottled))
+
+	current = current.Add(2 * time.Minute)
+	next := newThrottledEvent("web", "BackOff")
+	allowed, ev = th.Allow(next)
+	require.True(t, allowed)
+	assert.Equal(t, 3, ev.SuppressedCount)
+	// The original event is shared with other routes, so it must not be modified
+	assert.Equal(t, 0, next.SuppressedCount)
+
+	current = current.Add(2 * time.Minute)
+	allowed, ev = th.Allow(newThrottledEvent("web", "BackOff"))
+	require.True(t, allowed)
+	assert.Equal(t, 0, ev.SuppressedCount)
+}
+
+func TestRouteThrottle(t *testing.T) {
+	current := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	setClock(t, &current)
+	stop := false
+
+	r := Route{
+		Routes: []Route{{
+			Match: []Rule{{
+				Reason:   "BackOff",
+				Receiver: "pager",
+			}},
+			Throttle: &Throttle{Window: time.Minute, MaxCount: 1},
+			Continue: &stop,
+			Routes: []Route{{
+				Match: []Rule{{Receiver: "sub"}},
+			}},
+		}, {
+			Match: []Rule{{
+				Receiver: "slack",
+				Throttle: &Throttle{Window: time.Minute, MaxCount: 2},
+			}},
+		}},
+	}
+	reg := testReceiverRegistry{}
+
+	for i := 0; i < 5; i++ {
+		r.ProcessEvent(newThrottledEvent("web", "BackOff"), &reg)
+		r.ProcessEvent(newThrottledEvent("web", "Unhealthy"), &reg)
+	}
+
+	assert.Equal(t, 1, reg.count("pager"))
+	assert.Equal(t, 1, reg.count("sub"))
+	// Throttled BackOff events still match the first route, so only the Unhealthy ones get here
+	assert.Equal(t, 2, reg.count("slack"))
+}
+
+func TestRouteThrottlePartialMatch(t *testing.T) {
+	current := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	setClock(t, &current)
+
+	r := Route{
+		Routes: []Route{{
+			Match: []Rule{
+				{Reason: "BackOff", Receiver: "pager"},
+				{Type: "Warning", Receiver: "slack"},
+			},
+			Throttle: &Throttle{Window: time.Minute, MaxCount: 1},
+		}},
+	}
+	reg := testReceiverRegistry{}
+
+	// The Normal events only match the first rule, the route throttle applies to its receiver all the same
+	for i := 0; i < 5; i++ {
+		r.ProcessEvent(newThrottledEvent("web", "BackOff"), &reg)
+	}
+	assert.Equal(t, 1, reg.count("pager"))
+	assert.Equal(t, 0, reg.count("slack"))
+
+	current = current.Add(time.Minute)
+	r.ProcessEvent(newThrottledEvent("web", "BackOff"), &reg)
+	assert.Equal(t, 2, reg.count("pager"))
+}
+
+func TestThrottleValidate(t *testing.T) {
+	assert.NoError(t, (&Throttle{Window: time.Minute, MaxCount: 1}).Validate())
+	assert.Error(t, (&Throttle{MaxCount: 1}).Validate())
+	assert.Error(t, (&Throttle{Window: time.Minute}).Validate())
+	assert.Error(t, (&Throttle{Window: time.Minute, MaxCount: 1, Key: "{{ .Reason "}).Validate())
+}
+
+func Test_ParseConfigThrottle(t *testing.T) {
+	const yml = `
+route:
+  routes:
+    - match:
+        - receiver: slack
+          throttle:
+            key: "{{ .InvolvedObject.Name }}"
+            window: 10m
+            maxCount: 3
+      throttle:
+        window: 1h
+        maxCount: 100
+        includeSuppressedCount: true
+`
+
+	cfg := readConfig(t, yml)
+
+	require.NoError(t, cfg.Validate())
+	route := cfg.Route.Routes[0]
+	require.NotNil(t, route.Throttle)
+	assert.Equal(t, time.Hour, route.Throttle.Window)
+	assert.Equal(t, 100, route.Throttle.MaxCount)
+	assert.True(t, route.Throttle.IncludeSuppressedCount)
+	require.NotNil(t, route.Match[0].Throttle)
+	assert.Equal(t, "{{ .InvolvedObject.Name }}", route.Match[0].Throttle.Key)
+	assert.Equal(t, 10*time.Minute, route.Match[0].Throttle.Window)
+	assert.Equal(t, 3, route.Match[0].Throttle.MaxCount)
+}
@@ -0,0 +1,220 @@
+package exporter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeInterval is a named period of time which rules and routes refer to, such as business hours or a maintenance
+// window. A time is within the interval when it matches all the given conditions, empty conditions match any time.
+type TimeInterval struct {
+	Name string `yaml:"name"`
+	// Weekdays are day names or inclusive ranges of them, such as "monday:friday" or "saturday"
+	Weekdays []string `yaml:"weekdays"`
+	// Times are time of day ranges with an exclusive end. A range ending before its start spans midnight.
+	Times []TimeRange `yaml:"times"`
+	// Location is the IANA time zone the weekdays and times are in, it defaults to UTC
+	Location string `yaml:"location"`
+	// Start and End bound the interval to an absolute period in RFC 3339, for one-off maintenance windows
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+
+	location *time.Location
+	weekdays [7]bool
+	anyDay   bool
+	times    []minuteRange
+	start    time.Time
+	end      time.Time
+}
+
+// TimeRange is a time of day range in the 15:04 format, the end can be 24:00
+type TimeRange struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+type minuteRange struct {
+	start int
+	end   int
+}
+
+func (r minuteRange) contains(minute int) bool {
+	if r.start <= r.end {
+		return minute >= r.start && minute < r.end
+	}
+	return minute >= r.start || minute < r.end
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// compile parses and validates the conditions of the interval
+func (t *TimeInterval) compile() error {
+	if t.Name == "" {
+		return errors.New("time interval name cannot be empty")
+	}
+
+	t.location = time.UTC
+	if t.Location != "" {
+		loc, err := time.LoadLocation(t.Location)
+		if err != nil {
+			return fmt.Errorf("time interval %s: invalid location: %w", t.Name, err)
+		}
+		t.location = loc
+	}
+
+	t.weekdays = [7]bool{}
+	t.anyDay = len(t.Weekdays) == 0
+	for _, w := range t.Weekdays {
+		from, to, isRange := strings.Cut(strings.ToLower(w), ":")
+		if !isRange {
+			to = from
+		}
+		first, ok := weekdays[strings.TrimSpace(from)]
+		if !ok {
+			return fmt.Errorf("time interval %s: invalid weekday %q", t.Name, w)
+		}
+		last, ok := weekdays[strings.TrimSpace(to)]
+		if !ok {
+			return fmt.Errorf("time interval %s: invalid weekday %q", t.Name, w)
+		}
+		// Ranges wrap around the end of the week, such as saturday:sunday
+		for d := first; ; d = (d + 1) % 7 {
+			t.weekdays[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	t.times = make([]minuteRange, 0, len(t.Times))
+	for _, r := range t.Times {
+		start, err := parseMinuteOfDay(r.Start)
+		if err != nil {
+			return fmt.Errorf("time interval %s: invalid start time: %w", t.Name, err)
+		}
+		end, err := parseMinuteOfDay(r.End)
+		if err != nil {
+			return fmt.Errorf("time interval %s: invalid end time: %w", t.Name, err)
+		}
+		if start == end {
+			return fmt.Errorf("time interval %s: time range %s-%s is empty", t.Name, r.Start, r.End)
+		}
+		t.times = append(t.times, minuteRange{start: start, end: end})
+	}
+
+	t.start, t.end = time.Time{}, time.Time{}
+	if t.Start != "" {
+		start, err := time.Parse(time.RFC3339, t.Start)
+		if err != nil {
+			return fmt.Errorf("time interval %s: invalid start: %w", t.Name, err)
+		}
+		t.start = start
+	}
+	if t.End != "" {
+		end, err := time.Parse(time.RFC3339, t.End)
+		if err != nil {
+			return fmt.Errorf("time interval %s: invalid end: %w", t.Name, err)
+		}
+		t.end = end
+	}
+	if !t.start.IsZero() && !t.end.IsZero() && !t.end.After(t.start) {
+		return fmt.Errorf("time interval %s: end must be after start", t.Name)
+	}
+	return nil
+}
+
+// parseMinuteOfDay parses a time of day in the 15:04 format into the minutes since midnight
+func parseMinuteOfDay(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains tells whether the time is within the interval
+func (t *TimeInterval) Contains(current time.Time) bool {
+	if !t.start.IsZero() && current.Before(t.start) {
+		return false
+	}
+	if !t.end.IsZero() && !current.Before(t.end) {
+		return false
+	}
+
+	local := current.In(t.location)
+	if !t.anyDay && !t.weekdays[local.Weekday()] {
+		return false
+	}
+	if len(t.times) == 0 {
+		return true
+	}
+	minute := local.Hour()*60 + local.Minute()
+	for _, r := range t.times {
+		if r.contains(minute) {
+			return true
+		}
+	}
+	return false
+}
+
+// timeConditions holds the resolved time intervals of a rule or a route
+type timeConditions struct {
+	active []*TimeInterval
+	mute   []*TimeInterval
+}
+
+// resolve looks up the named intervals
+func (c *timeConditions) resolve(intervals map[string]*TimeInterval, active, mute []string) error {
+	c.active, c.mute = nil, nil
+	for _, name := range active {
+		interval, ok := intervals[name]
+		if !ok {
+			return fmt.Errorf("unknown time interval %q", name)
+		}
+		c.active = append(c.active, interval)
+	}
+	for _, name := range mute {
+		interval, ok := intervals[name]
+		if !ok {
+			return fmt.Errorf("unknown time interval %q", name)
+		}
+		c.mute = append(c.mute, interval)
+	}
+	return nil
+}
+
+// isActive tells whether the current time is within any of the active intervals, if there are any, and outside all the
+// mute intervals
+func (c *timeConditions) isActive() bool {
+	if len(c.active) == 0 && len(c.mute) == 0 {
+		return true
+	}
+
+	current := now()
+	if len(c.active) > 0 && !anyContains(c.active, current) {
+		return false
+	}
+	return !anyContains(c.mute, current)
+}
+
+func anyContains(intervals []*TimeInterval, current time.Time) bool {
+	for _, interval := range intervals {
+		if interval.Contains(current) {
+			return true
+		}
+	}
+	return false
+}
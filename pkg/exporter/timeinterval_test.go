@@ -0,0 +1,258 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeIntervalContains(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval TimeInterval
+		time     time.Time
+		contains bool
+	}{
+		{
+			name:     "empty interval",
+			interval: TimeInterval{},
+			time:     time.Date(2024, 1, 6, 3, 0, 0, 0, time.UTC),
+			contains: true,
+		},
+		{
+			name:     "weekday range",
+			interval: TimeInterval{Weekdays: []string{"monday:friday"}},
+			time:     time.Date(2024, 1, 3, 3, 0, 0, 0, time.UTC), // Wednesday
+			contains: true,
+		},
+		{
+			name:     "outside weekday range",
+			interval: TimeInterval{Weekdays: []string{"monday:friday"}},
+			time:     time.Date(2024, 1, 6, 3, 0, 0, 0, time.UTC), // Saturday
+			contains: false,
+		},
+		{
+			name:     "weekday range over the end of the week",
+			interval: TimeInterval{Weekdays: []string{"Saturday:Sunday"}},
+			time:     time.Date(2024, 1, 7, 3, 0, 0, 0, time.UTC), // Sunday
+			contains: true,
+		},
+		{
+			name:     "business hours",
+			interval: TimeInterval{Times: []TimeRange{{Start: "09:00", End: "17:00"}}},
+			time:     time.Date(2024, 1, 3, 16, 59, 0, 0, time.UTC),
+			contains: true,
+		},
+		{
+			name:     "end is exclusive",
+			interval: TimeInterval{Times: []TimeRange{{Start: "09:00", End: "17:00"}}},
+			time:     time.Date(2024, 1, 3, 17, 0, 0, 0, time.UTC),
+			contains: false,
+		},
+		{
+			name:     "time range over midnight",
+			interval: TimeInterval{Times: []TimeRange{{Start: "22:00", End: "06:00"}}},
+			time:     time.Date(2024, 1, 3, 2, 0, 0, 0, time.UTC),
+			contains: true,
+		},
+		{
+			name: "business hours in the location",
+			interval: TimeInterval{
+				Weekdays: []string{"monday:friday"},
+				Times:    []TimeRange{{Start: "09:00", End: "17:00"}},
+				Location: "America/New_York",
+			},
+			time:     time.Date(2024, 1, 3, 15, 0, 0, 0, time.UTC), // 10:00 in New York
+			contains: true,
+		},
+		{
+			name: "outside business hours in the location",
+			interval: TimeInterval{
+				Weekdays: []string{"monday:friday"},
+				Times:    []TimeRange{{Start: "09:00", End: "17:00"}},
+				Location: "America/New_York",
+			},
+			time:     time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC), // 05:00 in New York
+			contains: false,
+		},
+		{
+			name:     "maintenance window",
+			interval: TimeInterval{Start: "2024-01-03T10:00:00Z", End: "2024-01-03T12:00:00Z"},
+			time:     time.Date(2024, 1, 3, 11, 0, 0, 0, time.UTC),
+			contains: true,
+		},
+		{
+			name:     "after maintenance window",
+			interval: TimeInterval{Start: "2024-01-03T10:00:00Z", End: "2024-01-03T12:00:00Z"},
+			time:     time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC),
+			contains: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.interval.Name = "test"
+			require.NoError(t, test.interval.compile())
+			assert.Equal(t, test.contains, test.interval.Contains(test.time))
+		})
+	}
+}
+
+func TestTimeIntervalCompileErrors(t *testing.T) {
+	intervals := []TimeInterval{
+		{},
+		{Name: "x", Weekdays: []string{"someday"}},
+		{Name: "x", Weekdays: []string{"monday:friyay"}},
+		{Name: "x", Times: []TimeRange{{Start: "9am", End: "17:00"}}},
+		{Name: "x", Times: []TimeRange{{Start: "09:00", End: "09:00"}}},
+		{Name: "x", Location: "Mars/Olympus_Mons"},
+		{Name: "x", Start: "2024-01-03"},
+		{Name: "x", Start: "2024-01-03T12:00:00Z", End: "2024-01-03T10:00:00Z"},
+	}
+
+	for _, interval := range intervals {
+		assert.Error(t, interval.compile(), "%+v", interval)
+	}
+}
+
+func TestTimeIntervalRoute(t *testing.T) {
+	current := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC) // Wednesday
+	setClock(t, &current)
+
+	cfg := readConfig(t, `
+timeIntervals:
+  - name: business-hours
+    weekdays: ["monday:friday"]
+    times:
+      - start: "09:00"
+        end: "17:00"
+  - name: maintenance
+    start: "2024-01-03T12:00:00Z"
+    end: "2024-01-03T14:00:00Z"
+route:
+  drop:
+    - activeTimeIntervals: [maintenance]
+  routes:
+    - match:
+        - receiver: slack
+          type: Normal
+          activeTimeIntervals: [business-hours]
+        - receiver: pagerduty
+          type: Warning
+`)
+	require.NoError(t, cfg.Validate())
+
+	normal := &kube.EnhancedEvent{}
+	normal.Type = "Normal"
+	warning := &kube.EnhancedEvent{}
+	warning.Type = "Warning"
+
+	// Within business hours
+	reg := testReceiverRegistry{}
+	cfg.Route.ProcessEvent(normal, &reg)
+	cfg.Route.ProcessEvent(warning, &reg)
+	assert.Equal(t, 1, reg.count("slack"))
+	assert.Equal(t, 1, reg.count("pagerduty"))
+
+	// Within the maintenance window everything is dropped
+	current = time.Date(2024, 1, 3, 13, 0, 0, 0, time.UTC)
+	reg = testReceiverRegistry{}
+	cfg.Route.ProcessEvent(normal, &reg)
+	cfg.Route.ProcessEvent(warning, &reg)
+	assert.Equal(t, 0, reg.count("slack"))
+	assert.Equal(t, 0, reg.count("pagerduty"))
+
+	// Outside business hours only the warnings are sent
+	current = time.Date(2024, 1, 6, 10, 0, 0, 0, time.UTC) // Saturday
+	reg = testReceiverRegistry{}
+	cfg.Route.ProcessEvent(normal, &reg)
+	cfg.Route.ProcessEvent(warning, &reg)
+	assert.Equal(t, 0, reg.count("slack"))
+	assert.Equal(t, 1, reg.count("pagerduty"))
+}
+
+func TestTimeIntervalMutedRouteMatches(t *testing.T) {
+	current := time.Date(2024, 1, 6, 10, 0, 0, 0, time.UTC) // Saturday
+	setClock(t, &current)
+
+	cfg := readConfig(t, `
+timeIntervals:
+  - name: weekend
+    weekdays: ["saturday", "sunday"]
+route:
+  routes:
+    - muteTimeIntervals: [weekend]
+      continue: false
+      match:
+        - receiver: oncall
+    - match:
+        - receiver: fallback
+`)
+	require.NoError(t, cfg.Validate())
+
+	ev := &kube.EnhancedEvent{}
+	reg := testReceiverRegistry{}
+	cfg.Route.ProcessEvent(ev, &reg)
+	assert.Equal(t, 0, reg.count("oncall"))
+	assert.Equal(t, 0, reg.count("fallback"))
+
+	current = time.Date(2024, 1, 8, 10, 0, 0, 0, time.UTC) // Monday
+	cfg.Route.ProcessEvent(ev, &reg)
+	assert.Equal(t, 1, reg.count("oncall"))
+	assert.Equal(t, 0, reg.count("fallback"))
+}
+
+func TestTimeIntervalMutedRoutePartialMatch(t *testing.T) {
+	current := time.Date(2024, 1, 3, 13, 0, 0, 0, time.UTC)
+	setClock(t, &current)
+
+	cfg := readConfig(t, `
+timeIntervals:
+  - name: maintenance
+    start: "2024-01-03T12:00:00Z"
+    end: "2024-01-03T14:00:00Z"
+route:
+  routes:
+    - muteTimeIntervals: [maintenance]
+      match:
+        - receiver: slack
+          type: Normal
+        - receiver: pagerduty
+          type: Warning
+`)
+	require.NoError(t, cfg.Validate())
+
+	// The route only partly matches the event, the receiver of the rule matched is muted all the same
+	warning := &kube.EnhancedEvent{}
+	warning.Type = "Warning"
+	reg := testReceiverRegistry{}
+	cfg.Route.ProcessEvent(warning, &reg)
+	assert.Equal(t, 0, reg.count("pagerduty"))
+
+	current = time.Date(2024, 1, 3, 15, 0, 0, 0, time.UTC)
+	cfg.Route.ProcessEvent(warning, &reg)
+	assert.Equal(t, 1, reg.count("pagerduty"))
+	assert.Equal(t, 0, reg.count("slack"))
+}
+
+func TestTimeIntervalValidate(t *testing.T) {
+	cfg := readConfig(t, `
+route:
+  match:
+    - receiver: slack
+      activeTimeIntervals: [business-hours]
+`)
+	assert.ErrorContains(t, cfg.Validate(), `unknown time interval "business-hours"`)
+
+	cfg = readConfig(t, `
+timeIntervals:
+  - name: weekend
+    weekdays: [saturday]
+  - name: weekend
+    weekdays: [sunday]
+`)
+	assert.ErrorContains(t, cfg.Validate(), "duplicate time interval weekend")
+}
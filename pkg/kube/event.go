@@ -13,6 +13,8 @@ type EnhancedEvent struct {
 	corev1.Event   `json:",inline"`
 	ClusterName    string                  `json:"clusterName"`
 	InvolvedObject EnhancedObjectReference `json:"involvedObject"`
+	// SuppressedCount is the number of similar events suppressed by throttling before this one, if it is requested
+	SuppressedCount int `json:"suppressedCount,omitempty"`
 }
 
 // DeDot replaces all dots in the labels and annotations with underscores. This is required for example in the
@@ -15,13 +15,22 @@ import (
 )
 
 type Store struct {
-	EventsProcessed      prometheus.Counter
-	EventsDiscarded      prometheus.Counter
-	WatchErrors          prometheus.Counter
-	SendErrors           prometheus.Counter
-	BuildInfo            prometheus.GaugeFunc
-	KubeApiReadCacheHits prometheus.Counter
-	KubeApiReadRequests  prometheus.Counter
+	EventsProcessed       prometheus.Counter
+	EventsDiscarded       prometheus.Counter
+	WatchErrors           prometheus.Counter
+	SendErrors            prometheus.Counter
+	BuildInfo             prometheus.GaugeFunc
+	KubeApiReadCacheHits  prometheus.Counter
+	KubeApiReadRequests   prometheus.Counter
+	ConfigReloads         prometheus.Counter
+	ConfigReloadErrors    prometheus.Counter
+	EventsThrottled       prometheus.Counter
+	Redactions            *prometheus.CounterVec
+	KafkaMessagesProduced *prometheus.CounterVec
+	KafkaProduceErrors    *prometheus.CounterVec
+	BatchesSent           *prometheus.CounterVec
+	BatchEventsRetried    *prometheus.CounterVec
+	BatchEventsRejected   *prometheus.CounterVec
 }
 
 func Init(addr string, tlsConf string) {
@@ -116,6 +125,42 @@ func NewMetricsStore(name_prefix string) *Store {
 			Name: name_prefix + "kube_api_read_cache_misses",
 			Help: "The total number of read requests served from kube-apiserver when looking up object metadata",
 		}),
+		ConfigReloads: promauto.NewCounter(prometheus.CounterOpts{
+			Name: name_prefix + "config_reloads",
+			Help: "The total number of successful configuration reloads",
+		}),
+		ConfigReloadErrors: promauto.NewCounter(prometheus.CounterOpts{
+			Name: name_prefix + "config_reload_errors",
+			Help: "The total number of configuration reloads rejected because of an invalid configuration",
+		}),
+		EventsThrottled: promauto.NewCounter(prometheus.CounterOpts{
+			Name: name_prefix + "events_throttled",
+			Help: "The total number of events suppressed by the throttling of routes and rules",
+		}),
+		Redactions: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: name_prefix + "redactions",
+			Help: "The total number of values redacted from the events, labeled by the detector or rule",
+		}, []string{"rule"}),
+		KafkaMessagesProduced: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: name_prefix + "kafka_messages_produced",
+			Help: "The total number of messages acknowledged by the Kafka brokers, labeled by topic",
+		}, []string{"topic"}),
+		KafkaProduceErrors: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: name_prefix + "kafka_produce_errors",
+			Help: "The total number of messages the Kafka producer failed to produce, labeled by topic",
+		}, []string{"topic"}),
+		BatchesSent: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: name_prefix + "batches_sent",
+			Help: "The total number of batches sent by the batching sinks, labeled by sink and result: success, partial or failure",
+		}, []string{"sink", "result"}),
+		BatchEventsRetried: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: name_prefix + "batch_events_retried",
+			Help: "The total number of events of the batches failed with a retryable error, labeled by sink",
+		}, []string{"sink"}),
+		BatchEventsRejected: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: name_prefix + "batch_events_rejected",
+			Help: "The total number of events of the batches failed permanently and dropped, labeled by sink",
+		}, []string{"sink"}),
 	}
 }
 
@@ -127,5 +172,14 @@ func DestroyMetricsStore(store *Store) {
 	prometheus.Unregister(store.BuildInfo)
 	prometheus.Unregister(store.KubeApiReadCacheHits)
 	prometheus.Unregister(store.KubeApiReadRequests)
+	prometheus.Unregister(store.ConfigReloads)
+	prometheus.Unregister(store.ConfigReloadErrors)
+	prometheus.Unregister(store.EventsThrottled)
+	prometheus.Unregister(store.Redactions)
+	prometheus.Unregister(store.KafkaMessagesProduced)
+	prometheus.Unregister(store.KafkaProduceErrors)
+	prometheus.Unregister(store.BatchesSent)
+	prometheus.Unregister(store.BatchEventsRetried)
+	prometheus.Unregister(store.BatchEventsRejected)
 	store = nil
 }
@@ -2,12 +2,25 @@ package setup
 
 import (
 	"errors"
+	"os"
 	"strings"
 
 	"github.com/goccy/go-yaml"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/exporter"
 )
 
+// ReadConfigFile reads the config file at path, expands the environment variables in it and parses it.
+func ReadConfigFile(path string) (exporter.Config, error) {
+	configBytes, err := os.ReadFile(path)
+	if err != nil {
+		return exporter.Config{}, err
+	}
+
+	configBytes = []byte(os.ExpandEnv(string(configBytes)))
+
+	return ParseConfigFromBytes(configBytes)
+}
+
 func ParseConfigFromBytes(configBytes []byte) (exporter.Config, error) {
 	var config exporter.Config
 	err := yaml.Unmarshal(configBytes, &config)
@@ -0,0 +1,55 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// WatchConfigFile polls the file at path every interval and signals on the returned channel whenever its contents
+// change. Polling is used instead of inotify since Kubernetes updates mounted ConfigMaps by swapping symlinks, which
+// file watchers easily miss. The channel is closed when the context is done.
+func WatchConfigFile(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	last := fileChecksum(path)
+
+	go func() {
+		defer close(changes)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				current := fileChecksum(path)
+				if current == nil || bytes.Equal(current, last) {
+					continue
+				}
+				last = current
+
+				// Coalesce changes if the previous one is not consumed yet
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changes
+}
+
+func fileChecksum(path string) []byte {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		slog.With("path", path, "err", err).Warn("cannot read config file")
+		return nil
+	}
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
@@ -0,0 +1,50 @@
+package setup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WatchConfigFile_SignalsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("logLevel: info\n"), 0o644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := WatchConfigFile(ctx, path, 10*time.Millisecond)
+
+	select {
+	case <-changes:
+		t.Fatal("unexpected change before the file is modified")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, os.WriteFile(path, []byte("logLevel: debug\n"), 0o644))
+
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("change is not signaled")
+	}
+
+	cancel()
+	_, ok := <-changes
+	assert.False(t, ok)
+}
+
+func Test_ReadConfigFile_ExpandsEnv(t *testing.T) {
+	t.Setenv("TEST_LOG_LEVEL", "warn")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("logLevel: ${TEST_LOG_LEVEL}\n"), 0o644))
+
+	config, err := ReadConfigFile(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "warn", config.LogLevel)
+}
@@ -0,0 +1,345 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+)
+
+const (
+	DefaultAlertmanagerTTL         = 5 * time.Minute
+	DefaultAlertmanagerResendDelay = time.Minute
+	DefaultAlertmanagerMaxRetries  = 3
+	alertmanagerAlertsPath         = "/api/v2/alerts"
+)
+
+var alertmanagerLabelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// DefaultAlertmanagerLabels identify an alert per object and reason
+var DefaultAlertmanagerLabels = map[string]string{
+	"alertname": "{{ .Reason }}",
+	"severity":  `{{ if eq .Type "Warning" }}warning{{ else }}info{{ end }}`,
+	"cluster":   "{{ .ClusterName }}",
+	"namespace": "{{ .InvolvedObject.Namespace }}",
+	"kind":      "{{ .InvolvedObject.Kind }}",
+	"name":      "{{ .InvolvedObject.Name }}",
+}
+
+// DefaultAlertmanagerAnnotations describe the alert with the message of the event
+var DefaultAlertmanagerAnnotations = map[string]string{
+	"summary": "{{ .Reason }}: {{ .InvolvedObject.Kind }} {{ with .InvolvedObject.Namespace }}{{ . }}/{{ end }}{{ .InvolvedObject.Name }}",
+	"message": "{{ .Message }}",
+}
+
+type AlertmanagerConfig struct {
+	// URLs are the Alertmanagers of a cluster, every alert is posted to all of them. The API path is appended unless
+	// the URL already ends with it.
+	URLs []string `yaml:"urls"`
+	// Labels identify the alert, the ones rendered empty are left out
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+	// GeneratorURL is a template linking back to the source of the alert
+	GeneratorURL string `yaml:"generatorURL"`
+	// TTL is added to the last time the event was seen to get the end of the alert, 5m by default. The alert is
+	// resolved by Alertmanager once the event stops recurring for the TTL.
+	TTL time.Duration `yaml:"ttl"`
+	// ResendDelay is the minimum delay before an alert is posted again for a recurring event, 1m by default
+	ResendDelay time.Duration `yaml:"resendDelay"`
+	// MaxRetries is the number of times the alerts are posted again to the Alertmanagers which failed to take them, 3
+	// by default
+	MaxRetries int                    `yaml:"maxRetries"`
+	BasicAuth  *AlertmanagerBasicAuth `yaml:"basicAuth"`
+	TLS        TLS                    `yaml:"tls"`
+	Timeout    time.Duration          `yaml:"timeout"`
+}
+
+type AlertmanagerBasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type alertmanagerAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     string            `json:"startsAt,omitempty"`
+	EndsAt       string            `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// alertmanagerPost is the last time an alert was posted, and the end it was posted with
+type alertmanagerPost struct {
+	at     time.Time
+	endsAt time.Time
+}
+
+type AlertmanagerSink struct {
+	cfg    *AlertmanagerConfig
+	urls   []string
+	client *http.Client
+	now    func() time.Time
+
+	mu sync.Mutex
+	// posted holds the active alerts by their labels, to throttle the alerts of the recurring events
+	posted map[string]alertmanagerPost
+}
+
+func NewAlertmanagerSink(cfg *AlertmanagerConfig) (Sink, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("alertmanager urls cannot be empty")
+	}
+	if cfg.Labels == nil {
+		cfg.Labels = DefaultAlertmanagerLabels
+	}
+	if cfg.Annotations == nil {
+		cfg.Annotations = DefaultAlertmanagerAnnotations
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultAlertmanagerTTL
+	}
+	if cfg.ResendDelay <= 0 {
+		cfg.ResendDelay = DefaultAlertmanagerResendDelay
+	}
+	if cfg.ResendDelay >= cfg.TTL {
+		return nil, fmt.Errorf("alertmanager resendDelay %s must be shorter than the ttl %s", cfg.ResendDelay, cfg.TTL)
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = DefaultAlertmanagerMaxRetries
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	for name, text := range cfg.Labels {
+		if !alertmanagerLabelNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid alertmanager label name %q", name)
+		}
+		if err := ValidateTemplate(text); err != nil {
+			return nil, fmt.Errorf("invalid alertmanager label %s: %w", name, err)
+		}
+	}
+	for name, text := range cfg.Annotations {
+		if err := ValidateTemplate(text); err != nil {
+			return nil, fmt.Errorf("invalid alertmanager annotation %s: %w", name, err)
+		}
+	}
+	if err := ValidateTemplate(cfg.GeneratorURL); err != nil {
+		return nil, fmt.Errorf("invalid alertmanager generatorURL: %w", err)
+	}
+
+	urls := make([]string, 0, len(cfg.URLs))
+	for _, url := range cfg.URLs {
+		url = strings.TrimSuffix(url, "/")
+		if !strings.HasSuffix(url, alertmanagerAlertsPath) {
+			url += alertmanagerAlertsPath
+		}
+		urls = append(urls, url)
+	}
+
+	tlsClientConfig, err := setupTLS(&cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup TLS: %w", err)
+	}
+	client := &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsClientConfig,
+		},
+	}
+
+	return &AlertmanagerSink{
+		cfg:    cfg,
+		urls:   urls,
+		client: client,
+		now:    time.Now,
+		posted: make(map[string]alertmanagerPost),
+	}, nil
+}
+
+// Send posts the event as an alert ending after the TTL. A recurring event extends its alert, at most once per
+// ResendDelay.
+func (a *AlertmanagerSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	alert, err := a.alert(ev)
+	if err != nil {
+		return err
+	}
+
+	now := a.now()
+	startsAt := time.UnixMilli(ev.GetTimestampMs())
+	if ev.GetTimestampMs() <= 0 {
+		startsAt = now
+	}
+	lastSeen := ev.LastTimestamp.Time
+	if lastSeen.Before(startsAt) {
+		lastSeen = startsAt
+	}
+	endsAt := lastSeen.Add(a.cfg.TTL)
+	if !endsAt.After(now) {
+		// The event is older than the TTL, it would be resolved as soon as posted
+		return nil
+	}
+	alert.StartsAt = startsAt.UTC().Format(time.RFC3339Nano)
+	alert.EndsAt = endsAt.UTC().Format(time.RFC3339Nano)
+
+	key := formatLabels(alert.Labels)
+	if !a.due(key, now) {
+		return nil
+	}
+	if err := a.post(ctx, []*alertmanagerAlert{alert}); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.posted[key] = alertmanagerPost{at: now, endsAt: endsAt}
+	a.mu.Unlock()
+	return nil
+}
+
+// due tells whether the alert with the given labels should be posted, and forgets the alerts which have ended
+func (a *AlertmanagerSink) due(key string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for k, p := range a.posted {
+		if !p.endsAt.After(now) {
+			delete(a.posted, k)
+		}
+	}
+	p, ok := a.posted[key]
+	return !ok || now.Sub(p.at) >= a.cfg.ResendDelay
+}
+
+func (a *AlertmanagerSink) alert(ev *kube.EnhancedEvent) (*alertmanagerAlert, error) {
+	alert := &alertmanagerAlert{
+		Labels:      make(map[string]string, len(a.cfg.Labels)),
+		Annotations: make(map[string]string, len(a.cfg.Annotations)),
+	}
+	for name, text := range a.cfg.Labels {
+		value, err := GetString(ev, text)
+		if err != nil {
+			return nil, fmt.Errorf("cannot render the alertmanager label %s: %w", name, err)
+		}
+		if value != "" {
+			alert.Labels[name] = value
+		}
+	}
+	if len(alert.Labels) == 0 {
+		return nil, Permanent(errors.New("alertmanager labels rendered empty"))
+	}
+	for name, text := range a.cfg.Annotations {
+		value, err := GetString(ev, text)
+		if err != nil {
+			return nil, fmt.Errorf("cannot render the alertmanager annotation %s: %w", name, err)
+		}
+		if value != "" {
+			alert.Annotations[name] = value
+		}
+	}
+	generatorURL, err := GetString(ev, a.cfg.GeneratorURL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot render the alertmanager generatorURL: %w", err)
+	}
+	alert.GeneratorURL = generatorURL
+	return alert, nil
+}
+
+// post posts the alerts to all the Alertmanagers. The replicas of an Alertmanager cluster do not share the alerts with
+// each other, so the alerts are posted again to the ones which failed to take them, up to MaxRetries times. It fails
+// when any of them did not take the alerts in the end, permanently only when all of those rejected them.
+func (a *AlertmanagerSink) post(ctx context.Context, alerts []*alertmanagerAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	pending := a.urls
+	failures := make(map[string]error, len(a.urls))
+	return withRetries(ctx, "alertmanager", a.cfg.MaxRetries, func() (time.Duration, error) {
+		errs := make([]error, len(pending))
+		var wg sync.WaitGroup
+		for i, url := range pending {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = a.postTo(ctx, url, body)
+			}()
+		}
+		wg.Wait()
+
+		retry := make([]string, 0, len(pending))
+		for i, url := range pending {
+			switch err := errs[i]; {
+			case err == nil:
+				delete(failures, url)
+			case IsPermanent(err):
+				failures[url] = err
+			default:
+				failures[url] = err
+				retry = append(retry, url)
+			}
+		}
+		pending = retry
+		if len(failures) == 0 {
+			return -1, nil
+		}
+
+		errs = errs[:0]
+		for _, url := range a.urls {
+			if err := failures[url]; err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(retry) == 0 {
+			return -1, Permanent(errors.Join(errs...))
+		}
+		// The rejections are not permanent while another Alertmanager may take the alerts on retry
+		for i, err := range errs {
+			var p *permanentError
+			if errors.As(err, &p) {
+				errs[i] = p.err
+			}
+		}
+		return -1, errors.Join(errs...)
+	})
+}
+
+func (a *AlertmanagerSink) postTo(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.cfg.BasicAuth != nil {
+		req.SetBasicAuth(a.cfg.BasicAuth.Username, a.cfg.BasicAuth.Password)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	rb, _ := io.ReadAll(resp.Body)
+	err = fmt.Errorf("alertmanager %s failed with status %d: %s", url, resp.StatusCode, string(rb))
+	if !retryableStatus(resp.StatusCode) {
+		return Permanent(err)
+	}
+	return err
+}
+
+func (a *AlertmanagerSink) Close() {
+	a.client.CloseIdleConnections()
+}
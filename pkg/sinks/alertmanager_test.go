@@ -0,0 +1,187 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// alertmanagerStandIn records the alerts posted to it and answers with status, or with 503 for the first failures
+type alertmanagerStandIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	alerts   []map[string]any
+	auth     []string
+	status   int
+	failures int
+}
+
+func newAlertmanagerStandIn(t *testing.T, status int) *alertmanagerStandIn {
+	s := &alertmanagerStandIn{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/alerts", r.URL.Path)
+		var alerts []map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&alerts))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.alerts = append(s.alerts, alerts...)
+		user, password, _ := r.BasicAuth()
+		s.auth = append(s.auth, user+":"+password)
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(s.status)
+	}))
+	return s
+}
+
+func newPodEvent(now time.Time) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Reason = "BackOff"
+	ev.Type = "Warning"
+	ev.Message = "Back-off restarting failed container"
+	ev.InvolvedObject.Kind = "Pod"
+	ev.InvolvedObject.Namespace = "default"
+	ev.InvolvedObject.Name = "api-0"
+	ev.FirstTimestamp = metav1.NewTime(now.Add(-time.Minute))
+	ev.LastTimestamp = metav1.NewTime(now)
+	return ev
+}
+
+func TestAlertmanagerSend(t *testing.T) {
+	server := newAlertmanagerStandIn(t, http.StatusOK)
+	defer server.Close()
+
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	sink, err := NewAlertmanagerSink(&AlertmanagerConfig{
+		URLs:         []string{server.URL + "/"},
+		GeneratorURL: "https://console.example.com/{{ .InvolvedObject.Namespace }}/{{ .InvolvedObject.Name }}",
+		TTL:          10 * time.Minute,
+		BasicAuth:    &AlertmanagerBasicAuth{Username: "user", Password: "secret"},
+	})
+	require.NoError(t, err)
+	sink.(*AlertmanagerSink).now = func() time.Time { return now }
+
+	require.NoError(t, sink.Send(context.Background(), newPodEvent(now)))
+	require.Len(t, server.alerts, 1)
+	assert.Equal(t, map[string]any{
+		"labels": map[string]any{
+			"alertname": "BackOff",
+			"severity":  "warning",
+			"namespace": "default",
+			"kind":      "Pod",
+			"name":      "api-0",
+		},
+		"annotations": map[string]any{
+			"summary": "BackOff: Pod default/api-0",
+			"message": "Back-off restarting failed container",
+		},
+		"startsAt":     "2024-05-06T07:07:09Z",
+		"endsAt":       "2024-05-06T07:18:09Z",
+		"generatorURL": "https://console.example.com/default/api-0",
+	}, server.alerts[0])
+	assert.Equal(t, []string{"user:secret"}, server.auth)
+}
+
+func TestAlertmanagerResend(t *testing.T) {
+	server := newAlertmanagerStandIn(t, http.StatusOK)
+	defer server.Close()
+
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	sink, err := NewAlertmanagerSink(&AlertmanagerConfig{URLs: []string{server.URL}})
+	require.NoError(t, err)
+	sink.(*AlertmanagerSink).now = func() time.Time { return now }
+
+	require.NoError(t, sink.Send(context.Background(), newPodEvent(now)))
+
+	// The recurrence within the resend delay is not posted
+	now = now.Add(30 * time.Second)
+	require.NoError(t, sink.Send(context.Background(), newPodEvent(now)))
+	require.Len(t, server.alerts, 1)
+
+	// The later one extends the alert
+	now = now.Add(time.Minute)
+	require.NoError(t, sink.Send(context.Background(), newPodEvent(now)))
+	require.Len(t, server.alerts, 2)
+	assert.Equal(t, "2024-05-06T07:14:39Z", server.alerts[1]["endsAt"])
+
+	// An event seen last before the TTL is not posted at all
+	now = now.Add(time.Hour)
+	require.NoError(t, sink.Send(context.Background(), newPodEvent(now.Add(-10*time.Minute))))
+	require.Len(t, server.alerts, 2)
+}
+
+func TestAlertmanagerMultipleURLs(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	up := newAlertmanagerStandIn(t, http.StatusOK)
+	defer up.Close()
+	down := newAlertmanagerStandIn(t, http.StatusServiceUnavailable)
+	defer down.Close()
+	rejecting := newAlertmanagerStandIn(t, http.StatusBadRequest)
+	defer rejecting.Close()
+
+	now := time.Now()
+
+	// The replicas do not share the alerts, the alert is posted again to the one which failed until it takes it
+	sink, err := NewAlertmanagerSink(&AlertmanagerConfig{URLs: []string{up.URL, down.URL}})
+	require.NoError(t, err)
+	down.failures = 2
+	down.status = http.StatusOK
+	require.NoError(t, sink.Send(context.Background(), newPodEvent(now)))
+	assert.Len(t, up.alerts, 1)
+	assert.Len(t, down.alerts, 3)
+	down.status = http.StatusServiceUnavailable
+
+	// The alert fails once the retries are exhausted
+	sink, err = NewAlertmanagerSink(&AlertmanagerConfig{URLs: []string{up.URL, down.URL}, MaxRetries: 1})
+	require.NoError(t, err)
+	err = sink.Send(context.Background(), newPodEvent(now))
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+	assert.Len(t, up.alerts, 2)
+	assert.Len(t, down.alerts, 5)
+
+	sink, err = NewAlertmanagerSink(&AlertmanagerConfig{URLs: []string{up.URL, rejecting.URL}})
+	require.NoError(t, err)
+	err = sink.Send(context.Background(), newPodEvent(now))
+	assert.True(t, IsPermanent(err), "a rejected alert is not retried")
+
+	sink, err = NewAlertmanagerSink(&AlertmanagerConfig{URLs: []string{down.URL, rejecting.URL}})
+	require.NoError(t, err)
+	err = sink.Send(context.Background(), newPodEvent(now))
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err), "an unavailable Alertmanager is retried")
+
+	sink, err = NewAlertmanagerSink(&AlertmanagerConfig{URLs: []string{rejecting.URL, rejecting.URL + "/api/v2/alerts"}})
+	require.NoError(t, err)
+	err = sink.Send(context.Background(), newPodEvent(now))
+	assert.True(t, IsPermanent(err))
+}
+
+func TestAlertmanagerConfigErrors(t *testing.T) {
+	for name, cfg := range map[string]*AlertmanagerConfig{
+		"urls cannot be empty":            {},
+		"invalid alertmanager label name": {URLs: []string{"http://am"}, Labels: map[string]string{"app.kubernetes.io/name": "x"}},
+		"invalid alertmanager annotation": {URLs: []string{"http://am"}, Annotations: map[string]string{"message": "{{ .Message"}},
+		"must be shorter than the ttl":    {URLs: []string{"http://am"}, TTL: time.Minute, ResendDelay: time.Minute},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewAlertmanagerSink(cfg)
+			assert.ErrorContains(t, err, name)
+		})
+	}
+}
@@ -9,6 +9,7 @@ package sinks
 // where the first byte is \0 and the next 16 bytes are the schemaID string
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 
@@ -34,7 +35,7 @@ type Avro struct {
 	codec    *goavro.Codec
 }
 
-func (a Avro) encode(textual []byte) ([]byte, error) {
+func (a Avro) encode(_ context.Context, _ string, textual []byte) ([]byte, error) {
 
 	var err error
 	dst, err := hex.DecodeString(a.SchemaID)
@@ -0,0 +1,51 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// newAWSSession creates the session of the AWS sinks. The endpoint is optional, it can point to a VPC endpoint or a
+// local stand-in of the service.
+func newAWSSession(region, endpoint string) (*session.Session, error) {
+	cfg := &aws.Config{
+		Region: aws.String(region),
+	}
+	if endpoint != "" {
+		cfg.Endpoint = aws.String(endpoint)
+	}
+	return session.NewSession(cfg)
+}
+
+// awsRequestError classifies the error of a whole batch request, the requests rejected by the service other than for
+// throttling are not retried
+func awsRequestError(err error) error {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && !retryableStatus(reqErr.StatusCode()) && !awsThrottlingCode(reqErr.Code()) {
+		return Permanent(err)
+	}
+	return err
+}
+
+// awsEntryError is the error of an entry failed in a batch. The entries failed for throttling or internal errors are
+// retried, the others are not.
+func awsEntryError(code, message string, retryable bool) error {
+	err := fmt.Errorf("%s: %s", code, message)
+	if retryable || awsThrottlingCode(code) || code == "InternalFailure" || code == "ServiceUnavailableException" {
+		return err
+	}
+	return Permanent(err)
+}
+
+func awsThrottlingCode(code string) bool {
+	switch code {
+	case "ProvisionedThroughputExceededException", "ThrottlingException", "Throttling", "RequestThrottled",
+		"ServiceUnavailableException", "KMSThrottlingException":
+		return true
+	}
+	return false
+}
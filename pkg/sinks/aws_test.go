@@ -0,0 +1,257 @@
+package sinks
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// awsStandIn answers the batch APIs of Kinesis, Firehose and SQS. The entries whose data contains "throttled" fail with
+// a throttling error and the ones containing "invalid" with a client error.
+type awsStandIn struct {
+	mu       sync.Mutex
+	requests map[string][]map[string]any
+}
+
+func newAWSStandIn(t *testing.T) (*awsStandIn, *httptest.Server) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	s := &awsStandIn{requests: make(map[string][]map[string]any)}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		target := r.Header.Get("X-Amz-Target")
+		s.mu.Lock()
+		s.requests[target] = append(s.requests[target], req)
+		s.mu.Unlock()
+
+		var resp any
+		switch target {
+		case "Kinesis_20131202.PutRecords":
+			records := make([]map[string]any, 0)
+			for _, record := range req["Records"].([]any) {
+				records = append(records, kinesisResult(decodeData(t, record)))
+			}
+			resp = map[string]any{"Records": records}
+		case "Firehose_20150804.PutRecordBatch":
+			records := make([]map[string]any, 0)
+			for _, record := range req["Records"].([]any) {
+				records = append(records, kinesisResult(decodeData(t, record)))
+			}
+			resp = map[string]any{"RequestResponses": records}
+		case "AmazonSQS.GetQueueUrl":
+			resp = map[string]any{"QueueUrl": server.URL + "/123456789012/" + req["QueueName"].(string)}
+		case "AmazonSQS.SendMessageBatch":
+			successful := make([]map[string]any, 0)
+			failed := make([]map[string]any, 0)
+			for _, e := range req["Entries"].([]any) {
+				entry := e.(map[string]any)
+				body := entry["MessageBody"].(string)
+				switch {
+				case strings.Contains(body, "throttled"):
+					failed = append(failed, map[string]any{"Id": entry["Id"], "Code": "RequestThrottled", "Message": "slow down", "SenderFault": false})
+				case strings.Contains(body, "invalid"):
+					failed = append(failed, map[string]any{"Id": entry["Id"], "Code": "InvalidMessageContents", "Message": "invalid", "SenderFault": true})
+				default:
+					sum := md5.Sum([]byte(body))
+					successful = append(successful, map[string]any{"Id": entry["Id"], "MessageId": entry["Id"], "MD5OfMessageBody": hex.EncodeToString(sum[:])})
+				}
+			}
+			resp = map[string]any{"Successful": successful, "Failed": failed}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"__type":"UnknownOperationException","message":"%s"}`, target)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(resp)
+	}))
+	return s, server
+}
+
+func (s *awsStandIn) calls(target string) []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[target]
+}
+
+func decodeData(t *testing.T, record any) string {
+	var data []byte
+	require.NoError(t, json.Unmarshal([]byte(`"`+record.(map[string]any)["Data"].(string)+`"`), &data))
+	return string(data)
+}
+
+func kinesisResult(data string) map[string]any {
+	switch {
+	case strings.Contains(data, "throttled"):
+		return map[string]any{"ErrorCode": "ProvisionedThroughputExceededException", "ErrorMessage": "slow down"}
+	case strings.Contains(data, "invalid"):
+		return map[string]any{"ErrorCode": "InvalidArgumentException", "ErrorMessage": "invalid"}
+	}
+	return map[string]any{"SequenceNumber": "1", "ShardId": "shardId-000000000000", "RecordId": "1"}
+}
+
+func newAWSEvents(messages ...string) []*kube.EnhancedEvent {
+	evs := make([]*kube.EnhancedEvent, 0, len(messages))
+	for i, message := range messages {
+		ev := &kube.EnhancedEvent{}
+		ev.UID = types.UID(fmt.Sprintf("uid-%d", i))
+		ev.Message = message
+		ev.Namespace = "default"
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func assertPartialFailures(t *testing.T, errs []error) {
+	require.Len(t, errs, 3)
+	assert.NoError(t, errs[0])
+	require.Error(t, errs[1])
+	assert.False(t, IsPermanent(errs[1]), "throttled entries are retried")
+	require.Error(t, errs[2])
+	assert.True(t, IsPermanent(errs[2]), "invalid entries are not retried")
+}
+
+func TestKinesisSendBatch(t *testing.T) {
+	standIn, server := newAWSStandIn(t)
+	defer server.Close()
+
+	sink, err := NewKinesisSink(&KinesisConfig{
+		StreamName:   "events",
+		Region:       "us-east-1",
+		Endpoint:     server.URL,
+		Layout:       map[string]any{"message": "{{ .Message }}"},
+		PartitionKey: "{{ .Namespace }}",
+	})
+	require.NoError(t, err)
+
+	errs := sink.(*KinesisSink).SendBatch(context.Background(), newAWSEvents("ok", "throttled", "invalid"))
+	assertPartialFailures(t, errs)
+
+	calls := standIn.calls("Kinesis_20131202.PutRecords")
+	require.Len(t, calls, 1)
+	assert.Equal(t, "events", calls[0]["StreamName"])
+	records := calls[0]["Records"].([]any)
+	require.Len(t, records, 3)
+	assert.Equal(t, "default", records[0].(map[string]any)["PartitionKey"])
+	assert.Equal(t, `{"message":"ok"}`, decodeData(t, records[0]))
+}
+
+func TestKinesisSplitsByLimits(t *testing.T) {
+	standIn, server := newAWSStandIn(t)
+	defer server.Close()
+
+	sink, err := NewKinesisSink(&KinesisConfig{StreamName: "events", Region: "us-east-1", Endpoint: server.URL})
+	require.NoError(t, err)
+
+	messages := make([]string, kinesisMaxRecords+1)
+	for i := range messages {
+		messages[i] = "ok"
+	}
+	errs := sink.(*KinesisSink).SendBatch(context.Background(), newAWSEvents(messages...))
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	calls := standIn.calls("Kinesis_20131202.PutRecords")
+	require.Len(t, calls, 2)
+	assert.Len(t, calls[0]["Records"], kinesisMaxRecords)
+	assert.Len(t, calls[1]["Records"], 1)
+}
+
+func TestFirehoseSendBatch(t *testing.T) {
+	standIn, server := newAWSStandIn(t)
+	defer server.Close()
+
+	sink, err := NewFirehoseSink(&FirehoseConfig{
+		DeliveryStreamName: "events",
+		Region:             "us-east-1",
+		Endpoint:           server.URL,
+		Layout:             map[string]any{"message": "{{ .Message }}"},
+	})
+	require.NoError(t, err)
+
+	errs := sink.(*FirehoseSink).SendBatch(context.Background(), newAWSEvents("ok", "throttled", "invalid"))
+	assertPartialFailures(t, errs)
+
+	calls := standIn.calls("Firehose_20150804.PutRecordBatch")
+	require.Len(t, calls, 1)
+	assert.Equal(t, "events", calls[0]["DeliveryStreamName"])
+	assert.Len(t, calls[0]["Records"], 3)
+}
+
+func TestSQSSendBatch(t *testing.T) {
+	standIn, server := newAWSStandIn(t)
+	defer server.Close()
+
+	sink, err := NewSQSSink(&SQSConfig{
+		QueueName:              "events.fifo",
+		Region:                 "us-east-1",
+		Endpoint:               server.URL,
+		Layout:                 map[string]any{"message": "{{ .Message }}"},
+		MessageGroupID:         "{{ .Namespace }}",
+		MessageDeduplicationID: "{{ .Message }}",
+	})
+	require.NoError(t, err)
+
+	errs := sink.(*SQSSink).SendBatch(context.Background(), newAWSEvents("ok", "throttled", "invalid"))
+	assertPartialFailures(t, errs)
+
+	calls := standIn.calls("AmazonSQS.SendMessageBatch")
+	require.Len(t, calls, 1)
+	assert.Equal(t, server.URL+"/123456789012/events.fifo", calls[0]["QueueUrl"])
+	entries := calls[0]["Entries"].([]any)
+	require.Len(t, entries, 3)
+	assert.Equal(t, map[string]any{
+		"Id":                     "0",
+		"MessageBody":            `{"message":"ok"}`,
+		"MessageGroupId":         "default",
+		"MessageDeduplicationId": "ok",
+	}, entries[0])
+}
+
+func TestSQSSplitsByLimits(t *testing.T) {
+	standIn, server := newAWSStandIn(t)
+	defer server.Close()
+
+	sink, err := NewSQSSink(&SQSConfig{QueueName: "events", Region: "us-east-1", Endpoint: server.URL})
+	require.NoError(t, err)
+
+	messages := make([]string, 25)
+	for i := range messages {
+		messages[i] = "ok"
+	}
+	errs := sink.(*SQSSink).SendBatch(context.Background(), newAWSEvents(messages...))
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	calls := standIn.calls("AmazonSQS.SendMessageBatch")
+	require.Len(t, calls, 3)
+	assert.Len(t, calls[0]["Entries"], 10)
+	assert.Len(t, calls[2]["Entries"], 5)
+}
+
+func TestChunkBySize(t *testing.T) {
+	sizes := []int{5, 5, 5, 20, 1, 1}
+	assert.Equal(t, [][]int{{0, 1}, {2}, {3}, {4, 5}}, chunkBySize([]int{0, 1, 2, 3, 4, 5}, sizes, 2, 20))
+	assert.Equal(t, [][]int{{0, 2}, {4}}, chunkBySize([]int{0, 2, 4}, sizes, 2, 100))
+	assert.Empty(t, chunkBySize(nil, sizes, 2, 100))
+}
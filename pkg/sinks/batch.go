@@ -0,0 +1,57 @@
+package sinks
+
+import (
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
+)
+
+// BatchConfig configures how a BatchSink buffers the events. The zero values fall back to the defaults of the sink.
+type BatchConfig struct {
+	// Size is the maximum number of events sent at once
+	Size int `yaml:"size"`
+	// Interval is the maximum time the events are buffered
+	Interval time.Duration `yaml:"interval"`
+	// MaxRetries is the number of times the failed events are retried with the next batches
+	MaxRetries int           `yaml:"maxRetries"`
+	Timeout    time.Duration `yaml:"timeout"`
+}
+
+// WriterConfig returns the configuration of the batch.Writer, using the given defaults for the unset values
+func (b BatchConfig) WriterConfig(defaults batch.WriterConfig) batch.WriterConfig {
+	cfg := defaults
+	if b.Size > 0 {
+		cfg.BatchSize = b.Size
+	}
+	if b.Interval > 0 {
+		cfg.Interval = b.Interval
+	}
+	if b.MaxRetries > 0 {
+		cfg.MaxRetries = b.MaxRetries
+	}
+	if b.Timeout > 0 {
+		cfg.Timeout = b.Timeout
+	}
+	return cfg
+}
+
+// chunkBySize splits the positions of the entries into chunks of at most maxCount entries and maxBytes bytes, in the
+// order of the entries. The entries are expected to fit in maxBytes on their own.
+func chunkBySize(positions []int, sizes []int, maxCount, maxBytes int) [][]int {
+	chunks := make([][]int, 0)
+	current := make([]int, 0)
+	currentBytes := 0
+	for _, i := range positions {
+		if len(current) > 0 && (len(current) >= maxCount || currentBytes+sizes[i] > maxBytes) {
+			chunks = append(chunks, current)
+			current = make([]int, 0)
+			currentBytes = 0
+		}
+		current = append(current, i)
+		currentBytes += sizes[i]
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
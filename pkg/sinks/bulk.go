@@ -0,0 +1,160 @@
+package sinks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+)
+
+// defaultBulkWriterConfig is used by the sinks of the _bulk API unless their batch is configured
+var defaultBulkWriterConfig = batch.WriterConfig{
+	BatchSize:  500,
+	Interval:   time.Second,
+	MaxRetries: 3,
+	Timeout:    30 * time.Second,
+}
+
+// bulkIndexer builds the requests of the _bulk API shared by Elasticsearch and OpenSearch and interprets their
+// responses
+type bulkIndexer struct {
+	deDot       bool
+	layout      map[string]any
+	index       string
+	indexFormat string
+	docType     string
+	useEventID  bool
+}
+
+type bulkAction struct {
+	Index bulkActionMeta `json:"index"`
+}
+
+type bulkActionMeta struct {
+	Index string `json:"_index,omitempty"`
+	// Type should not be used for clusters with ES8.0+.
+	Type string `json:"_type,omitempty"`
+	ID   string `json:"_id,omitempty"`
+}
+
+type bulkResponse struct {
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+type bulkItemResult struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+// body serializes the events into a bulk request. It returns the positions of the events in the body, the events which
+// cannot be serialized are left out and get a permanent error.
+func (b *bulkIndexer) body(evs []*kube.EnhancedEvent, now time.Time) (*bytes.Buffer, []int, []error) {
+	errs := make([]error, len(evs))
+	included := make([]int, 0, len(evs))
+	buf := new(bytes.Buffer)
+
+	index := b.index
+	if len(b.indexFormat) > 0 {
+		index = formatIndexName(b.indexFormat, now)
+	}
+
+	for i, ev := range evs {
+		doc, err := b.document(ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+
+		meta := bulkActionMeta{Index: index, Type: b.docType}
+		if b.useEventID {
+			meta.ID = string(ev.UID)
+		}
+		action, err := json.Marshal(bulkAction{Index: meta})
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(doc)
+		buf.WriteByte('\n')
+		included = append(included, i)
+	}
+	return buf, included, errs
+}
+
+func (b *bulkIndexer) document(ev *kube.EnhancedEvent) ([]byte, error) {
+	if b.deDot {
+		de := ev.DeDot()
+		ev = &de
+	}
+	if b.layout == nil {
+		return ev.ToJSON(), nil
+	}
+
+	res, err := convertLayoutTemplate(b.layout, ev)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(res)
+}
+
+// result sets the errors of the included events from the response. Rejected requests and items are permanent errors,
+// except for the rate limited ones and the server errors which are retried.
+func (b *bulkIndexer) result(statusCode int, respBody io.Reader, included []int, errs []error) {
+	if statusCode > 399 {
+		rb, _ := io.ReadAll(respBody)
+		err := fmt.Errorf("bulk request failed with status %d: %s", statusCode, string(rb))
+		if !retryableStatus(statusCode) {
+			err = Permanent(err)
+		}
+		setErrors(errs, included, err)
+		return
+	}
+
+	var resp bulkResponse
+	if err := json.NewDecoder(respBody).Decode(&resp); err != nil {
+		setErrors(errs, included, fmt.Errorf("cannot decode the bulk response: %w", err))
+		return
+	}
+	if !resp.Errors {
+		return
+	}
+	if len(resp.Items) != len(included) {
+		setErrors(errs, included, fmt.Errorf("bulk response has %d items for %d events", len(resp.Items), len(included)))
+		return
+	}
+
+	for n, item := range resp.Items {
+		for _, result := range item {
+			if result.Status < 300 {
+				continue
+			}
+
+			err := fmt.Errorf("indexing failed with status %d", result.Status)
+			if result.Error != nil {
+				err = fmt.Errorf("indexing failed with status %d: %s: %s", result.Status, result.Error.Type, result.Error.Reason)
+			}
+			if !retryableStatus(result.Status) {
+				err = Permanent(err)
+			}
+			errs[included[n]] = err
+		}
+	}
+}
+
+// setErrors sets the error of all the included events
+func setErrors(errs []error, included []int, err error) {
+	for _, i := range included {
+		errs[i] = err
+	}
+}
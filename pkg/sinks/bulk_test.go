@@ -0,0 +1,139 @@
+package sinks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// bulkServer answers the bulk requests with the statuses of the items by message
+func bulkServer(t *testing.T, statuses map[string]int, requests *[][]map[string]any) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/_bulk" {
+			w.Write([]byte(`{"version":{"number":"7.17.0"},"tagline":"You Know, for Search"}`))
+			return
+		}
+
+		lines := make([]map[string]any, 0)
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var line map[string]any
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			lines = append(lines, line)
+		}
+		*requests = append(*requests, lines)
+
+		items := make([]map[string]any, 0)
+		hasErrors := false
+		for i := 1; i < len(lines); i += 2 {
+			status := statuses[lines[i]["message"].(string)]
+			if status == 0 {
+				status = http.StatusCreated
+			}
+			item := map[string]any{"status": status}
+			if status > 299 {
+				hasErrors = true
+				item["error"] = map[string]any{"type": "some_exception", "reason": "failed"}
+			}
+			items = append(items, map[string]any{"index": item})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"errors": hasErrors, "items": items})
+	}))
+}
+
+func newBulkEvents(messages ...string) []*kube.EnhancedEvent {
+	evs := make([]*kube.EnhancedEvent, 0, len(messages))
+	for _, message := range messages {
+		ev := &kube.EnhancedEvent{}
+		ev.Message = message
+		ev.UID = types.UID("uid-" + message)
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func TestElasticsearchSendBatch(t *testing.T) {
+	requests := make([][]map[string]any, 0)
+	server := bulkServer(t, map[string]int{
+		"throttled": http.StatusTooManyRequests,
+		"invalid":   http.StatusBadRequest,
+	}, &requests)
+	defer server.Close()
+
+	es, err := NewElasticsearch(&ElasticsearchConfig{
+		Hosts:       []string{server.URL},
+		IndexFormat: "kube-events-{2006-01-02}",
+		UseEventID:  true,
+	})
+	require.NoError(t, err)
+
+	errs := es.SendBatch(context.Background(), newBulkEvents("ok", "throttled", "invalid"))
+
+	require.Len(t, errs, 3)
+	assert.NoError(t, errs[0])
+	assert.Error(t, errs[1])
+	assert.False(t, IsPermanent(errs[1]), "rate limited items are retried")
+	assert.Error(t, errs[2])
+	assert.True(t, IsPermanent(errs[2]), "rejected items are not retried")
+
+	require.Len(t, requests, 1)
+	require.Len(t, requests[0], 6)
+	action := requests[0][0]["index"].(map[string]any)
+	assert.Regexp(t, `^kube-events-\d{4}-\d{2}-\d{2}$`, action["_index"])
+	assert.Equal(t, "uid-ok", action["_id"])
+}
+
+func TestOpenSearchSendBatchLayout(t *testing.T) {
+	requests := make([][]map[string]any, 0)
+	server := bulkServer(t, nil, &requests)
+	defer server.Close()
+
+	os, err := NewOpenSearch(&OpenSearchConfig{
+		Hosts:  []string{server.URL},
+		Index:  "kube-events",
+		Layout: map[string]any{"message": "{{ .Message }}"},
+	})
+	require.NoError(t, err)
+
+	errs := os.SendBatch(context.Background(), newBulkEvents("a", "b"))
+	assert.Equal(t, []error{nil, nil}, errs)
+
+	require.Len(t, requests, 1)
+	assert.Equal(t, []map[string]any{
+		{"index": map[string]any{"_index": "kube-events"}},
+		{"message": "a"},
+		{"index": map[string]any{"_index": "kube-events"}},
+		{"message": "b"},
+	}, requests[0])
+}
+
+func TestBulkRequestFailure(t *testing.T) {
+	b := &bulkIndexer{}
+	errs := make([]error, 3)
+	b.result(http.StatusServiceUnavailable, strings.NewReader("unavailable"), []int{0, 2}, errs)
+	assert.Error(t, errs[0])
+	assert.False(t, IsPermanent(errs[0]))
+	assert.NoError(t, errs[1])
+
+	errs = make([]error, 1)
+	b.result(http.StatusUnauthorized, strings.NewReader("unauthorized"), []int{0}, errs)
+	assert.True(t, IsPermanent(errs[0]))
+}
+
+func TestBatchConfigWriterConfig(t *testing.T) {
+	cfg := BatchConfig{Size: 10}.WriterConfig(defaultBulkWriterConfig)
+	assert.Equal(t, 10, cfg.BatchSize)
+	assert.Equal(t, defaultBulkWriterConfig.Interval, cfg.Interval)
+	assert.Equal(t, defaultBulkWriterConfig.MaxRetries, cfg.MaxRetries)
+}
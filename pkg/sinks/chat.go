@@ -0,0 +1,235 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"golang.org/x/time/rate"
+)
+
+const (
+	DefaultChatText       = "{{ .Reason }}: {{ .InvolvedObject.Kind }} {{ with .InvolvedObject.Namespace }}{{ . }}/{{ end }}{{ .InvolvedObject.Name }}: {{ .Message }}"
+	DefaultChatMaxRetries = 3
+)
+
+// ChatConfig holds the settings shared by the sinks posting to the webhooks of the chat platforms
+type ChatConfig struct {
+	URL string `yaml:"url"`
+	// Text is a template of the text of the messages, it is truncated to the limit of the platform
+	Text    string            `yaml:"text"`
+	Headers map[string]string `yaml:"headers"`
+	TLS     TLS               `yaml:"tls"`
+	Timeout time.Duration     `yaml:"timeout"`
+	// RateLimit is the number of messages posted per second and Burst the number posted at once, the defaults follow
+	// the limits of the platform
+	RateLimit float64 `yaml:"rateLimit"`
+	Burst     int     `yaml:"burst"`
+	// MaxRetries is the number of times the rate limited and failed messages are retried, 3 by default
+	MaxRetries int `yaml:"maxRetries"`
+}
+
+// chatPlatform declares what differs between the chat platforms: the default rate limit and burst of their webhooks,
+// the key of the text in the payloads with its maximum length, and the key of the rich layout with the maximum lengths
+// of its strings by their keys
+type chatPlatform struct {
+	name      string
+	rateLimit float64
+	burst     int
+	textKey   string
+	maxText   int
+	layoutKey string
+	limits    map[string]int
+}
+
+// chatClient posts the messages of a chat sink, limiting their rate and retrying the rate limited and failed ones
+type chatClient struct {
+	chatPlatform
+	text       string
+	headers    map[string]string
+	maxRetries int
+	client     *http.Client
+	limiter    *rate.Limiter
+	// rateLimited tells whether a successful response reports a rate limit, for the platforms answering so
+	rateLimited func(body []byte) bool
+}
+
+// newChatClient validates the shared settings and sets their defaults from the ones of the platform
+func newChatClient(platform chatPlatform, cfg *ChatConfig) (*chatClient, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("%s url cannot be empty", platform.name)
+	}
+	if cfg.Text == "" {
+		cfg.Text = DefaultChatText
+	}
+	if err := ValidateTemplate(cfg.Text); err != nil {
+		return nil, fmt.Errorf("invalid %s text: %w", platform.name, err)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.RateLimit <= 0 {
+		cfg.RateLimit = platform.rateLimit
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = platform.burst
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = DefaultChatMaxRetries
+	}
+
+	tlsClientConfig, err := setupTLS(&cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup TLS: %w", err)
+	}
+	return &chatClient{
+		chatPlatform: platform,
+		text:         cfg.Text,
+		headers:      cfg.Headers,
+		maxRetries:   cfg.MaxRetries,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsClientConfig,
+			},
+		},
+		limiter: rate.NewLimiter(rate.Limit(cfg.RateLimit), cfg.Burst),
+	}, nil
+}
+
+// validate validates the templates overriding the settings of the webhook, by their keys in the payloads, and the
+// templates of the rich layout
+func (c *chatClient) validate(overrides map[string]string, layout []any) error {
+	for key, text := range overrides {
+		if err := ValidateTemplate(text); err != nil {
+			return fmt.Errorf("invalid %s %s: %w", c.name, key, err)
+		}
+	}
+	if err := validateTemplates(layout); err != nil {
+		return fmt.Errorf("invalid %s %s: %w", c.name, c.layoutKey, err)
+	}
+	return nil
+}
+
+// render renders the payload of a message: the text cut to the maximum length of the platform, the overrides left out
+// when rendered empty, and the rich layout with its strings cut to their limits
+func (c *chatClient) render(ev *kube.EnhancedEvent, overrides map[string]string, layout []any) (map[string]any, error) {
+	text, err := GetString(ev, c.text)
+	if err != nil {
+		return nil, err
+	}
+	payload := map[string]any{c.textKey: truncateText(text, c.maxText)}
+
+	for key, tmpl := range overrides {
+		value, err := GetString(ev, tmpl)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			payload[key] = value
+		}
+	}
+
+	if layout != nil {
+		rendered, err := convertTemplate(layout, ev)
+		if err != nil {
+			return nil, err
+		}
+		payload[c.layoutKey] = truncateFields(rendered, c.limits)
+	}
+	return payload, nil
+}
+
+// post posts the payload as JSON to the url. The rate limited messages are retried after the Retry-After delay of
+// the response and the failed ones with a backoff, the rejected ones are not retried.
+func (c *chatClient) post(ctx context.Context, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	return withRetries(ctx, c.name, c.maxRetries, func() (time.Duration, error) {
+		if err := c.limiter.Wait(ctx); err != nil {
+			return -1, err
+		}
+		return c.postOnce(ctx, url, body)
+	})
+}
+
+// postOnce posts the body, it returns how long to wait before retrying when the message is rate limited or -1
+func (c *chatClient) postOnce(ctx context.Context, url string, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return -1, Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	rb, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return -1, err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return retryAfter(resp.Header.Get("Retry-After"), -1), fmt.Errorf("%s rate limited: %s", c.name, string(rb))
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("%s responded with status %d: %s", c.name, resp.StatusCode, string(rb))
+		if !retryableStatus(resp.StatusCode) {
+			return -1, Permanent(err)
+		}
+		return -1, err
+	}
+	if c.rateLimited != nil && c.rateLimited(rb) {
+		return -1, fmt.Errorf("%s rate limited: %s", c.name, string(rb))
+	}
+	return -1, nil
+}
+
+func (c *chatClient) Close() {
+	c.client.CloseIdleConnections()
+}
+
+// truncateFields truncates the strings under the given keys of the maps in a rendered layout, at any depth, to keep
+// the rich messages within the limits of the platforms
+func truncateFields(value any, limits map[string]int) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for k, field := range v {
+			if s, ok := field.(string); ok {
+				if limit, ok := limits[k]; ok {
+					v[k] = truncateText(s, limit)
+				}
+				continue
+			}
+			v[k] = truncateFields(field, limits)
+		}
+	case []any:
+		for i := range v {
+			v[i] = truncateFields(v[i], limits)
+		}
+	}
+	return value
+}
+
+// truncateText cuts the text to at most n characters, ending it with an ellipsis when it is cut
+func truncateText(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return truncate(s, n-1) + "…"
+}
@@ -0,0 +1,220 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chatStandIn records the messages posted to it, answering with the statuses in order and then with 200
+type chatStandIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	messages []map[string]any
+	queries  []string
+	statuses []int
+}
+
+func newChatStandIn(t *testing.T, statuses ...int) *chatStandIn {
+	s := &chatStandIn{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var message map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&message))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.messages = append(s.messages, message)
+		s.queries = append(s.queries, r.URL.RawQuery)
+		if len(s.statuses) > 0 {
+			status := s.statuses[0]
+			s.statuses = s.statuses[1:]
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "0")
+			}
+			w.WriteHeader(status)
+		}
+	}))
+	return s
+}
+
+func newChatEvent() *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Reason = "BackOff"
+	ev.Type = "Warning"
+	ev.Message = "Back-off restarting failed container"
+	ev.InvolvedObject.Kind = "Pod"
+	ev.InvolvedObject.Namespace = "default"
+	ev.InvolvedObject.Name = "api-0"
+	return ev
+}
+
+func TestChatRetries(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	server := newChatStandIn(t, http.StatusTooManyRequests, http.StatusBadGateway)
+	defer server.Close()
+
+	sink, err := NewMattermostSink(&MattermostConfig{ChatConfig: ChatConfig{URL: server.URL, RateLimit: 1000}})
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(context.Background(), newChatEvent()))
+	assert.Len(t, server.messages, 3, "the rate limited and failed messages are retried")
+
+	server.statuses = []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	err = sink.Send(context.Background(), newChatEvent())
+	assert.ErrorContains(t, err, "mattermost rate limited")
+	assert.False(t, IsPermanent(err))
+	assert.Len(t, server.messages, 7)
+
+	server.statuses = []int{http.StatusBadRequest}
+	err = sink.Send(context.Background(), newChatEvent())
+	assert.True(t, IsPermanent(err))
+	assert.Len(t, server.messages, 8, "the rejected messages are not retried")
+}
+
+func TestChatRateLimit(t *testing.T) {
+	server := newChatStandIn(t)
+	defer server.Close()
+
+	sink, err := NewRocketChatSink(&RocketChatConfig{ChatConfig: ChatConfig{URL: server.URL, RateLimit: 20, Burst: 1}})
+	require.NoError(t, err)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, sink.Send(context.Background(), newChatEvent()))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+}
+
+func TestDiscordSend(t *testing.T) {
+	server := newChatStandIn(t)
+	defer server.Close()
+
+	sink, err := NewDiscordSink(&DiscordConfig{
+		ChatConfig: ChatConfig{URL: server.URL, Text: "{{ .Message }}{{ repeat 3000 \".\" }}"},
+		Username:   "{{ .ClusterName }}",
+		Embeds: []any{
+			map[string]any{
+				"title":       "{{ .Reason }}",
+				"description": "{{ repeat 5000 \"x\" }}",
+				"color":       15158332,
+				"fields": []any{
+					map[string]any{"name": "Object", "value": "{{ .InvolvedObject.Namespace }}/{{ .InvolvedObject.Name }}", "inline": true},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(context.Background(), newChatEvent()))
+
+	require.Len(t, server.messages, 1)
+	message := server.messages[0]
+	assert.Len(t, []rune(message["content"].(string)), discordPlatform.maxText)
+	assert.True(t, strings.HasSuffix(message["content"].(string), "…"))
+	assert.NotContains(t, message, "username", "the empty overrides are left out")
+
+	embed := message["embeds"].([]any)[0].(map[string]any)
+	assert.Equal(t, "BackOff", embed["title"])
+	assert.Equal(t, float64(15158332), embed["color"])
+	assert.Len(t, []rune(embed["description"].(string)), 4096)
+	assert.Equal(t, []any{map[string]any{"name": "Object", "value": "default/api-0", "inline": true}}, embed["fields"])
+}
+
+func TestMattermostSend(t *testing.T) {
+	server := newChatStandIn(t)
+	defer server.Close()
+
+	sink, err := NewMattermostSink(&MattermostConfig{
+		ChatConfig: ChatConfig{URL: server.URL},
+		Channel:    "{{ .InvolvedObject.Namespace }}-alerts",
+		Username:   "kubernetes",
+		Attachments: []any{
+			map[string]any{
+				"color": `{{ if eq .Type "Warning" }}#FF0000{{ else }}#00FF00{{ end }}`,
+				"title": "{{ .Reason }}",
+				"text":  "{{ .Message }}",
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(context.Background(), newChatEvent()))
+
+	assert.Equal(t, map[string]any{
+		"text":     "BackOff: Pod default/api-0: Back-off restarting failed container",
+		"channel":  "default-alerts",
+		"username": "kubernetes",
+		"attachments": []any{
+			map[string]any{"color": "#FF0000", "title": "BackOff", "text": "Back-off restarting failed container"},
+		},
+	}, server.messages[0])
+}
+
+func TestGoogleChatSend(t *testing.T) {
+	server := newChatStandIn(t)
+	defer server.Close()
+
+	sink, err := NewGoogleChatSink(&GoogleChatConfig{
+		ChatConfig: ChatConfig{URL: server.URL + "/v1/spaces/AAAA/messages?key=k&token=t"},
+		ThreadKey:  "{{ .InvolvedObject.Namespace }}/{{ .InvolvedObject.Name }}",
+		CardsV2: []any{
+			map[string]any{
+				"cardId": "event",
+				"card": map[string]any{
+					"header": map[string]any{"title": "{{ .Reason }}", "subtitle": "{{ .InvolvedObject.Name }}"},
+					"sections": []any{
+						map[string]any{"widgets": []any{map[string]any{"textParagraph": map[string]any{"text": "{{ .Message }}"}}}},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(context.Background(), newChatEvent()))
+
+	assert.Equal(t, "key=k&messageReplyOption=REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD&token=t", server.queries[0])
+	assert.Equal(t, map[string]any{"threadKey": "default/api-0"}, server.messages[0]["thread"])
+	card := server.messages[0]["cardsV2"].([]any)[0].(map[string]any)["card"].(map[string]any)
+	assert.Equal(t, map[string]any{"title": "BackOff", "subtitle": "api-0"}, card["header"])
+}
+
+func TestRocketChatSend(t *testing.T) {
+	server := newChatStandIn(t)
+	defer server.Close()
+
+	sink, err := NewRocketChatSink(&RocketChatConfig{
+		ChatConfig: ChatConfig{URL: server.URL, Text: "{{ .Message }}"},
+		Alias:      "kubernetes",
+		Emoji:      ":warning:",
+	})
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(context.Background(), newChatEvent()))
+
+	assert.Equal(t, map[string]any{
+		"text":  "Back-off restarting failed container",
+		"alias": "kubernetes",
+		"emoji": ":warning:",
+	}, server.messages[0])
+}
+
+func TestChatConfigErrors(t *testing.T) {
+	_, err := NewDiscordSink(&DiscordConfig{})
+	assert.ErrorContains(t, err, "discord url cannot be empty")
+
+	_, err = NewGoogleChatSink(&GoogleChatConfig{ChatConfig: ChatConfig{URL: "http://chat", Text: "{{ .Reason"}})
+	assert.ErrorContains(t, err, "invalid googlechat text")
+
+	_, err = NewMattermostSink(&MattermostConfig{ChatConfig: ChatConfig{URL: "http://chat"}, Attachments: []any{map[string]any{"text": "{{ .Reason"}}})
+	assert.ErrorContains(t, err, "invalid mattermost attachments")
+
+	_, err = NewRocketChatSink(&RocketChatConfig{ChatConfig: ChatConfig{URL: "http://chat"}, Channel: "{{ .Reason"})
+	assert.ErrorContains(t, err, "invalid rocketchat channel")
+}
@@ -0,0 +1,63 @@
+package sinks
+
+import (
+	"context"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+)
+
+var discordPlatform = chatPlatform{
+	name: "discord",
+	// Discord allows 30 messages per minute to a webhook
+	rateLimit: 0.5,
+	burst:     5,
+	textKey:   "content",
+	maxText:   2000,
+	layoutKey: "embeds",
+	// The limits are the lengths of the fields of the embeds Discord accepts
+	limits: map[string]int{
+		"title":       256,
+		"description": 4096,
+		"name":        256,
+		"value":       1024,
+		"text":        2048,
+	},
+}
+
+type DiscordConfig struct {
+	ChatConfig `yaml:",inline"`
+	// Username and AvatarURL are templates overriding the ones of the webhook
+	Username  string `yaml:"username"`
+	AvatarURL string `yaml:"avatarURL"`
+	// Embeds are the rich embeds of the messages, with templates in their strings
+	Embeds []any `yaml:"embeds"`
+}
+
+type DiscordSink struct {
+	cfg *DiscordConfig
+	*chatClient
+}
+
+func NewDiscordSink(cfg *DiscordConfig) (Sink, error) {
+	client, err := newChatClient(discordPlatform, &cfg.ChatConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.validate(cfg.overrides(), cfg.Embeds); err != nil {
+		return nil, err
+	}
+	return &DiscordSink{cfg: cfg, chatClient: client}, nil
+}
+
+// overrides are the templates overriding the settings of the webhook, by their keys in the payloads
+func (cfg *DiscordConfig) overrides() map[string]string {
+	return map[string]string{"username": cfg.Username, "avatar_url": cfg.AvatarURL}
+}
+
+func (d *DiscordSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	payload, err := d.render(ev, d.cfg.overrides(), d.cfg.Embeds)
+	if err != nil {
+		return err
+	}
+	return d.post(ctx, d.cfg.URL, payload)
+}
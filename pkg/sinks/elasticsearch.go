@@ -1,12 +1,8 @@
 package sinks
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"log/slog"
 	"net/http"
 	"regexp"
 	"strings"
@@ -14,6 +10,7 @@ import (
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 )
 
@@ -34,6 +31,8 @@ type ElasticsearchConfig struct {
 	Type        string         `yaml:"type"`
 	TLS         TLS            `yaml:"tls"`
 	Layout      map[string]any `yaml:"layout"`
+	// Batch configures the buffering of the events for the _bulk API
+	Batch BatchConfig `yaml:"batch"`
 }
 
 func NewElasticsearch(cfg *ElasticsearchConfig) (*Elasticsearch, error) {
@@ -67,12 +66,21 @@ func NewElasticsearch(cfg *ElasticsearchConfig) (*Elasticsearch, error) {
 	return &Elasticsearch{
 		client: client,
 		cfg:    cfg,
+		bulk: &bulkIndexer{
+			deDot:       cfg.DeDot,
+			layout:      cfg.Layout,
+			index:       cfg.Index,
+			indexFormat: cfg.IndexFormat,
+			docType:     cfg.Type,
+			useEventID:  cfg.UseEventID,
+		},
 	}, nil
 }
 
 type Elasticsearch struct {
 	client *elasticsearch.Client
 	cfg    *ElasticsearchConfig
+	bulk   *bulkIndexer
 }
 
 var regex = regexp.MustCompile(`(?s){(.*)}`)
@@ -96,62 +104,29 @@ func formatIndexName(pattern string, when time.Time) string {
 }
 
 func (e *Elasticsearch) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	var toSend []byte
-
-	if e.cfg.DeDot {
-		de := ev.DeDot()
-		ev = &de
-	}
-	if e.cfg.Layout != nil {
-		res, err := convertLayoutTemplate(e.cfg.Layout, ev)
-		if err != nil {
-			return err
-		}
-
-		toSend, err = json.Marshal(res)
-		if err != nil {
-			return err
-		}
-	} else {
-		toSend = ev.ToJSON()
-	}
-
-	var index string
-	if len(e.cfg.IndexFormat) > 0 {
-		now := time.Now()
-		index = formatIndexName(e.cfg.IndexFormat, now)
-	} else {
-		index = e.cfg.Index
-	}
-
-	req := esapi.IndexRequest{
-		Body:  bytes.NewBuffer(toSend),
-		Index: index,
-	}
-
-	// This should not be used for clusters with ES8.0+.
-	if len(e.cfg.Type) > 0 {
-		req.DocumentType = e.cfg.Type
-	}
+	return e.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
 
-	if e.cfg.UseEventID {
-		req.DocumentID = string(ev.UID)
+// SendBatch indexes the events with the _bulk API, only the events failed with a retryable error are retried
+func (e *Elasticsearch) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	body, included, errs := e.bulk.body(evs, time.Now())
+	if len(included) == 0 {
+		return errs
 	}
 
-	resp, err := req.Do(ctx, e.client)
+	resp, err := esapi.BulkRequest{Body: body}.Do(ctx, e.client)
 	if err != nil {
-		return err
+		setErrors(errs, included, err)
+		return errs
 	}
-
 	defer resp.Body.Close()
-	if resp.StatusCode > 399 {
-		rb, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-		slog.Error(fmt.Sprintf("Indexing failed: %s", string(rb)))
-	}
-	return nil
+
+	e.bulk.result(resp.StatusCode, resp.Body, included, errs)
+	return errs
+}
+
+func (e *Elasticsearch) BatchConfig() batch.WriterConfig {
+	return e.cfg.Batch.WriterConfig(defaultBulkWriterConfig)
 }
 
 func (e *Elasticsearch) Close() {
@@ -2,20 +2,29 @@ package sinks
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 )
 
+// The limits of the PutRecordBatch API
+const (
+	firehoseMaxRecords     = 500
+	firehoseMaxBatchBytes  = 4 * 1024 * 1024
+	firehoseMaxRecordBytes = 1000 * 1024
+)
+
 type FirehoseConfig struct {
 	DeliveryStreamName string         `yaml:"deliveryStreamName"`
 	Region             string         `yaml:"region"`
+	Endpoint           string         `yaml:"endpoint"`
 	Layout             map[string]any `yaml:"layout"`
 	// DeDot all labels and annotations in the event. For both the event and the involvedObject
-	DeDot bool `yaml:"deDot"`
+	DeDot bool        `yaml:"deDot"`
+	Batch BatchConfig `yaml:"batch"`
 }
 
 type FirehoseSink struct {
@@ -24,10 +33,7 @@ type FirehoseSink struct {
 }
 
 func NewFirehoseSink(cfg *FirehoseConfig) (Sink, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(cfg.Region),
-	},
-	)
+	sess, err := newAWSSession(cfg.Region, cfg.Endpoint)
 	if err != nil {
 		return nil, err
 	}
@@ -39,35 +45,62 @@ func NewFirehoseSink(cfg *FirehoseConfig) (Sink, error) {
 }
 
 func (f *FirehoseSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	var toSend []byte
+	return f.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
 
-	if f.cfg.DeDot {
-		de := ev.DeDot()
-		ev = &de
-	}
+// SendBatch puts the events with PutRecordBatch, split by its limits. Only the records failed for throttling or
+// internal errors are retried.
+func (f *FirehoseSink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	records := make([]*firehose.Record, len(evs))
+	sizes := make([]int, len(evs))
+	included := make([]int, 0, len(evs))
 
-	if f.cfg.Layout != nil {
-		res, err := convertLayoutTemplate(f.cfg.Layout, ev)
-		if err != nil {
-			return err
+	for i, ev := range evs {
+		if f.cfg.DeDot {
+			de := ev.DeDot()
+			ev = &de
 		}
 
-		toSend, err = json.Marshal(res)
+		data, err := serializeEventWithLayout(f.cfg.Layout, ev)
 		if err != nil {
-			return err
+			errs[i] = Permanent(err)
+			continue
+		}
+		sizes[i] = len(data)
+		if sizes[i] > firehoseMaxRecordBytes {
+			errs[i] = Permanent(fmt.Errorf("firehose record of %d bytes is too large", sizes[i]))
+			continue
 		}
-	} else {
-		toSend = ev.ToJSON()
+		records[i] = &firehose.Record{Data: data}
+		included = append(included, i)
 	}
 
-	_, err := f.svc.PutRecord(&firehose.PutRecordInput{
-		Record: &firehose.Record{
-			Data: toSend,
-		},
-		DeliveryStreamName: aws.String(f.cfg.DeliveryStreamName),
-	})
+	for _, chunk := range chunkBySize(included, sizes, firehoseMaxRecords, firehoseMaxBatchBytes) {
+		input := &firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String(f.cfg.DeliveryStreamName),
+			Records:            make([]*firehose.Record, 0, len(chunk)),
+		}
+		for _, i := range chunk {
+			input.Records = append(input.Records, records[i])
+		}
+
+		out, err := f.svc.PutRecordBatchWithContext(ctx, input)
+		if err != nil {
+			setErrors(errs, chunk, awsRequestError(err))
+			continue
+		}
+		for n, record := range out.RequestResponses {
+			if n < len(chunk) && record.ErrorCode != nil {
+				errs[chunk[n]] = awsEntryError(*record.ErrorCode, aws.StringValue(record.ErrorMessage), false)
+			}
+		}
+	}
+	return errs
+}
 
-	return err
+func (f *FirehoseSink) BatchConfig() batch.WriterConfig {
+	return f.cfg.Batch.WriterConfig(defaultAWSWriterConfig)
 }
 
 func (f *FirehoseSink) Close() {
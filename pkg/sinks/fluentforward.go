@@ -0,0 +1,413 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	FluentForwardModeMessage       = "message"
+	FluentForwardModeForward       = "forward"
+	FluentForwardModePackedForward = "packedForward"
+
+	DefaultFluentForwardTag = "kubernetes.events"
+)
+
+var defaultFluentForwardWriterConfig = batch.WriterConfig{
+	BatchSize:  500,
+	Interval:   time.Second,
+	MaxRetries: 3,
+	Timeout:    30 * time.Second,
+}
+
+func init() {
+	msgpack.RegisterExt(0, (*fluentEventTime)(nil))
+}
+
+type FluentForwardConfig struct {
+	// Address is the host:port of the forward input
+	Address string `yaml:"address"`
+	// Tag is a template rendered with the event, kubernetes.events by default
+	Tag string `yaml:"tag"`
+	// Mode is message to send every event on its own, forward, by default, to send the events of a tag together or
+	// packedForward to send them as a single binary stream
+	Mode string `yaml:"mode"`
+	// RequireAck waits for the server to acknowledge every chunk, the chunks not acknowledged are sent again
+	RequireAck bool `yaml:"requireAck"`
+	// SharedKey enables the handshake of the secure forward input, Username and Password are optional on top of it
+	SharedKey    string `yaml:"sharedKey"`
+	SelfHostname string `yaml:"selfHostname"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	TLS          struct {
+		Enable bool `yaml:"enable"`
+		TLS    `yaml:",inline"`
+	} `yaml:"tls"`
+	Timeout time.Duration  `yaml:"timeout"`
+	Layout  map[string]any `yaml:"layout"`
+	Batch   BatchConfig    `yaml:"batch"`
+}
+
+type FluentForwardSink struct {
+	cfg       *FluentForwardConfig
+	tlsConfig *tls.Config
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// fluentEventTime is the EventTime extension of the protocol, the time in seconds and nanoseconds
+type fluentEventTime time.Time
+
+func (t *fluentEventTime) MarshalMsgpack() ([]byte, error) {
+	b := make([]byte, 8)
+	tm := time.Time(*t)
+	binary.BigEndian.PutUint32(b, uint32(tm.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(tm.Nanosecond()))
+	return b, nil
+}
+
+func (t *fluentEventTime) UnmarshalMsgpack(b []byte) error {
+	if len(b) != 8 {
+		return fmt.Errorf("invalid fluent event time length %d", len(b))
+	}
+	*t = fluentEventTime(time.Unix(int64(binary.BigEndian.Uint32(b)), int64(binary.BigEndian.Uint32(b[4:]))))
+	return nil
+}
+
+// fluentEntry is an event in the Forward and PackedForward modes
+type fluentEntry struct {
+	_msgpack struct{} `msgpack:",as_array"`
+	Time     *fluentEventTime
+	Record   map[string]any
+}
+
+func NewFluentForwardSink(cfg *FluentForwardConfig) (Sink, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("fluentforward address cannot be empty")
+	}
+	if cfg.Tag == "" {
+		cfg.Tag = DefaultFluentForwardTag
+	}
+	if err := ValidateTemplate(cfg.Tag); err != nil {
+		return nil, fmt.Errorf("invalid fluentforward tag: %w", err)
+	}
+	if err := validateTemplates(cfg.Layout); err != nil {
+		return nil, fmt.Errorf("invalid fluentforward layout: %w", err)
+	}
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = FluentForwardModeForward
+	case FluentForwardModeMessage, FluentForwardModeForward, FluentForwardModePackedForward:
+	default:
+		return nil, fmt.Errorf("invalid fluentforward mode %q: can be one of 'message', 'forward' or 'packedForward'", cfg.Mode)
+	}
+	if cfg.SharedKey == "" && (cfg.Username != "" || cfg.Password != "") {
+		return nil, errors.New("fluentforward username and password require a shared key")
+	}
+	if cfg.SelfHostname == "" {
+		cfg.SelfHostname, _ = os.Hostname()
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	f := &FluentForwardSink{cfg: cfg}
+	if cfg.TLS.Enable {
+		tlsConfig, err := setupTLS(&cfg.TLS.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup TLS: %w", err)
+		}
+		f.tlsConfig = tlsConfig
+	}
+	return f, nil
+}
+
+func (f *FluentForwardSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	return f.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// SendBatch sends the events of every tag together, or one by one in the message mode. The connection is closed when
+// sending fails, so that the next attempt connects again.
+func (f *FluentForwardSink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	tags := make(map[string][]int)
+	order := make([]string, 0)
+	entries := make([]fluentEntry, len(evs))
+
+	for i, ev := range evs {
+		tag, err := GetString(ev, f.cfg.Tag)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		entries[i], err = f.entry(ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		if _, ok := tags[tag]; !ok {
+			order = append(order, tag)
+		}
+		tags[tag] = append(tags[tag], i)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, tag := range order {
+		positions := tags[tag]
+		if f.cfg.Mode == FluentForwardModeMessage {
+			for _, i := range positions {
+				errs[i] = f.send(ctx, func(option map[string]any) []any {
+					return []any{tag, entries[i].Time, entries[i].Record, option}
+				})
+			}
+			continue
+		}
+
+		group := make([]fluentEntry, 0, len(positions))
+		for _, i := range positions {
+			group = append(group, entries[i])
+		}
+		var err error
+		if f.cfg.Mode == FluentForwardModePackedForward {
+			err = f.sendPacked(ctx, tag, group)
+		} else {
+			err = f.send(ctx, func(option map[string]any) []any {
+				return []any{tag, group, option}
+			})
+		}
+		if err != nil {
+			setErrors(errs, positions, err)
+		}
+	}
+	return errs
+}
+
+func (f *FluentForwardSink) BatchConfig() batch.WriterConfig {
+	return f.cfg.Batch.WriterConfig(defaultFluentForwardWriterConfig)
+}
+
+// entry renders the record of the event, the event itself unless there is a layout
+func (f *FluentForwardSink) entry(ev *kube.EnhancedEvent) (fluentEntry, error) {
+	at := time.Now()
+	if ms := ev.GetTimestampMs(); ms > 0 {
+		at = time.UnixMilli(ms)
+	}
+	t := fluentEventTime(at)
+
+	if f.cfg.Layout != nil {
+		record, err := convertLayoutTemplate(f.cfg.Layout, ev)
+		return fluentEntry{Time: &t, Record: record}, err
+	}
+	var record map[string]any
+	if err := json.Unmarshal(ev.ToJSON(), &record); err != nil {
+		return fluentEntry{}, err
+	}
+	return fluentEntry{Time: &t, Record: record}, nil
+}
+
+func (f *FluentForwardSink) sendPacked(ctx context.Context, tag string, group []fluentEntry) error {
+	var stream bytes.Buffer
+	enc := msgpack.NewEncoder(&stream)
+	for _, entry := range group {
+		if err := enc.Encode(&entry); err != nil {
+			return Permanent(err)
+		}
+	}
+	return f.send(ctx, func(option map[string]any) []any {
+		option["size"] = len(group)
+		return []any{tag, stream.Bytes(), option}
+	})
+}
+
+// send writes the message built with the options, and waits for its ack when they are required. It must be called
+// with the lock held.
+func (f *FluentForwardSink) send(ctx context.Context, message func(option map[string]any) []any) error {
+	option := make(map[string]any)
+	chunk := ""
+	if f.cfg.RequireAck {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		chunk = base64.StdEncoding.EncodeToString(id)
+		option["chunk"] = chunk
+	}
+	b, err := msgpack.Marshal(message(option))
+	if err != nil {
+		return Permanent(err)
+	}
+
+	if f.conn == nil {
+		if err := f.connect(ctx); err != nil {
+			return err
+		}
+	}
+	if err := f.exchange(ctx, b, chunk); err != nil {
+		f.closeConn()
+		return err
+	}
+	return nil
+}
+
+func (f *FluentForwardSink) exchange(ctx context.Context, b []byte, chunk string) error {
+	deadline := time.Now().Add(f.cfg.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := f.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	if _, err := f.conn.Write(b); err != nil {
+		return err
+	}
+	if chunk == "" {
+		return nil
+	}
+
+	var ack struct {
+		Ack string `msgpack:"ack"`
+	}
+	if err := msgpack.NewDecoder(f.conn).Decode(&ack); err != nil {
+		return fmt.Errorf("cannot read the fluentforward ack: %w", err)
+	}
+	if ack.Ack != chunk {
+		return fmt.Errorf("fluentforward ack %q does not match the chunk %q", ack.Ack, chunk)
+	}
+	return nil
+}
+
+func (f *FluentForwardSink) connect(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: f.cfg.Timeout}
+	var conn net.Conn
+	var err error
+	if f.tlsConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: f.tlsConfig}).DialContext(ctx, "tcp", f.cfg.Address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", f.cfg.Address)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot connect to fluentforward %s: %w", f.cfg.Address, err)
+	}
+
+	if f.cfg.SharedKey != "" {
+		if err := conn.SetDeadline(time.Now().Add(f.cfg.Timeout)); err != nil {
+			conn.Close()
+			return err
+		}
+		if err := f.handshake(conn); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	f.conn = conn
+	return nil
+}
+
+// handshake authenticates the connection to a secure forward input. The server sends a HELO with a nonce, the client
+// answers with a PING proving it knows the shared key and the server proves it too in its PONG.
+// see: https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1#handshake-messages
+func (f *FluentForwardSink) handshake(conn net.Conn) error {
+	dec := msgpack.NewDecoder(conn)
+	var helo []any
+	if err := dec.Decode(&helo); err != nil {
+		return fmt.Errorf("cannot read the fluentforward HELO: %w", err)
+	}
+	if len(helo) != 2 || helo[0] != "HELO" {
+		return fmt.Errorf("unexpected fluentforward HELO: %v", helo)
+	}
+	options, _ := helo[1].(map[string]any)
+	nonce := fluentBytes(options["nonce"])
+	authSalt := fluentBytes(options["auth"])
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	passwordDigest := ""
+	if f.cfg.Username != "" || f.cfg.Password != "" {
+		passwordDigest = fluentDigest(authSalt, []byte(f.cfg.Username), []byte(f.cfg.Password))
+	}
+	ping, err := msgpack.Marshal([]any{
+		"PING",
+		f.cfg.SelfHostname,
+		salt,
+		fluentDigest(salt, []byte(f.cfg.SelfHostname), nonce, []byte(f.cfg.SharedKey)),
+		f.cfg.Username,
+		passwordDigest,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(ping); err != nil {
+		return err
+	}
+
+	var pong []any
+	if err := dec.Decode(&pong); err != nil {
+		return fmt.Errorf("cannot read the fluentforward PONG: %w", err)
+	}
+	if len(pong) != 5 || pong[0] != "PONG" {
+		return fmt.Errorf("unexpected fluentforward PONG: %v", pong)
+	}
+	if ok, _ := pong[1].(bool); !ok {
+		return Permanent(fmt.Errorf("fluentforward authentication failed: %v", pong[2]))
+	}
+	hostname, _ := pong[3].(string)
+	if pong[4] != fluentDigest(salt, []byte(hostname), nonce, []byte(f.cfg.SharedKey)) {
+		return Permanent(errors.New("fluentforward server does not know the shared key"))
+	}
+	return nil
+}
+
+// fluentDigest is the hex encoded SHA-512 of the parts of the handshake
+func fluentDigest(parts ...[]byte) string {
+	h := sha512.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fluentBytes returns the bytes of a value of the handshake, the servers send them as bin or str
+func fluentBytes(v any) []byte {
+	switch b := v.(type) {
+	case []byte:
+		return b
+	case string:
+		return []byte(b)
+	}
+	return nil
+}
+
+// closeConn closes the connection, it must be called with the lock held
+func (f *FluentForwardSink) closeConn() {
+	if f.conn != nil {
+		_ = f.conn.Close()
+		f.conn = nil
+	}
+}
+
+func (f *FluentForwardSink) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closeConn()
+}
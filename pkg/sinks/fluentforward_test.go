@@ -0,0 +1,305 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fluentServer is an in-process forward input recording the messages it receives
+type fluentServer struct {
+	t        *testing.T
+	listener net.Listener
+	// sharedKey enables the handshake, password is the one expected for the user
+	sharedKey string
+	password  string
+	// skipAcks is the number of chunks the server does not acknowledge before closing the connection
+	skipAcks int
+
+	mu       sync.Mutex
+	messages [][]any
+	conns    int
+}
+
+// newFluentServer starts the server once the options are set
+func newFluentServer(t *testing.T, options ...func(*fluentServer)) *fluentServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fluentServer{t: t, listener: listener}
+	for _, option := range options {
+		option(s)
+	}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fluentServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fluentServer) handle(conn net.Conn) {
+	defer conn.Close()
+	dec := msgpack.NewDecoder(conn)
+	if s.sharedKey != "" && !s.handshake(conn, dec) {
+		return
+	}
+
+	for {
+		var message []any
+		if err := dec.Decode(&message); err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.t.Logf("fluent server: %v", err)
+			}
+			return
+		}
+		option, _ := message[len(message)-1].(map[string]any)
+
+		s.mu.Lock()
+		skip := s.skipAcks > 0 && option["chunk"] != nil
+		if skip {
+			s.skipAcks--
+		} else {
+			s.messages = append(s.messages, message)
+		}
+		s.mu.Unlock()
+		if skip {
+			return
+		}
+
+		if chunk, ok := option["chunk"]; ok {
+			b, _ := msgpack.Marshal(map[string]any{"ack": chunk})
+			_, _ = conn.Write(b)
+		}
+	}
+}
+
+func (s *fluentServer) handshake(conn net.Conn, dec *msgpack.Decoder) bool {
+	nonce, authSalt := []byte("nonce"), []byte("salt")
+	b, _ := msgpack.Marshal([]any{"HELO", map[string]any{"nonce": nonce, "auth": authSalt, "keepalive": true}})
+	_, _ = conn.Write(b)
+
+	var ping []any
+	if err := dec.Decode(&ping); err != nil {
+		return false
+	}
+	hostname, salt := ping[1].(string), ping[2].([]byte)
+	ok := ping[3] == fluentDigest(salt, []byte(hostname), nonce, []byte(s.sharedKey))
+	if s.password != "" {
+		ok = ok && ping[5] == fluentDigest(authSalt, []byte(ping[4].(string)), []byte(s.password))
+	}
+
+	reason := ""
+	if !ok {
+		reason = "shared key mismatch"
+	}
+	b, _ = msgpack.Marshal([]any{"PONG", ok, reason, "fluent-bit", fluentDigest(salt, []byte("fluent-bit"), nonce, []byte(s.sharedKey))})
+	_, _ = conn.Write(b)
+	return ok
+}
+
+func (s *fluentServer) received() [][]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]any(nil), s.messages...)
+}
+
+func newFluentEvent(namespace, reason string) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Namespace = namespace
+	ev.Reason = reason
+	ev.Message = reason + " in " + namespace
+	ev.InvolvedObject.Namespace = namespace
+	ev.FirstTimestamp = metav1.NewTime(time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC))
+	return ev
+}
+
+func fluentTime(t *testing.T, v any) time.Time {
+	et, ok := v.(*fluentEventTime)
+	require.True(t, ok, "expected an EventTime, got %T", v)
+	return time.Time(*et)
+}
+
+func TestFluentForwardModes(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	layout := map[string]any{"reason": "{{ .Reason }}"}
+
+	t.Run("forward", func(t *testing.T) {
+		server := newFluentServer(t)
+		sink, err := NewFluentForwardSink(&FluentForwardConfig{
+			Address: server.listener.Addr().String(),
+			Tag:     "kube.{{ .InvolvedObject.Namespace }}",
+			Layout:  layout,
+		})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		errs := sink.(*FluentForwardSink).SendBatch(context.Background(), []*kube.EnhancedEvent{
+			newFluentEvent("a", "BackOff"),
+			newFluentEvent("b", "Pulled"),
+			newFluentEvent("a", "Created"),
+		})
+		assert.Equal(t, []error{nil, nil, nil}, errs)
+
+		// The events of a tag are sent together
+		require.Eventually(t, func() bool { return len(server.received()) == 2 }, time.Second, 10*time.Millisecond)
+		messages := server.received()
+		assert.Equal(t, "kube.a", messages[0][0])
+		entries := messages[0][1].([]any)
+		require.Len(t, entries, 2)
+		entry := entries[0].([]any)
+		assert.True(t, at.Equal(fluentTime(t, entry[0])))
+		assert.Equal(t, map[string]any{"reason": "BackOff"}, entry[1])
+		assert.Equal(t, map[string]any{"reason": "Created"}, entries[1].([]any)[1])
+		assert.Equal(t, "kube.b", messages[1][0])
+	})
+
+	t.Run("message", func(t *testing.T) {
+		server := newFluentServer(t)
+		sink, err := NewFluentForwardSink(&FluentForwardConfig{
+			Address: server.listener.Addr().String(),
+			Mode:    FluentForwardModeMessage,
+		})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		errs := sink.(*FluentForwardSink).SendBatch(context.Background(), []*kube.EnhancedEvent{
+			newFluentEvent("a", "BackOff"),
+			newFluentEvent("a", "Created"),
+		})
+		assert.Equal(t, []error{nil, nil}, errs)
+
+		require.Eventually(t, func() bool { return len(server.received()) == 2 }, time.Second, 10*time.Millisecond)
+		message := server.received()[0]
+		require.Len(t, message, 4)
+		assert.Equal(t, DefaultFluentForwardTag, message[0])
+		assert.True(t, at.Equal(fluentTime(t, message[1])))
+		// The record is the event without a layout
+		record := message[2].(map[string]any)
+		assert.Equal(t, "BackOff", record["reason"])
+		assert.Equal(t, "BackOff in a", record["message"])
+	})
+
+	t.Run("packedForward", func(t *testing.T) {
+		server := newFluentServer(t)
+		sink, err := NewFluentForwardSink(&FluentForwardConfig{
+			Address:    server.listener.Addr().String(),
+			Mode:       FluentForwardModePackedForward,
+			Layout:     layout,
+			RequireAck: true,
+		})
+		require.NoError(t, err)
+		defer sink.Close()
+
+		errs := sink.(*FluentForwardSink).SendBatch(context.Background(), []*kube.EnhancedEvent{
+			newFluentEvent("a", "BackOff"),
+			newFluentEvent("b", "Pulled"),
+		})
+		assert.Equal(t, []error{nil, nil}, errs)
+
+		messages := server.received()
+		require.Len(t, messages, 1)
+		option := messages[0][2].(map[string]any)
+		assert.EqualValues(t, 2, option["size"])
+		assert.NotEmpty(t, option["chunk"])
+
+		dec := msgpack.NewDecoder(bytes.NewReader(messages[0][1].([]byte)))
+		var reasons []any
+		for {
+			var entry []any
+			if err := dec.Decode(&entry); err != nil {
+				require.ErrorIs(t, err, io.EOF)
+				break
+			}
+			assert.True(t, at.Equal(fluentTime(t, entry[0])))
+			reasons = append(reasons, entry[1].(map[string]any)["reason"])
+		}
+		assert.Equal(t, []any{"BackOff", "Pulled"}, reasons)
+	})
+}
+
+func TestFluentForwardAck(t *testing.T) {
+	server := newFluentServer(t, func(s *fluentServer) { s.skipAcks = 1 })
+	sink, err := NewFluentForwardSink(&FluentForwardConfig{
+		Address:    server.listener.Addr().String(),
+		RequireAck: true,
+		Timeout:    time.Second,
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	// The chunk which is not acknowledged fails, and is sent again on a new connection
+	err = sink.Send(context.Background(), newFluentEvent("a", "BackOff"))
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+	assert.Empty(t, server.received())
+
+	require.NoError(t, sink.Send(context.Background(), newFluentEvent("a", "BackOff")))
+	assert.Len(t, server.received(), 1)
+	server.mu.Lock()
+	assert.Equal(t, 2, server.conns)
+	server.mu.Unlock()
+}
+
+func TestFluentForwardHandshake(t *testing.T) {
+	server := newFluentServer(t, func(s *fluentServer) {
+		s.sharedKey = "secret"
+		s.password = "hunter2"
+	})
+
+	sink, err := NewFluentForwardSink(&FluentForwardConfig{
+		Address:    server.listener.Addr().String(),
+		SharedKey:  "secret",
+		Username:   "exporter",
+		Password:   "hunter2",
+		RequireAck: true,
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+	require.NoError(t, sink.Send(context.Background(), newFluentEvent("a", "BackOff")))
+	assert.Len(t, server.received(), 1)
+
+	sink, err = NewFluentForwardSink(&FluentForwardConfig{
+		Address:   server.listener.Addr().String(),
+		SharedKey: "wrong",
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+	err = sink.Send(context.Background(), newFluentEvent("a", "BackOff"))
+	require.Error(t, err)
+	assert.True(t, IsPermanent(err))
+}
+
+func TestFluentForwardConfigErrors(t *testing.T) {
+	_, err := NewFluentForwardSink(&FluentForwardConfig{})
+	assert.Error(t, err)
+
+	_, err = NewFluentForwardSink(&FluentForwardConfig{Address: "localhost:24224", Mode: "compressed"})
+	assert.Error(t, err)
+
+	_, err = NewFluentForwardSink(&FluentForwardConfig{Address: "localhost:24224", Tag: "{{ .Reason"})
+	assert.Error(t, err)
+
+	_, err = NewFluentForwardSink(&FluentForwardConfig{Address: "localhost:24224", Username: "exporter"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,383 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+)
+
+const (
+	GELFProtocolUDP  = "udp"
+	GELFProtocolTCP  = "tcp"
+	GELFProtocolHTTP = "http"
+
+	GELFCompressionGzip = "gzip"
+	GELFCompressionZlib = "zlib"
+	GELFCompressionNone = "none"
+
+	// DefaultGELFChunkSize fits in the MTU of most networks, the chunks can be up to 8192 bytes on a LAN
+	DefaultGELFChunkSize = 1420
+	gelfMaxChunkSize     = 8192
+	gelfMaxChunks        = 128
+	// gelfChunkHeaderSize is the size of the magic bytes, the message id and the sequence of a chunk
+	gelfChunkHeaderSize = 12
+)
+
+// DefaultGELFLayout are the additional fields of the messages when no layout is given
+var DefaultGELFLayout = map[string]any{
+	"cluster":   "{{ .ClusterName }}",
+	"namespace": "{{ .InvolvedObject.Namespace }}",
+	"kind":      "{{ .InvolvedObject.Kind }}",
+	"name":      "{{ .InvolvedObject.Name }}",
+	"reason":    "{{ .Reason }}",
+	"type":      "{{ .Type }}",
+	"count":     "{{ .Count }}",
+}
+
+var gelfInvalidFieldChars = regexp.MustCompile(`[^\w.\-]`)
+
+type GELFConfig struct {
+	// Address is the host:port of the input for UDP and TCP, and its URL for HTTP
+	Address string `yaml:"address"`
+	// Protocol is udp, by default, tcp or http
+	Protocol string `yaml:"protocol"`
+	// Compression is gzip, by default, zlib or none for UDP, and gzip or none for HTTP. The TCP messages are not
+	// compressed.
+	Compression string `yaml:"compression"`
+	// ChunkSize is the maximum size of the UDP datagrams, the larger messages are split in chunks
+	ChunkSize int `yaml:"chunkSize"`
+	TLS       struct {
+		Enable bool `yaml:"enable"`
+		TLS    `yaml:",inline"`
+	} `yaml:"tls"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout time.Duration     `yaml:"timeout"`
+	// Layout are the additional fields of the messages, their names are prefixed with an underscore and the nested
+	// ones are flattened
+	Layout map[string]any `yaml:"layout"`
+}
+
+type GELFSink struct {
+	cfg        *GELFConfig
+	tlsConfig  *tls.Config
+	httpClient *http.Client
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func NewGELFSink(cfg *GELFConfig) (Sink, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("gelf address cannot be empty")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Layout == nil {
+		cfg.Layout = DefaultGELFLayout
+	}
+	if err := validateTemplates(cfg.Layout); err != nil {
+		return nil, fmt.Errorf("invalid gelf layout: %w", err)
+	}
+	if _, ok := cfg.Layout["id"]; ok {
+		return nil, errors.New("invalid gelf layout: the id field is reserved")
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.TLS.Enable || cfg.Protocol == GELFProtocolHTTP {
+		var err error
+		tlsConfig, err = setupTLS(&cfg.TLS.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup TLS: %w", err)
+		}
+	}
+
+	g := &GELFSink{cfg: cfg}
+	switch cfg.Protocol {
+	case "", GELFProtocolUDP:
+		cfg.Protocol = GELFProtocolUDP
+		if cfg.TLS.Enable {
+			return nil, errors.New("gelf tls is not supported over udp")
+		}
+		if cfg.Compression == "" {
+			cfg.Compression = GELFCompressionGzip
+		}
+		if cfg.ChunkSize <= 0 {
+			cfg.ChunkSize = DefaultGELFChunkSize
+		}
+		if cfg.ChunkSize <= gelfChunkHeaderSize || cfg.ChunkSize > gelfMaxChunkSize {
+			return nil, fmt.Errorf("invalid gelf chunk size %d: must be between %d and %d", cfg.ChunkSize, gelfChunkHeaderSize+1, gelfMaxChunkSize)
+		}
+	case GELFProtocolTCP:
+		if cfg.Compression != "" && cfg.Compression != GELFCompressionNone {
+			return nil, errors.New("gelf compression is not supported over tcp")
+		}
+		g.tlsConfig = tlsConfig
+	case GELFProtocolHTTP:
+		if cfg.Compression == GELFCompressionZlib {
+			return nil, errors.New("gelf zlib compression is not supported over http")
+		}
+		g.httpClient = &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		}
+	default:
+		return nil, fmt.Errorf("invalid gelf protocol %q: can be one of 'udp', 'tcp' or 'http'", cfg.Protocol)
+	}
+	switch cfg.Compression {
+	case "", GELFCompressionGzip, GELFCompressionZlib, GELFCompressionNone:
+	default:
+		return nil, fmt.Errorf("invalid gelf compression %q: can be one of 'gzip', 'zlib' or 'none'", cfg.Compression)
+	}
+	return g, nil
+}
+
+func (g *GELFSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	msg, err := g.message(ev)
+	if err != nil {
+		return Permanent(err)
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	switch g.cfg.Protocol {
+	case GELFProtocolHTTP:
+		return g.post(ctx, b)
+	case GELFProtocolTCP:
+		// The messages are delimited by a null byte
+		return g.write(ctx, append(b, 0))
+	}
+
+	b, err = gelfCompress(b, g.cfg.Compression)
+	if err != nil {
+		return Permanent(err)
+	}
+	chunks, err := gelfChunks(b, g.cfg.ChunkSize)
+	if err != nil {
+		return Permanent(err)
+	}
+	for _, chunk := range chunks {
+		if err := g.write(ctx, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// message maps the event to a GELF 1.1 message
+// see: https://go2docs.graylog.org/current/getting_in_log_data/gelf.html#GELFPayloadSpecification
+func (g *GELFSink) message(ev *kube.EnhancedEvent) (map[string]any, error) {
+	host := ev.Source.Host
+	if host == "" {
+		host = ev.ClusterName
+	}
+	if host == "" {
+		host = "kubernetes"
+	}
+	msg := map[string]any{
+		"version":       "1.1",
+		"host":          host,
+		"short_message": ev.Message,
+		"level":         syslogSeverity(ev.Type),
+	}
+	if ms := ev.GetTimestampMs(); ms > 0 {
+		msg["timestamp"] = float64(ms) / 1000
+	}
+
+	fields, err := convertLayoutTemplate(g.cfg.Layout, ev)
+	if err != nil {
+		return nil, err
+	}
+	gelfFlatten(msg, "_", fields)
+	return msg, nil
+}
+
+// gelfFlatten adds the fields with the prefix, the nested ones are joined by underscores and the lists are kept as
+// JSON since GELF only allows strings and numbers. The empty fields are left out.
+func gelfFlatten(msg map[string]any, prefix string, fields map[string]any) {
+	for k, v := range fields {
+		name := prefix + gelfInvalidFieldChars.ReplaceAllString(k, "_")
+		switch value := v.(type) {
+		case map[string]any:
+			gelfFlatten(msg, name+"_", value)
+		case string:
+			if value != "" {
+				msg[name] = value
+			}
+		case nil:
+		case bool:
+			msg[name] = fmt.Sprint(value)
+		case int, int64, uint64, float64:
+			msg[name] = value
+		default:
+			b, _ := json.Marshal(value)
+			msg[name] = string(b)
+		}
+	}
+}
+
+func gelfCompress(b []byte, compression string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case GELFCompressionGzip:
+		w = gzip.NewWriter(&buf)
+	case GELFCompressionZlib:
+		w = zlib.NewWriter(&buf)
+	default:
+		return b, nil
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gelfChunks splits a message larger than a datagram in chunks sharing a random id, with their sequence numbers
+func gelfChunks(b []byte, size int) ([][]byte, error) {
+	if len(b) <= size {
+		return [][]byte{b}, nil
+	}
+	payload := size - gelfChunkHeaderSize
+	count := (len(b) + payload - 1) / payload
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("gelf message of %d bytes needs more than %d chunks", len(b), gelfMaxChunks)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		data := b[i*payload : min((i+1)*payload, len(b))]
+		chunk := make([]byte, 0, gelfChunkHeaderSize+len(data))
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunks = append(chunks, append(chunk, data...))
+	}
+	return chunks, nil
+}
+
+// write writes to the UDP socket or the TCP connection, it is connected again after a failure
+func (g *GELFSink) write(ctx context.Context, b []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.conn == nil {
+		if err := g.connect(ctx); err != nil {
+			return err
+		}
+	}
+	deadline := time.Now().Add(g.cfg.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := g.conn.SetWriteDeadline(deadline); err != nil {
+		g.closeConn()
+		return err
+	}
+	if _, err := g.conn.Write(b); err != nil {
+		g.closeConn()
+		return err
+	}
+	return nil
+}
+
+func (g *GELFSink) connect(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: g.cfg.Timeout}
+	var conn net.Conn
+	var err error
+	if g.tlsConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: g.tlsConfig}).DialContext(ctx, "tcp", g.cfg.Address)
+	} else {
+		conn, err = dialer.DialContext(ctx, g.cfg.Protocol, g.cfg.Address)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot connect to gelf %s: %w", g.cfg.Address, err)
+	}
+	g.conn = conn
+	return nil
+}
+
+func (g *GELFSink) post(ctx context.Context, b []byte) error {
+	encoding := ""
+	if g.cfg.Compression == GELFCompressionGzip {
+		var err error
+		if b, err = gelfCompress(b, GELFCompressionGzip); err != nil {
+			return Permanent(err)
+		}
+		encoding = "gzip"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.cfg.Address, bytes.NewReader(b))
+	if err != nil {
+		return Permanent(err)
+	}
+	for k, v := range g.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("gelf responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		if !retryableStatus(resp.StatusCode) {
+			return Permanent(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// closeConn closes the connection, it must be called with the lock held
+func (g *GELFSink) closeConn() {
+	if g.conn != nil {
+		_ = g.conn.Close()
+		g.conn = nil
+	}
+}
+
+func (g *GELFSink) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closeConn()
+	if g.httpClient != nil {
+		g.httpClient.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,244 @@
+package sinks
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newGELFEvent(eventType, message string) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.ClusterName = "prod"
+	ev.Type = eventType
+	ev.Reason = "BackOff"
+	ev.Message = message
+	ev.Count = 2
+	ev.InvolvedObject.Kind = "Pod"
+	ev.InvolvedObject.Name = "nginx"
+	ev.InvolvedObject.Namespace = "default"
+	ev.FirstTimestamp = metav1.NewTime(time.Date(2024, 1, 2, 3, 4, 5, 250000000, time.UTC))
+	return ev
+}
+
+// readGELFDatagrams reads the datagrams of a message, reassembling its chunks and decompressing it
+func readGELFDatagrams(t *testing.T, conn net.PacketConn) map[string]any {
+	buf := make([]byte, 65536)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+	var payload []byte
+	var chunks [][]byte
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		datagram := append([]byte(nil), buf[:n]...)
+		if !bytes.HasPrefix(datagram, []byte{0x1e, 0x0f}) {
+			payload = datagram
+			break
+		}
+		require.LessOrEqual(t, n, 200)
+		seq, count := int(datagram[10]), int(datagram[11])
+		if chunks == nil {
+			chunks = make([][]byte, count)
+		}
+		chunks[seq] = datagram[12:]
+		if seq == count-1 {
+			payload = bytes.Join(chunks, nil)
+			break
+		}
+	}
+
+	var r io.Reader = bytes.NewReader(payload)
+	switch {
+	case bytes.HasPrefix(payload, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(r)
+		require.NoError(t, err)
+		r = gz
+	case payload[0] == 0x78:
+		zr, err := zlib.NewReader(r)
+		require.NoError(t, err)
+		r = zr
+	}
+	var msg map[string]any
+	require.NoError(t, json.NewDecoder(r).Decode(&msg))
+	return msg
+}
+
+func TestGELFUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, compression := range []string{GELFCompressionGzip, GELFCompressionZlib, GELFCompressionNone} {
+		t.Run(compression, func(t *testing.T) {
+			sink, err := NewGELFSink(&GELFConfig{
+				Address:     conn.LocalAddr().String(),
+				Compression: compression,
+				ChunkSize:   200,
+			})
+			require.NoError(t, err)
+			defer sink.Close()
+
+			require.NoError(t, sink.Send(context.Background(), newGELFEvent("Warning", "Back-off restarting failed container")))
+			msg := readGELFDatagrams(t, conn)
+			assert.Equal(t, map[string]any{
+				"version":       "1.1",
+				"host":          "prod",
+				"short_message": "Back-off restarting failed container",
+				"timestamp":     1704164645.25,
+				"level":         float64(4),
+				"_cluster":      "prod",
+				"_namespace":    "default",
+				"_kind":         "Pod",
+				"_name":         "nginx",
+				"_reason":       "BackOff",
+				"_type":         "Warning",
+				"_count":        "2",
+			}, msg)
+
+			// The messages larger than a datagram are chunked
+			long := strings.Repeat("a long message with some variety 0123456789 ", 200)
+			require.NoError(t, sink.Send(context.Background(), newGELFEvent("Normal", long)))
+			msg = readGELFDatagrams(t, conn)
+			assert.Equal(t, long, msg["short_message"])
+			assert.Equal(t, float64(6), msg["level"])
+		})
+	}
+}
+
+func TestGELFChunksLimit(t *testing.T) {
+	chunks, err := gelfChunks(make([]byte, 128*88), 100)
+	require.NoError(t, err)
+	assert.Len(t, chunks, 128)
+
+	_, err = gelfChunks(make([]byte, 128*88+1), 100)
+	assert.Error(t, err)
+}
+
+func TestGELFTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	messages := make(chan map[string]any, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					frame, err := r.ReadBytes(0)
+					if err != nil {
+						return
+					}
+					var msg map[string]any
+					if json.Unmarshal(frame[:len(frame)-1], &msg) == nil {
+						messages <- msg
+					}
+				}
+			}()
+		}
+	}()
+
+	ev := newGELFEvent("Warning", "Back-off restarting failed container")
+	ev.Source.Host = "node-1"
+	sink, err := NewGELFSink(&GELFConfig{
+		Address:  listener.Addr().String(),
+		Protocol: GELFProtocolTCP,
+		Layout: map[string]any{
+			"object": map[string]any{
+				"kind": "{{ .InvolvedObject.Kind }}",
+				"name": "{{ .InvolvedObject.Name }}",
+			},
+			"empty":    "{{ .InvolvedObject.FieldPath }}",
+			"app name": "nginx",
+		},
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.Send(context.Background(), ev))
+	require.NoError(t, sink.Send(context.Background(), ev))
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-messages:
+			assert.Equal(t, "node-1", msg["host"])
+			assert.Equal(t, "Pod", msg["_object_kind"])
+			assert.Equal(t, "nginx", msg["_object_name"])
+			assert.Equal(t, "nginx", msg["_app_name"])
+			assert.NotContains(t, msg, "_empty")
+		case <-time.After(5 * time.Second):
+			t.Fatal("the message was not received")
+		}
+	}
+}
+
+func TestGELFHTTP(t *testing.T) {
+	var received map[string]any
+	var encoding string
+	statusCode := http.StatusAccepted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		gz, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(gz).Decode(&received))
+		w.WriteHeader(statusCode)
+	}))
+	defer server.Close()
+
+	sink, err := NewGELFSink(&GELFConfig{
+		Address:     server.URL + "/gelf",
+		Protocol:    GELFProtocolHTTP,
+		Compression: GELFCompressionGzip,
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.Send(context.Background(), newGELFEvent("Custom", "Scaled up")))
+	assert.Equal(t, "gzip", encoding)
+	assert.Equal(t, "Scaled up", received["short_message"])
+	assert.Equal(t, float64(5), received["level"])
+
+	statusCode = http.StatusBadRequest
+	err = sink.Send(context.Background(), newGELFEvent("Custom", "Scaled up"))
+	require.Error(t, err)
+	assert.True(t, IsPermanent(err))
+
+	statusCode = http.StatusServiceUnavailable
+	err = sink.Send(context.Background(), newGELFEvent("Custom", "Scaled up"))
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+}
+
+func TestGELFConfigErrors(t *testing.T) {
+	for _, cfg := range []*GELFConfig{
+		{},
+		{Address: "localhost:12201", Protocol: "amqp"},
+		{Address: "localhost:12201", Compression: "lz4"},
+		{Address: "localhost:12201", ChunkSize: 10000},
+		{Address: "localhost:12201", Protocol: GELFProtocolTCP, Compression: GELFCompressionGzip},
+		{Address: "localhost:12201", Layout: map[string]any{"id": "{{ .UID }}"}},
+		{Address: "localhost:12201", Layout: map[string]any{"reason": "{{ .Reason"}},
+	} {
+		_, err := NewGELFSink(cfg)
+		assert.Error(t, err, "%+v", cfg)
+	}
+}
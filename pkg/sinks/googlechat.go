@@ -0,0 +1,82 @@
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+)
+
+const googleChatMaxTextLength = 4096
+
+var googleChatPlatform = chatPlatform{
+	name: "googlechat",
+	// Google Chat allows a message per second to a space
+	rateLimit: 1,
+	burst:     1,
+	textKey:   "text",
+	maxText:   googleChatMaxTextLength,
+	layoutKey: "cardsV2",
+	limits: map[string]int{
+		"title":    googleChatMaxTextLength,
+		"subtitle": googleChatMaxTextLength,
+		"text":     googleChatMaxTextLength,
+	},
+}
+
+type GoogleChatConfig struct {
+	ChatConfig `yaml:",inline"`
+	// CardsV2 are the cards of the messages, with templates in their strings
+	CardsV2 []any `yaml:"cardsV2"`
+	// ThreadKey is a template grouping the messages with the same key into a thread
+	ThreadKey string `yaml:"threadKey"`
+}
+
+type GoogleChatSink struct {
+	cfg *GoogleChatConfig
+	// url is the url of the webhook, replying in the threads when they are keyed
+	url string
+	*chatClient
+}
+
+func NewGoogleChatSink(cfg *GoogleChatConfig) (Sink, error) {
+	client, err := newChatClient(googleChatPlatform, &cfg.ChatConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.validate(map[string]string{"threadKey": cfg.ThreadKey}, cfg.CardsV2); err != nil {
+		return nil, err
+	}
+
+	webhookURL := cfg.URL
+	if cfg.ThreadKey != "" {
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid googlechat url: %w", err)
+		}
+		query := u.Query()
+		query.Set("messageReplyOption", "REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD")
+		u.RawQuery = query.Encode()
+		webhookURL = u.String()
+	}
+	return &GoogleChatSink{cfg: cfg, url: webhookURL, chatClient: client}, nil
+}
+
+func (g *GoogleChatSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	payload, err := g.render(ev, nil, g.cfg.CardsV2)
+	if err != nil {
+		return err
+	}
+
+	if g.cfg.ThreadKey != "" {
+		threadKey, err := GetString(ev, g.cfg.ThreadKey)
+		if err != nil {
+			return err
+		}
+		if threadKey != "" {
+			payload["thread"] = map[string]any{"threadKey": threadKey}
+		}
+	}
+	return g.post(ctx, g.url, payload)
+}
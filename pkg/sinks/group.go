@@ -0,0 +1,293 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+)
+
+const (
+	DefaultGroupSummary   = "{{ .Count }} event{{ if ne .Count 1 }}s{{ end }}{{ with .Key }} for {{ . }}{{ end }} across {{ len .Objects }} object{{ if ne (len .Objects) 1 }}s{{ end }}"
+	DefaultGroupMaxEvents = 100
+)
+
+// GroupConfig batches the events of a receiver into periodic digests, similar to the grouping of Alertmanager.
+// Events are grouped by the rendered By templates. The first digest of a group is sent Wait after its first event,
+// then at most every Interval while new events arrive. If the new events are only about the objects and reasons
+// already reported in the previous digest, the digest is postponed until RepeatInterval passes. A group without new
+// events for an Interval is forgotten.
+type GroupConfig struct {
+	By             []string      `yaml:"by"`
+	Wait           time.Duration `yaml:"wait"`
+	Interval       time.Duration `yaml:"interval"`
+	RepeatInterval time.Duration `yaml:"repeatInterval"`
+	// Summary is the template rendered with the EventGroup to build the message of the digest
+	Summary string `yaml:"summary"`
+	// MaxEvents is the number of events kept per group for the Summary template, the Count includes all of them
+	MaxEvents int `yaml:"maxEvents"`
+}
+
+func (g *GroupConfig) Validate() error {
+	if g.Interval <= 0 {
+		return errors.New("group interval must be positive")
+	}
+	if g.Wait < 0 || g.RepeatInterval < 0 {
+		return errors.New("group wait and repeatInterval cannot be negative")
+	}
+	for _, by := range g.By {
+		if err := ValidateTemplate(by); err != nil {
+			return fmt.Errorf("invalid group by template: %w", err)
+		}
+	}
+	if g.Summary != "" {
+		if err := ValidateTemplate(g.Summary); err != nil {
+			return fmt.Errorf("invalid group summary template: %w", err)
+		}
+	}
+	return nil
+}
+
+// EventGroup is the data the Summary template is rendered with
+type EventGroup struct {
+	// Key is the group by values joined by a slash
+	Key string
+	// Values are the rendered group by templates
+	Values []string
+	// Count is the number of events since the previous digest, Events keeps only the first MaxEvents of them
+	Count  int
+	Events []*kube.EnhancedEvent
+}
+
+// Objects returns the distinct involved objects of the events as Kind/Namespace/Name
+func (g *EventGroup) Objects() []string {
+	seen := make(map[string]bool)
+	objects := make([]string, 0)
+	for _, ev := range g.Events {
+		o := objectKey(ev)
+		if !seen[o] {
+			seen[o] = true
+			objects = append(objects, o)
+		}
+	}
+	sort.Strings(objects)
+	return objects
+}
+
+// Reasons returns the number of events per reason
+func (g *EventGroup) Reasons() map[string]int {
+	reasons := make(map[string]int)
+	for _, ev := range g.Events {
+		reasons[ev.Reason]++
+	}
+	return reasons
+}
+
+func objectKey(ev *kube.EnhancedEvent) string {
+	return ev.InvolvedObject.Kind + "/" + ev.InvolvedObject.Namespace + "/" + ev.InvolvedObject.Name
+}
+
+type eventGroup struct {
+	EventGroup
+	// reported holds the object and reason pairs of the previous digest
+	reported map[string]bool
+	lastSent time.Time
+	timer    *time.Timer
+}
+
+// GroupSink sends digests of the grouped events to the underlying sink instead of the events themselves
+type GroupSink struct {
+	cfg    *GroupConfig
+	sink   Sink
+	mu     sync.Mutex
+	groups map[string]*eventGroup
+	// sendMu serializes the digests since the underlying sink is not expected to be used concurrently
+	sendMu sync.Mutex
+	closed bool
+}
+
+func NewGroupSink(cfg *GroupConfig, sink Sink) (*GroupSink, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &GroupSink{
+		cfg:    cfg,
+		sink:   sink,
+		groups: make(map[string]*eventGroup),
+	}, nil
+}
+
+func (g *GroupSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	values := make([]string, 0, len(g.cfg.By))
+	for _, by := range g.cfg.By {
+		value, err := GetString(ev, by)
+		if err != nil {
+			return err
+		}
+		values = append(values, value)
+	}
+	key := strings.Join(values, "/")
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	group, ok := g.groups[key]
+	if !ok {
+		group = &eventGroup{
+			EventGroup: EventGroup{Key: key, Values: values},
+			reported:   make(map[string]bool),
+		}
+		g.groups[key] = group
+		group.timer = time.AfterFunc(g.cfg.Wait, func() {
+			g.flush(key)
+		})
+	}
+
+	group.Count++
+	if len(group.Events) < g.maxEvents() {
+		group.Events = append(group.Events, ev)
+	}
+	return nil
+}
+
+func (g *GroupSink) maxEvents() int {
+	if g.cfg.MaxEvents > 0 {
+		return g.cfg.MaxEvents
+	}
+	return DefaultGroupMaxEvents
+}
+
+// flush sends the digest of the group if it is due and schedules the next one
+func (g *GroupSink) flush(key string) {
+	g.mu.Lock()
+	group, ok := g.groups[key]
+	if !ok {
+		g.mu.Unlock()
+		return
+	}
+
+	if group.Count == 0 {
+		delete(g.groups, key)
+		g.mu.Unlock()
+		return
+	}
+
+	var digest *EventGroup
+	now := time.Now()
+	if g.isDue(group, now) {
+		digest = &EventGroup{
+			Key:    group.Key,
+			Values: group.Values,
+			Count:  group.Count,
+			Events: group.Events,
+		}
+		group.reported = reportedKeys(group.Events)
+		group.lastSent = now
+		group.Count = 0
+		group.Events = nil
+	}
+	group.timer = time.AfterFunc(g.cfg.Interval, func() {
+		g.flush(key)
+	})
+	g.mu.Unlock()
+
+	if digest != nil {
+		g.send(digest)
+	}
+}
+
+// isDue tells whether a digest is sent for the group. A digest is always sent if there is something not reported
+// before, otherwise only after the repeat interval.
+func (g *GroupSink) isDue(group *eventGroup, now time.Time) bool {
+	if group.lastSent.IsZero() || g.cfg.RepeatInterval == 0 || now.Sub(group.lastSent) >= g.cfg.RepeatInterval {
+		return true
+	}
+	for key := range reportedKeys(group.Events) {
+		if !group.reported[key] {
+			return true
+		}
+	}
+	return false
+}
+
+func reportedKeys(events []*kube.EnhancedEvent) map[string]bool {
+	keys := make(map[string]bool, len(events))
+	for _, ev := range events {
+		keys[objectKey(ev)+"/"+ev.Reason] = true
+	}
+	return keys
+}
+
+func (g *GroupSink) send(group *EventGroup) {
+	ev, err := g.digest(group)
+	if err == nil {
+		g.sendMu.Lock()
+		if !g.closed {
+			err = g.sink.Send(context.Background(), ev)
+		}
+		g.sendMu.Unlock()
+	}
+	if err != nil {
+		slog.With("group", group.Key, "err", err).Error("Cannot send the digest of the group")
+	}
+}
+
+// digest builds the event sent for a group. It is a copy of the last event of the group, with the rendered summary
+// as its message, the number of events as its count and the timestamps covering all the events.
+func (g *GroupSink) digest(group *EventGroup) (*kube.EnhancedEvent, error) {
+	summary := g.cfg.Summary
+	if summary == "" {
+		summary = DefaultGroupSummary
+	}
+	message, err := GetGroupString(group, summary)
+	if err != nil {
+		return nil, err
+	}
+
+	first := group.Events[0]
+	ev := *group.Events[len(group.Events)-1]
+	ev.Message = message
+	ev.Count = int32(group.Count)
+	ev.FirstTimestamp = first.FirstTimestamp
+	for _, e := range group.Events {
+		if e.Type == "Warning" {
+			ev.Type = "Warning"
+		}
+	}
+	return &ev, nil
+}
+
+// Close sends the digests of the pending events and closes the underlying sink
+func (g *GroupSink) Close() {
+	g.mu.Lock()
+	pending := make([]*EventGroup, 0)
+	for key, group := range g.groups {
+		group.timer.Stop()
+		if group.Count > 0 {
+			pending = append(pending, &EventGroup{
+				Key:    group.Key,
+				Values: group.Values,
+				Count:  group.Count,
+				Events: group.Events,
+			})
+		}
+		delete(g.groups, key)
+	}
+	g.mu.Unlock()
+
+	for _, group := range pending {
+		g.send(group)
+	}
+
+	g.sendMu.Lock()
+	defer g.sendMu.Unlock()
+	g.closed = true
+	g.sink.Close()
+}
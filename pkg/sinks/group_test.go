@@ -0,0 +1,134 @@
+package sinks
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingSink keeps the received events, it is safe to use from multiple goroutines
+type recordingSink struct {
+	mu     sync.Mutex
+	events []*kube.EnhancedEvent
+	closed bool
+}
+
+func (r *recordingSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+	return nil
+}
+
+func (r *recordingSink) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+}
+
+func (r *recordingSink) received() []*kube.EnhancedEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*kube.EnhancedEvent{}, r.events...)
+}
+
+func newGroupedEvent(namespace, name, reason string) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Namespace = namespace
+	ev.Reason = reason
+	ev.Type = "Warning"
+	ev.InvolvedObject.Kind = "Pod"
+	ev.InvolvedObject.Namespace = namespace
+	ev.InvolvedObject.Name = name
+	return ev
+}
+
+func TestGroupSinkDigest(t *testing.T) {
+	inner := &recordingSink{}
+	g, err := NewGroupSink(&GroupConfig{
+		By:       []string{"{{ .Namespace }}", "{{ .Reason }}"},
+		Wait:     20 * time.Millisecond,
+		Interval: time.Hour,
+		Summary:  "{{ .Count }} {{ index .Values 1 }} events in {{ index .Values 0 }} across {{ len .Objects }} pods",
+	}, inner)
+	require.NoError(t, err)
+
+	for i := 0; i < 14; i++ {
+		name := []string{"web-1", "web-2", "web-3"}[i%3]
+		require.NoError(t, g.Send(context.Background(), newGroupedEvent("x", name, "BackOff")))
+	}
+	require.NoError(t, g.Send(context.Background(), newGroupedEvent("y", "db-1", "BackOff")))
+
+	assert.Eventually(t, func() bool {
+		return len(inner.received()) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	messages := make([]string, 0)
+	for _, ev := range inner.received() {
+		messages = append(messages, ev.Message)
+	}
+	assert.ElementsMatch(t, []string{
+		"14 BackOff events in x across 3 pods",
+		"1 BackOff events in y across 1 pods",
+	}, messages)
+
+	g.Close()
+	assert.True(t, inner.closed)
+}
+
+func TestGroupSinkRepeatInterval(t *testing.T) {
+	inner := &recordingSink{}
+	g, err := NewGroupSink(&GroupConfig{
+		Interval:       20 * time.Millisecond,
+		RepeatInterval: time.Hour,
+	}, inner)
+	require.NoError(t, err)
+
+	require.NoError(t, g.Send(context.Background(), newGroupedEvent("x", "web-1", "BackOff")))
+	assert.Eventually(t, func() bool {
+		return len(inner.received()) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	// The same object and reason again is not reported before the repeat interval
+	require.NoError(t, g.Send(context.Background(), newGroupedEvent("x", "web-1", "BackOff")))
+	time.Sleep(60 * time.Millisecond)
+	assert.Len(t, inner.received(), 1)
+
+	// Something new is reported with the next interval, together with the postponed event
+	require.NoError(t, g.Send(context.Background(), newGroupedEvent("x", "web-2", "BackOff")))
+	assert.Eventually(t, func() bool {
+		return len(inner.received()) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, int32(2), inner.received()[1].Count)
+	assert.Equal(t, "2 events across 2 objects", inner.received()[1].Message)
+
+	g.Close()
+}
+
+func TestGroupSinkCloseFlushesPending(t *testing.T) {
+	inner := &recordingSink{}
+	g, err := NewGroupSink(&GroupConfig{
+		Wait:     time.Hour,
+		Interval: time.Hour,
+	}, inner)
+	require.NoError(t, err)
+
+	require.NoError(t, g.Send(context.Background(), newGroupedEvent("x", "web-1", "BackOff")))
+	g.Close()
+
+	require.Len(t, inner.received(), 1)
+	assert.Equal(t, "1 event across 1 object", inner.received()[0].Message)
+	assert.True(t, inner.closed)
+}
+
+func TestGroupConfigValidate(t *testing.T) {
+	assert.Error(t, (&GroupConfig{}).Validate())
+	assert.Error(t, (&GroupConfig{Interval: time.Minute, Wait: -time.Second}).Validate())
+	assert.Error(t, (&GroupConfig{Interval: time.Minute, By: []string{"{{ .Reason"}}).Validate())
+	assert.NoError(t, (&GroupConfig{Interval: time.Minute}).Validate())
+}
@@ -6,7 +6,7 @@ import (
 )
 
 type InMemoryConfig struct {
-	Ref *InMemory
+	Ref *InMemory `yaml:"-"`
 }
 
 type InMemory struct {
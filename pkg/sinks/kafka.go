@@ -4,21 +4,30 @@ import (
 	"context"
 	"crypto/sha256"
 	"crypto/sha512"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/metrics"
 
 	"github.com/xdg-go/scram"
 )
 
+// DefaultKafkaKey keys the messages by the event, so that the updates of an event land in the same partition
+const DefaultKafkaKey = "{{ .UID }}"
+
 // KafkaConfig is the Kafka producer configuration
 type KafkaConfig struct {
+	// Topic is a template rendered with the event
 	Topic            string         `yaml:"topic"`
 	Brokers          []string       `yaml:"brokers"`
 	Layout           map[string]any `yaml:"layout"`
@@ -26,32 +35,74 @@ type KafkaConfig struct {
 	CompressionCodec string         `yaml:"compressionCodec" default:"none"`
 	Version          string         `yaml:"version"`
 	TLS              struct {
-		Enable             bool   `yaml:"enable"`
-		CaFile             string `yaml:"caFile"`
-		CertFile           string `yaml:"certFile"`
-		KeyFile            string `yaml:"keyFile"`
-		InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+		Enable bool `yaml:"enable"`
+		TLS    `yaml:",inline"`
 	} `yaml:"tls"`
 	SASL struct {
-		Enable    bool   `yaml:"enable"`
-		Username  string `yaml:"username"`
-		Password  string `yaml:"password"`
+		Enable   bool   `yaml:"enable"`
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+		// Mechanism is one of plain, sha256, sha512, oauthbearer or aws-msk-iam
 		Mechanism string `yaml:"mechanism" default:"plain"`
+		// OAuth configures the token fetching of oauthbearer
+		OAuth KafkaOAuthConfig `yaml:"oauth"`
+		// Region is the region of the MSK cluster for aws-msk-iam
+		Region string `yaml:"region"`
 	} `yaml:"sasl"`
 	KafkaEncode Avro `yaml:"avro"`
+	// SchemaRegistry encodes the values in the wire format of the Confluent Schema Registry, instead of avro
+	SchemaRegistry *SchemaRegistryConfig `yaml:"schemaRegistry"`
+
+	// Key is a template rendered with the event, the messages with an empty key are spread over the partitions
+	Key string `yaml:"key"`
+	// Headers are templates rendered with the event, the empty ones are left out
+	Headers  map[string]string   `yaml:"headers"`
+	Producer KafkaProducerConfig `yaml:"producer"`
+	// ConnectTimeout bounds the connection to the brokers on startup, 10s by default
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
+}
+
+const defaultKafkaConnectTimeout = 10 * time.Second
+
+// KafkaProducerConfig tunes the producer
+type KafkaProducerConfig struct {
+	// Async enqueues the events without waiting for the brokers, the results are only reported in the metrics
+	Async bool `yaml:"async"`
+	// Acks is the acknowledgement required from the brokers, one of none, leader or all
+	Acks string `yaml:"acks"`
+	// Idempotent makes the producer write every message exactly once per partition, it requires all the acks
+	Idempotent bool `yaml:"idempotent"`
+	// Linger is the maximum time the messages are buffered before they are sent
+	Linger time.Duration `yaml:"linger"`
+	// BatchSize and BatchBytes are the number of messages and bytes which trigger a send before the linger
+	BatchSize  int `yaml:"batchSize"`
+	BatchBytes int `yaml:"batchBytes"`
+	// Settings override the other producer settings of sarama by their path, such as retry.max or maxMessageBytes
+	Settings map[string]any `yaml:"settings"`
+}
+
+var kafkaAcks = map[string]sarama.RequiredAcks{
+	"none":   sarama.NoResponse,
+	"leader": sarama.WaitForLocal,
+	"all":    sarama.WaitForAll,
 }
 
 // KafkaEncoder is an interface type for adding an
 // encoder to the kafka data pipeline
 type KafkaEncoder interface {
-	encode([]byte) ([]byte, error)
+	encode(ctx context.Context, topic string, value []byte) ([]byte, error)
 }
 
 // KafkaSink is a sink that sends events to a Kafka topic
 type KafkaSink struct {
-	producer sarama.SyncProducer
-	cfg      *KafkaConfig
-	encoder  KafkaEncoder
+	// Only one of the producers is set, depending on the producer mode
+	syncProducer  sarama.SyncProducer
+	asyncProducer sarama.AsyncProducer
+	cfg           *KafkaConfig
+	encoder       KafkaEncoder
+	metricsStore  *metrics.Store
+	// drained is done once the results of the async producer are all consumed
+	drained sync.WaitGroup
 }
 
 var CompressionCodecs = map[string]sarama.CompressionCodec{
@@ -62,75 +113,223 @@ var CompressionCodecs = map[string]sarama.CompressionCodec{
 	"zstd":   sarama.CompressionZSTD,
 }
 
-func NewKafkaSink(cfg *KafkaConfig) (Sink, error) {
-	var avro KafkaEncoder
-	producer, err := createSaramaProducer(cfg)
+func NewKafkaSink(cfg *KafkaConfig, metricsStore *metrics.Store) (Sink, error) {
+	if cfg.Key == "" {
+		cfg.Key = DefaultKafkaKey
+	}
+	if err := validateKafkaTemplates(cfg); err != nil {
+		return nil, err
+	}
+
+	saramaConfig, err := newSaramaConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	slog.Info(fmt.Sprintf("kafka: Producer initialized for topic: %s, brokers: %s", cfg.Topic, cfg.Brokers))
-	if len(cfg.KafkaEncode.SchemaID) > 0 {
-		var err error
-		avro, err = NewAvroEncoder(cfg.KafkaEncode.SchemaID, cfg.KafkaEncode.Schema)
+	var encoder KafkaEncoder
+	if cfg.SchemaRegistry != nil {
+		encoder, err = NewSchemaRegistryEncoder(cfg.SchemaRegistry)
+		if err != nil {
+			return nil, err
+		}
+		slog.Info(fmt.Sprintf("kafka: Producer using the schema registry %s with %s encoding", cfg.SchemaRegistry.URL, cfg.SchemaRegistry.Format))
+	} else if len(cfg.KafkaEncode.SchemaID) > 0 {
+		encoder, err = NewAvroEncoder(cfg.KafkaEncode.SchemaID, cfg.KafkaEncode.Schema)
 		if err != nil {
 			return nil, err
 		}
 		slog.Info(fmt.Sprintf("kafka: Producer using avro encoding with schemaid: %s", cfg.KafkaEncode.SchemaID))
 	}
 
-	return &KafkaSink{
-		producer: producer,
-		cfg:      cfg,
-		encoder:  avro,
-	}, nil
+	if err := checkKafkaConnectivity(cfg, saramaConfig); err != nil {
+		return nil, err
+	}
+
+	k := &KafkaSink{
+		cfg:          cfg,
+		encoder:      encoder,
+		metricsStore: metricsStore,
+	}
+	if cfg.Producer.Async {
+		producer, err := sarama.NewAsyncProducer(cfg.Brokers, saramaConfig)
+		if err != nil {
+			return nil, err
+		}
+		k.startAsync(producer)
+	} else {
+		k.syncProducer, err = sarama.NewSyncProducer(cfg.Brokers, saramaConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slog.Info(fmt.Sprintf("kafka: Producer initialized for topic: %s, brokers: %s, async: %t", cfg.Topic, cfg.Brokers, cfg.Producer.Async))
+	return k, nil
+}
+
+func validateKafkaTemplates(cfg *KafkaConfig) error {
+	if cfg.Topic == "" {
+		return errors.New("kafka topic cannot be empty")
+	}
+	if err := ValidateTemplate(cfg.Topic); err != nil {
+		return fmt.Errorf("invalid kafka topic: %w", err)
+	}
+	if err := ValidateTemplate(cfg.Key); err != nil {
+		return fmt.Errorf("invalid kafka key: %w", err)
+	}
+	for name, text := range cfg.Headers {
+		if err := ValidateTemplate(text); err != nil {
+			return fmt.Errorf("invalid kafka header %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// startAsync drains the results of the async producer into the metrics until it is closed
+func (k *KafkaSink) startAsync(producer sarama.AsyncProducer) {
+	k.asyncProducer = producer
+	k.drained.Add(2)
+	go func() {
+		defer k.drained.Done()
+		for msg := range producer.Successes() {
+			k.produced(msg.Topic)
+		}
+	}()
+	go func() {
+		defer k.drained.Done()
+		for err := range producer.Errors() {
+			k.failed(err.Msg.Topic)
+			slog.With("topic", err.Msg.Topic).Error("kafka: Cannot produce the message", "err", err.Err)
+		}
+	}()
 }
 
-// Send an event to Kafka synchronously
+func (k *KafkaSink) produced(topic string) {
+	if k.metricsStore != nil {
+		k.metricsStore.KafkaMessagesProduced.WithLabelValues(topic).Inc()
+	}
+}
+
+func (k *KafkaSink) failed(topic string) {
+	if k.metricsStore != nil {
+		k.metricsStore.KafkaProduceErrors.WithLabelValues(topic).Inc()
+	}
+}
+
+// Send an event to Kafka. In the async mode the event is only enqueued and the errors are reported in the metrics.
 func (k *KafkaSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	var toSend []byte
+	msg, err := k.message(ctx, ev)
+	if err != nil {
+		return err
+	}
 
-	if k.cfg.Layout != nil {
-		res, err := convertLayoutTemplate(k.cfg.Layout, ev)
+	if k.asyncProducer != nil {
+		select {
+		case k.asyncProducer.Input() <- msg:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if _, _, err := k.syncProducer.SendMessage(msg); err != nil {
+		k.failed(msg.Topic)
+		return err
+	}
+	k.produced(msg.Topic)
+	return nil
+}
+
+// message builds the message of the event with the rendered topic, key and headers
+func (k *KafkaSink) message(ctx context.Context, ev *kube.EnhancedEvent) (*sarama.ProducerMessage, error) {
+	topic, err := GetString(ev, k.cfg.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if topic == "" {
+		return nil, Permanent(errors.New("kafka topic rendered empty"))
+	}
+
+	value, err := k.value(ctx, topic, ev)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Value: sarama.ByteEncoder(value),
+	}
+
+	key, err := GetString(ev, k.cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+
+	names := make([]string, 0, len(k.cfg.Headers))
+	for name := range k.cfg.Headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		header, err := GetString(ev, k.cfg.Headers[name])
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if header == "" {
+			continue
 		}
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(name), Value: []byte(header)})
+	}
+	return msg, nil
+}
 
-		toSend, err = json.Marshal(res)
+// value serializes the event with the layout, and encodes it with the schema registry if configured. The legacy avro
+// encoding is only used without a layout.
+func (k *KafkaSink) value(ctx context.Context, topic string, ev *kube.EnhancedEvent) ([]byte, error) {
+	value := ev.ToJSON()
+	if k.cfg.Layout != nil {
+		res, err := convertLayoutTemplate(k.cfg.Layout, ev)
 		if err != nil {
-			return err
+			return nil, err
 		}
-	} else if len(k.cfg.KafkaEncode.SchemaID) > 0 {
-		var err error
-		toSend, err = k.encoder.encode(ev.ToJSON())
+		value, err = json.Marshal(res)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if k.cfg.SchemaRegistry == nil {
+			return value, nil
 		}
-	} else {
-		toSend = ev.ToJSON()
 	}
-
-	_, _, err := k.producer.SendMessage(&sarama.ProducerMessage{
-		Topic: k.cfg.Topic,
-		Key:   sarama.StringEncoder(string(ev.UID)),
-		Value: sarama.ByteEncoder(toSend),
-	})
-
-	return err
+	if k.encoder != nil {
+		return k.encoder.encode(ctx, topic, value)
+	}
+	return value, nil
 }
 
 // Close the Kafka producer
 func (k *KafkaSink) Close() {
 	slog.Info("kafka: Closing producer...")
 
-	if err := k.producer.Close(); err != nil {
+	if k.asyncProducer != nil {
+		// The buffered messages are flushed and their results drained before returning
+		k.asyncProducer.AsyncClose()
+		k.drained.Wait()
+		slog.Info("kafka: Closed producer")
+		return
+	}
+
+	if err := k.syncProducer.Close(); err != nil {
 		slog.Error("Failed to shut down the Kafka producer cleanly", "err", err)
 	} else {
 		slog.Info("kafka: Closed producer")
 	}
 }
 
-func createSaramaProducer(cfg *KafkaConfig) (sarama.SyncProducer, error) {
+// newSaramaConfig builds the sarama configuration of the producer and validates it
+func newSaramaConfig(cfg *KafkaConfig) (*sarama.Config, error) {
 	// Default Sarama config
 	saramaConfig := sarama.NewConfig()
 	if cfg.Version != "" {
@@ -145,38 +344,24 @@ func createSaramaProducer(cfg *KafkaConfig) (sarama.SyncProducer, error) {
 	saramaConfig.Metadata.Full = true
 	saramaConfig.ClientID = cfg.ClientId
 
-	// Necessary for SyncProducer
+	// Necessary for SyncProducer, and drained into the metrics by the async one
 	saramaConfig.Producer.Return.Successes = true
 	saramaConfig.Producer.Return.Errors = true
 	if _, ok := CompressionCodecs[cfg.CompressionCodec]; ok {
 		saramaConfig.Producer.Compression = CompressionCodecs[cfg.CompressionCodec]
 	}
+	if err := applyKafkaProducerConfig(saramaConfig, &cfg.Producer); err != nil {
+		return nil, err
+	}
 
 	// TLS Client auth override
 	if cfg.TLS.Enable {
-
-		caCert, err := os.ReadFile(cfg.TLS.CaFile)
+		tlsConfig, err := setupTLS(&cfg.TLS.TLS)
 		if err != nil {
-			return nil, fmt.Errorf("error loading ca file: %w", err)
+			return nil, fmt.Errorf("failed to setup TLS: %w", err)
 		}
-
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-
 		saramaConfig.Net.TLS.Enable = true
-		saramaConfig.Net.TLS.Config = &tls.Config{
-			RootCAs:            caCertPool,
-			InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
-		}
-
-		if cfg.TLS.CertFile != "" && cfg.TLS.KeyFile != "" {
-			cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
-			if err != nil {
-				return nil, err
-			}
-
-			saramaConfig.Net.TLS.Config.Certificates = []tls.Certificate{cert}
-		}
+		saramaConfig.Net.TLS.Config = tlsConfig
 	}
 
 	// SASL Client auth
@@ -184,28 +369,181 @@ func createSaramaProducer(cfg *KafkaConfig) (sarama.SyncProducer, error) {
 		saramaConfig.Net.SASL.Enable = true
 		saramaConfig.Net.SASL.User = cfg.SASL.Username
 		saramaConfig.Net.SASL.Password = cfg.SASL.Password
-		if cfg.SASL.Mechanism == "sha512" {
+		switch cfg.SASL.Mechanism {
+		case "sha512":
 			saramaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &XDGSCRAMClient{HashGeneratorFcn: SHA512} }
 			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
-		} else if cfg.SASL.Mechanism == "sha256" {
+		case "sha256":
 			saramaConfig.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &XDGSCRAMClient{HashGeneratorFcn: SHA256} }
 			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
-		} else if cfg.SASL.Mechanism == "plain" || cfg.SASL.Mechanism == "" {
+		case "plain", "":
 			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypePlaintext
-		} else {
-			return nil, fmt.Errorf("invalid scram sha mechanism: %s: can be one of 'sha256', 'sha512' or 'plain'", cfg.SASL.Mechanism)
+		case "oauthbearer":
+			provider, err := newOAuthTokenProvider(&cfg.SASL.OAuth)
+			if err != nil {
+				return nil, err
+			}
+			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypeOAuth
+			saramaConfig.Net.SASL.TokenProvider = provider
+		case "aws-msk-iam":
+			if !cfg.TLS.Enable {
+				return nil, errors.New("aws-msk-iam requires tls to be enabled")
+			}
+			provider, err := newMSKIAMTokenProvider(cfg.SASL.Region)
+			if err != nil {
+				return nil, err
+			}
+			saramaConfig.Net.SASL.Mechanism = sarama.SASLTypeOAuth
+			saramaConfig.Net.SASL.TokenProvider = provider
+		default:
+			return nil, fmt.Errorf("invalid sasl mechanism: %s: can be one of 'plain', 'sha256', 'sha512', 'oauthbearer' or 'aws-msk-iam'", cfg.SASL.Mechanism)
 		}
 	}
 
-	// TODO: Find a generic way to override all other configs
+	if err := saramaConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid kafka producer configuration: %w", err)
+	}
+	return saramaConfig, nil
+}
+
+// checkKafkaConnectivity connects to the brokers and fetches the metadata within the connect timeout, so that
+// unreachable brokers or rejected credentials fail the startup with a clear error instead of retrying for long
+func checkKafkaConnectivity(cfg *KafkaConfig, saramaConfig *sarama.Config) error {
+	timeout := cfg.ConnectTimeout
+	if timeout <= 0 {
+		timeout = defaultKafkaConnectTimeout
+	}
 
-	// Build producer
-	producer, err := sarama.NewSyncProducer(cfg.Brokers, saramaConfig)
-	if err != nil {
-		return nil, err
+	checkConfig := *saramaConfig
+	checkConfig.Net.DialTimeout = timeout
+	checkConfig.Net.ReadTimeout = timeout
+	checkConfig.Net.WriteTimeout = timeout
+	checkConfig.Metadata.Retry.Max = 0
+
+	result := make(chan error, 1)
+	go func() {
+		client, err := sarama.NewClient(cfg.Brokers, &checkConfig)
+		if err == nil {
+			client.Close()
+		}
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			return fmt.Errorf("cannot connect to the kafka brokers %s: %w", cfg.Brokers, err)
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("cannot connect to the kafka brokers %s: no response within %s", cfg.Brokers, timeout)
 	}
+}
 
-	return producer, nil
+func applyKafkaProducerConfig(saramaConfig *sarama.Config, cfg *KafkaProducerConfig) error {
+	if cfg.Acks != "" {
+		acks, ok := kafkaAcks[cfg.Acks]
+		if !ok {
+			return fmt.Errorf("invalid kafka acks: %s: can be one of 'none', 'leader' or 'all'", cfg.Acks)
+		}
+		saramaConfig.Producer.RequiredAcks = acks
+	}
+	if cfg.Idempotent {
+		if cfg.Acks != "" && cfg.Acks != "all" {
+			return fmt.Errorf("kafka idempotent producer requires all the acks, not %s", cfg.Acks)
+		}
+		saramaConfig.Producer.Idempotent = true
+		saramaConfig.Producer.RequiredAcks = sarama.WaitForAll
+		saramaConfig.Net.MaxOpenRequests = 1
+	}
+	if cfg.Linger > 0 {
+		saramaConfig.Producer.Flush.Frequency = cfg.Linger
+	}
+	if cfg.BatchSize > 0 {
+		saramaConfig.Producer.Flush.Messages = cfg.BatchSize
+	}
+	if cfg.BatchBytes > 0 {
+		saramaConfig.Producer.Flush.Bytes = cfg.BatchBytes
+	}
+
+	paths := make([]string, 0, len(cfg.Settings))
+	for path := range cfg.Settings {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		if err := setProducerSetting(&saramaConfig.Producer, path, fmt.Sprint(cfg.Settings[path])); err != nil {
+			return fmt.Errorf("invalid kafka producer setting %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setProducerSetting sets the field of the producer configuration at the dotted path, matching the field names
+// case-insensitively. Only the scalar fields can be set, and not the ones the sink relies on.
+func setProducerSetting(producer any, path, value string) error {
+	names := strings.Split(path, ".")
+	if strings.EqualFold(names[0], "return") {
+		return errors.New("the returned results are managed by the sink")
+	}
+
+	field := reflect.ValueOf(producer).Elem()
+	for _, name := range names {
+		if field.Kind() != reflect.Struct {
+			return errors.New("unknown setting")
+		}
+		found := false
+		for i := 0; i < field.NumField(); i++ {
+			if field.Type().Field(i).IsExported() && strings.EqualFold(field.Type().Field(i).Name, name) {
+				field = field.Field(i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("unknown setting")
+		}
+	}
+
+	switch {
+	case field.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case field.CanInt():
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case field.CanUint():
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case field.CanFloat():
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	default:
+		return fmt.Errorf("a %s cannot be set", field.Type())
+	}
+	return nil
 }
 
 var (
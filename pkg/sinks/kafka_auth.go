@@ -0,0 +1,101 @@
+package sinks
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// KafkaOAuthConfig fetches the tokens of the OAUTHBEARER mechanism with the OAuth2 client credentials flow
+type KafkaOAuthConfig struct {
+	TokenURL     string   `yaml:"tokenURL"`
+	ClientID     string   `yaml:"clientId"`
+	ClientSecret string   `yaml:"clientSecret"`
+	Scopes       []string `yaml:"scopes"`
+	// Extensions are sent to the brokers along with the token, such as the logicalCluster of Confluent Cloud
+	Extensions map[string]string `yaml:"extensions"`
+}
+
+// oauthTokenProvider provides the tokens of the client credentials flow, a token is reused until it is about to
+// expire and a new one is fetched
+type oauthTokenProvider struct {
+	source     oauth2.TokenSource
+	extensions map[string]string
+}
+
+func newOAuthTokenProvider(cfg *KafkaOAuthConfig) (*oauthTokenProvider, error) {
+	if cfg.TokenURL == "" || cfg.ClientID == "" {
+		return nil, errors.New("oauthbearer requires a tokenURL and a clientId")
+	}
+	cc := &clientcredentials.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		TokenURL:     cfg.TokenURL,
+		Scopes:       cfg.Scopes,
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: 10 * time.Second})
+	return &oauthTokenProvider{source: cc.TokenSource(ctx), extensions: cfg.Extensions}, nil
+}
+
+func (p *oauthTokenProvider) Token() (*sarama.AccessToken, error) {
+	token, err := p.source.Token()
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch the oauth token: %w", err)
+	}
+	return &sarama.AccessToken{Token: token.AccessToken, Extensions: p.extensions}, nil
+}
+
+const (
+	mskIAMService   = "kafka-cluster"
+	mskIAMAction    = "kafka-cluster:Connect"
+	mskIAMExpiry    = 15 * time.Minute
+	mskIAMUserAgent = "kubernetes-event-exporter"
+)
+
+// mskIAMTokenProvider provides the tokens of the AWS MSK IAM authentication, sent with the OAUTHBEARER mechanism. A
+// token is a presigned request of the kafka-cluster:Connect action, signed with the credentials of the default chain.
+type mskIAMTokenProvider struct {
+	region      string
+	credentials *credentials.Credentials
+	now         func() time.Time
+}
+
+func newMSKIAMTokenProvider(region string) (*mskIAMTokenProvider, error) {
+	if region == "" {
+		return nil, errors.New("aws-msk-iam requires a region")
+	}
+	sess, err := newAWSSession(region, "")
+	if err != nil {
+		return nil, err
+	}
+	return &mskIAMTokenProvider{region: region, credentials: sess.Config.Credentials, now: time.Now}, nil
+}
+
+func (p *mskIAMTokenProvider) Token() (*sarama.AccessToken, error) {
+	endpoint := fmt.Sprintf("https://kafka.%s.amazonaws.com/?Action=%s", p.region, url.QueryEscape(mskIAMAction))
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := v4.NewSigner(p.credentials)
+	if _, err := signer.Presign(req, nil, mskIAMService, p.region, mskIAMExpiry, p.now()); err != nil {
+		return nil, fmt.Errorf("cannot sign the aws msk iam token: %w", err)
+	}
+
+	// The user agent is not signed
+	query := req.URL.Query()
+	query.Set("User-Agent", mskIAMUserAgent)
+	req.URL.RawQuery = query.Encode()
+	return &sarama.AccessToken{Token: base64.RawURLEncoding.EncodeToString([]byte(req.URL.String()))}, nil
+}
@@ -0,0 +1,95 @@
+package sinks
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOAuthTokenProvider(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "kafka", r.PostForm.Get("scope"))
+		id, secret, _ := r.BasicAuth()
+		assert.Equal(t, "exporter", id)
+		assert.Equal(t, "secret", secret)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"token-1","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	provider, err := newOAuthTokenProvider(&KafkaOAuthConfig{
+		TokenURL:     server.URL,
+		ClientID:     "exporter",
+		ClientSecret: "secret",
+		Scopes:       []string{"kafka"},
+		Extensions:   map[string]string{"logicalCluster": "lkc-1"},
+	})
+	require.NoError(t, err)
+
+	for range 2 {
+		token, err := provider.Token()
+		require.NoError(t, err)
+		assert.Equal(t, "token-1", token.Token)
+		assert.Equal(t, map[string]string{"logicalCluster": "lkc-1"}, token.Extensions)
+	}
+	assert.Equal(t, 1, requests, "the token is reused until it expires")
+}
+
+func TestOAuthTokenProviderRefresh(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		// Tokens expiring within the expiry delta of oauth2 are refreshed on every use
+		w.Write([]byte(`{"access_token":"token","token_type":"bearer","expires_in":1}`))
+	}))
+	defer server.Close()
+
+	provider, err := newOAuthTokenProvider(&KafkaOAuthConfig{TokenURL: server.URL, ClientID: "exporter"})
+	require.NoError(t, err)
+
+	for range 2 {
+		_, err := provider.Token()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, requests)
+}
+
+func TestMSKIAMTokenProvider(t *testing.T) {
+	signedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	provider := &mskIAMTokenProvider{
+		region:      "eu-west-1",
+		credentials: credentials.NewStaticCredentials("AKIAEXAMPLE", "secret", ""),
+		now:         func() time.Time { return signedAt },
+	}
+
+	token, err := provider.Token()
+	require.NoError(t, err)
+
+	decoded, err := base64.RawURLEncoding.DecodeString(token.Token)
+	require.NoError(t, err)
+	u, err := url.Parse(string(decoded))
+	require.NoError(t, err)
+
+	assert.Equal(t, "kafka.eu-west-1.amazonaws.com", u.Host)
+	query := u.Query()
+	assert.Equal(t, "kafka-cluster:Connect", query.Get("Action"))
+	assert.Equal(t, "AWS4-HMAC-SHA256", query.Get("X-Amz-Algorithm"))
+	assert.Equal(t, "AKIAEXAMPLE/20240102/eu-west-1/kafka-cluster/aws4_request", query.Get("X-Amz-Credential"))
+	assert.Equal(t, "20240102T030405Z", query.Get("X-Amz-Date"))
+	assert.Equal(t, "900", query.Get("X-Amz-Expires"))
+	assert.NotEmpty(t, query.Get("X-Amz-Signature"))
+	assert.Equal(t, mskIAMUserAgent, query.Get("User-Agent"))
+}
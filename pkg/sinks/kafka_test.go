@@ -0,0 +1,247 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/goccy/go-yaml"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newKafkaEvent() *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.UID = "event-uid"
+	ev.Namespace = "default"
+	ev.Reason = "BackOff"
+	ev.InvolvedObject.UID = types.UID("pod-uid")
+	ev.InvolvedObject.Kind = "Pod"
+	return ev
+}
+
+func TestKafkaMessage(t *testing.T) {
+	k := &KafkaSink{cfg: &KafkaConfig{
+		Topic: "events-{{ .Namespace }}",
+		Key:   "{{ .InvolvedObject.UID }}",
+		Headers: map[string]string{
+			"reason": "{{ .Reason }}",
+			"kind":   "{{ .InvolvedObject.Kind }}",
+			"empty":  "{{ .Source.Host }}",
+		},
+		Layout: map[string]any{"reason": "{{ .Reason }}"},
+	}}
+
+	msg, err := k.message(context.Background(), newKafkaEvent())
+	require.NoError(t, err)
+	assert.Equal(t, "events-default", msg.Topic)
+	assert.Equal(t, sarama.StringEncoder("pod-uid"), msg.Key)
+	assert.Equal(t, sarama.ByteEncoder(`{"reason":"BackOff"}`), msg.Value)
+	assert.Equal(t, []sarama.RecordHeader{
+		{Key: []byte("kind"), Value: []byte("Pod")},
+		{Key: []byte("reason"), Value: []byte("BackOff")},
+	}, msg.Headers)
+}
+
+func TestKafkaMessageEmptyTopic(t *testing.T) {
+	k := &KafkaSink{cfg: &KafkaConfig{Topic: "{{ .Source.Host }}", Key: DefaultKafkaKey}}
+
+	_, err := k.message(context.Background(), newKafkaEvent())
+	assert.True(t, IsPermanent(err))
+}
+
+func TestKafkaInvalidTemplates(t *testing.T) {
+	_, err := NewKafkaSink(&KafkaConfig{Topic: "{{ .Namespace"}, nil)
+	assert.ErrorContains(t, err, "invalid kafka topic")
+
+	_, err = NewKafkaSink(&KafkaConfig{Topic: "events", Headers: map[string]string{"reason": "{{ .Reason"}}, nil)
+	assert.ErrorContains(t, err, "invalid kafka header reason")
+}
+
+func TestKafkaAsyncMetrics(t *testing.T) {
+	store := metrics.NewMetricsStore("test_")
+	defer metrics.DestroyMetricsStore(store)
+
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = true
+	producer := mocks.NewAsyncProducer(t, config)
+	producer.ExpectInputAndSucceed()
+	producer.ExpectInputAndFail(errors.New("broker unavailable"))
+
+	k := &KafkaSink{cfg: &KafkaConfig{Topic: "events", Key: DefaultKafkaKey}, metricsStore: store}
+	k.startAsync(producer)
+
+	assert.NoError(t, k.Send(context.Background(), newKafkaEvent()))
+	assert.NoError(t, k.Send(context.Background(), newKafkaEvent()))
+	k.Close()
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(store.KafkaMessagesProduced.WithLabelValues("events")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(store.KafkaProduceErrors.WithLabelValues("events")))
+}
+
+func TestKafkaSyncErrors(t *testing.T) {
+	store := metrics.NewMetricsStore("test_")
+	defer metrics.DestroyMetricsStore(store)
+
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = true
+	producer := mocks.NewSyncProducer(t, config)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndFail(sarama.ErrNotLeaderForPartition)
+
+	k := &KafkaSink{cfg: &KafkaConfig{Topic: "events", Key: DefaultKafkaKey}, syncProducer: producer, metricsStore: store}
+	assert.NoError(t, k.Send(context.Background(), newKafkaEvent()))
+	assert.ErrorIs(t, k.Send(context.Background(), newKafkaEvent()), sarama.ErrNotLeaderForPartition)
+	k.Close()
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(store.KafkaMessagesProduced.WithLabelValues("events")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(store.KafkaProduceErrors.WithLabelValues("events")))
+}
+
+func TestSaramaConfigProducer(t *testing.T) {
+	config, err := newSaramaConfig(&KafkaConfig{Producer: KafkaProducerConfig{
+		Idempotent: true,
+		Linger:     50 * time.Millisecond,
+		BatchSize:  100,
+		BatchBytes: 1 << 20,
+		Settings: map[string]any{
+			"retry.max":       10,
+			"retry.backoff":   "250ms",
+			"maxMessageBytes": 2000000,
+		},
+	}})
+	require.NoError(t, err)
+
+	assert.True(t, config.Producer.Idempotent)
+	assert.Equal(t, sarama.WaitForAll, config.Producer.RequiredAcks)
+	assert.Equal(t, 1, config.Net.MaxOpenRequests)
+	assert.Equal(t, 50*time.Millisecond, config.Producer.Flush.Frequency)
+	assert.Equal(t, 100, config.Producer.Flush.Messages)
+	assert.Equal(t, 1<<20, config.Producer.Flush.Bytes)
+	assert.Equal(t, 10, config.Producer.Retry.Max)
+	assert.Equal(t, 250*time.Millisecond, config.Producer.Retry.Backoff)
+	assert.Equal(t, 2000000, config.Producer.MaxMessageBytes)
+}
+
+func TestSaramaConfigProducerErrors(t *testing.T) {
+	for name, producer := range map[string]KafkaProducerConfig{
+		"invalid kafka acks":                           {Acks: "some"},
+		"requires all the acks":                        {Acks: "leader", Idempotent: true},
+		"retry.max: strconv.ParseInt":                  {Settings: map[string]any{"retry.max": "many"}},
+		"retry.backoff: time: missing unit":            {Settings: map[string]any{"retry.backoff": 100}},
+		"flush.unknown: unknown setting":               {Settings: map[string]any{"flush.unknown": 1}},
+		"maxMessageBytes.size: unknown setting":        {Settings: map[string]any{"maxMessageBytes.size": 1}},
+		"return.successes: the returned results":       {Settings: map[string]any{"return.successes": false}},
+		"partitioner: a sarama.PartitionerConstructor": {Settings: map[string]any{"partitioner": "hash"}},
+		"invalid kafka producer configuration":         {Settings: map[string]any{"maxMessageBytes": 0}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newSaramaConfig(&KafkaConfig{Producer: producer})
+			assert.ErrorContains(t, err, name)
+		})
+	}
+}
+
+func TestSaramaConfigTLS(t *testing.T) {
+	cfg := &KafkaConfig{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+tls:
+  enable: true
+  serverName: kafka.internal
+  insecureSkipVerify: true
+`), cfg))
+
+	config, err := newSaramaConfig(cfg)
+	require.NoError(t, err)
+	assert.True(t, config.Net.TLS.Enable)
+	assert.Equal(t, "kafka.internal", config.Net.TLS.Config.ServerName)
+	assert.True(t, config.Net.TLS.Config.InsecureSkipVerify)
+	assert.Nil(t, config.Net.TLS.Config.RootCAs, "the system roots are used without a CA file")
+}
+
+func TestSaramaConfigSASL(t *testing.T) {
+	cfg := &KafkaConfig{}
+	cfg.SASL.Enable = true
+	cfg.SASL.Mechanism = "oauthbearer"
+	cfg.SASL.OAuth = KafkaOAuthConfig{TokenURL: "https://idp/token", ClientID: "exporter"}
+	config, err := newSaramaConfig(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeOAuth), config.Net.SASL.Mechanism)
+	assert.IsType(t, &oauthTokenProvider{}, config.Net.SASL.TokenProvider)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	cfg = &KafkaConfig{}
+	cfg.TLS.Enable = true
+	cfg.SASL.Enable = true
+	cfg.SASL.Mechanism = "aws-msk-iam"
+	cfg.SASL.Region = "us-east-1"
+	config, err = newSaramaConfig(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeOAuth), config.Net.SASL.Mechanism)
+	assert.IsType(t, &mskIAMTokenProvider{}, config.Net.SASL.TokenProvider)
+}
+
+func TestSaramaConfigSASLErrors(t *testing.T) {
+	for name, mechanism := range map[string]string{
+		"invalid sasl mechanism":             "gssapi",
+		"requires a tokenURL and a clientId": "oauthbearer",
+		"requires tls to be enabled":         "aws-msk-iam",
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := &KafkaConfig{}
+			cfg.SASL.Enable = true
+			cfg.SASL.Mechanism = mechanism
+			_, err := newSaramaConfig(cfg)
+			assert.ErrorContains(t, err, name)
+		})
+	}
+}
+
+func TestKafkaConnectivityCheck(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("events", 0, broker.BrokerID()),
+	})
+
+	cfg := &KafkaConfig{Brokers: []string{broker.Addr()}, Version: "2.1.0"}
+	config, err := newSaramaConfig(cfg)
+	require.NoError(t, err)
+	assert.NoError(t, checkKafkaConnectivity(cfg, config))
+}
+
+func TestKafkaConnectivityCheckUnreachable(t *testing.T) {
+	// The broker accepts the connections but never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	cfg := &KafkaConfig{Brokers: []string{listener.Addr().String()}, ConnectTimeout: 200 * time.Millisecond}
+	config, err := newSaramaConfig(cfg)
+	require.NoError(t, err)
+
+	start := time.Now()
+	err = checkKafkaConnectivity(cfg, config)
+	assert.ErrorContains(t, err, "cannot connect to the kafka brokers")
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
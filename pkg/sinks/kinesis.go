@@ -2,18 +2,43 @@ package sinks
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 )
 
+// The limits of the PutRecords API
+const (
+	kinesisMaxRecords     = 500
+	kinesisMaxBatchBytes  = 5 * 1024 * 1024
+	kinesisMaxRecordBytes = 1024 * 1024
+	kinesisMaxKeyLength   = 256
+)
+
+const DefaultKinesisPartitionKey = "{{ .UID }}"
+
+// defaultAWSWriterConfig is used by the AWS sinks unless their batch is configured, the batches are split further by
+// the limits of the services
+var defaultAWSWriterConfig = batch.WriterConfig{
+	BatchSize:  500,
+	Interval:   time.Second,
+	MaxRetries: 3,
+	Timeout:    30 * time.Second,
+}
+
 type KinesisConfig struct {
 	StreamName string         `yaml:"streamName"`
 	Region     string         `yaml:"region"`
+	Endpoint   string         `yaml:"endpoint"`
 	Layout     map[string]any `yaml:"layout"`
+	// PartitionKey is a template rendered with the event, the UID of the event by default
+	PartitionKey string      `yaml:"partitionKey"`
+	Batch        BatchConfig `yaml:"batch"`
 }
 
 type KinesisSink struct {
@@ -22,10 +47,14 @@ type KinesisSink struct {
 }
 
 func NewKinesisSink(cfg *KinesisConfig) (Sink, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(cfg.Region),
-	},
-	)
+	if cfg.PartitionKey == "" {
+		cfg.PartitionKey = DefaultKinesisPartitionKey
+	}
+	if err := ValidateTemplate(cfg.PartitionKey); err != nil {
+		return nil, fmt.Errorf("invalid kinesis partition key: %w", err)
+	}
+
+	sess, err := newAWSSession(cfg.Region, cfg.Endpoint)
 	if err != nil {
 		return nil, err
 	}
@@ -37,29 +66,71 @@ func NewKinesisSink(cfg *KinesisConfig) (Sink, error) {
 }
 
 func (k *KinesisSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	var toSend []byte
+	return k.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
 
-	if k.cfg.Layout != nil {
-		res, err := convertLayoutTemplate(k.cfg.Layout, ev)
+// SendBatch puts the events with PutRecords, split by its limits. Only the records failed for throttling or internal
+// errors are retried.
+func (k *KinesisSink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	records := make([]*kinesis.PutRecordsRequestEntry, len(evs))
+	sizes := make([]int, len(evs))
+	included := make([]int, 0, len(evs))
+
+	for i, ev := range evs {
+		data, err := serializeEventWithLayout(k.cfg.Layout, ev)
 		if err != nil {
-			return err
+			errs[i] = Permanent(err)
+			continue
 		}
-
-		toSend, err = json.Marshal(res)
+		key, err := GetString(ev, k.cfg.PartitionKey)
 		if err != nil {
-			return err
+			errs[i] = Permanent(err)
+			continue
+		}
+		if key == "" {
+			errs[i] = Permanent(errors.New("kinesis partition key is empty"))
+			continue
 		}
-	} else {
-		toSend = ev.ToJSON()
+		key = truncate(key, kinesisMaxKeyLength)
+
+		sizes[i] = len(data) + len(key)
+		if sizes[i] > kinesisMaxRecordBytes {
+			errs[i] = Permanent(fmt.Errorf("kinesis record of %d bytes is too large", sizes[i]))
+			continue
+		}
+		records[i] = &kinesis.PutRecordsRequestEntry{
+			Data:         data,
+			PartitionKey: aws.String(key),
+		}
+		included = append(included, i)
 	}
 
-	_, err := k.svc.PutRecord(&kinesis.PutRecordInput{
-		Data:         toSend,
-		PartitionKey: aws.String(string(ev.UID)),
-		StreamName:   aws.String(k.cfg.StreamName),
-	})
+	for _, chunk := range chunkBySize(included, sizes, kinesisMaxRecords, kinesisMaxBatchBytes) {
+		input := &kinesis.PutRecordsInput{
+			StreamName: aws.String(k.cfg.StreamName),
+			Records:    make([]*kinesis.PutRecordsRequestEntry, 0, len(chunk)),
+		}
+		for _, i := range chunk {
+			input.Records = append(input.Records, records[i])
+		}
+
+		out, err := k.svc.PutRecordsWithContext(ctx, input)
+		if err != nil {
+			setErrors(errs, chunk, awsRequestError(err))
+			continue
+		}
+		for n, record := range out.Records {
+			if n < len(chunk) && record.ErrorCode != nil {
+				errs[chunk[n]] = awsEntryError(*record.ErrorCode, aws.StringValue(record.ErrorMessage), false)
+			}
+		}
+	}
+	return errs
+}
 
-	return err
+func (k *KinesisSink) BatchConfig() batch.WriterConfig {
+	return k.cfg.Batch.WriterConfig(defaultAWSWriterConfig)
 }
 
 func (k *KinesisSink) Close() {
@@ -2,6 +2,7 @@ package sinks
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -9,12 +10,42 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"regexp"
+	"sort"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/golang/snappy"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
+const (
+	LokiEncodingJSON     = "json"
+	LokiEncodingProtobuf = "protobuf"
+
+	DefaultLokiMaxLabelValues = 100
+	// LokiOverflowLabelValue replaces the values of a stream label once it has too many distinct values
+	LokiOverflowLabelValue = "other"
+	// lokiMaxLabelValueLength is below the default max_label_value_length of Loki
+	lokiMaxLabelValueLength = 1024
+	// lokiFallbackLabel and lokiFallbackLabelValue label the streams of the events whose labels all render empty, as Loki
+	// rejects the streams without labels
+	lokiFallbackLabel      = "source"
+	lokiFallbackLabelValue = "kubernetes-event-exporter"
+)
+
+var defaultLokiWriterConfig = batch.WriterConfig{
+	BatchSize:  1000,
+	Interval:   time.Second,
+	MaxRetries: 3,
+	Timeout:    30 * time.Second,
+}
+
+var lokiLabelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
 type promtailStream struct {
 	Stream map[string]string `json:"stream"`
 	Values [][]string        `json:"values"`
@@ -25,86 +56,326 @@ type LokiMsg struct {
 }
 
 type LokiConfig struct {
-	Layout       map[string]any    `yaml:"layout"`
+	Layout map[string]any `yaml:"layout"`
+	// StreamLabels are templates rendered with the event, such as "{{ .InvolvedObject.Namespace }}". Labels rendered
+	// empty are left out.
 	StreamLabels map[string]string `yaml:"streamLabels"`
-	TLS          TLS               `yaml:"tls"`
-	URL          string            `yaml:"url"`
-	Headers      map[string]string `yaml:"headers"`
+	// MaxLabelValues is the number of distinct values kept per stream label, the following ones are replaced by
+	// "other" to limit the number of streams
+	MaxLabelValues int               `yaml:"maxLabelValues"`
+	TLS            TLS               `yaml:"tls"`
+	URL            string            `yaml:"url"`
+	Headers        map[string]string `yaml:"headers"`
+	// TenantID is sent as the X-Scope-OrgID header for the multi-tenant Loki setups
+	TenantID string `yaml:"tenantID"`
+	// Encoding is either json, by default, or protobuf which is compressed with snappy
+	Encoding string `yaml:"encoding"`
+	// Gzip compresses the json requests
+	Gzip  bool        `yaml:"gzip"`
+	Batch BatchConfig `yaml:"batch"`
 }
 
 type Loki struct {
 	cfg       *LokiConfig
 	transport *http.Transport
+	client    *http.Client
+
+	mu sync.Mutex
+	// labelValues holds the distinct values seen per stream label for the cardinality limit
+	labelValues map[string]map[string]bool
 }
 
 func NewLoki(cfg *LokiConfig) (Sink, error) {
+	switch cfg.Encoding {
+	case "", LokiEncodingJSON:
+	case LokiEncodingProtobuf:
+		if cfg.Gzip {
+			return nil, errors.New("loki protobuf encoding is compressed with snappy, it cannot be gzipped")
+		}
+	default:
+		return nil, fmt.Errorf("unknown loki encoding %q", cfg.Encoding)
+	}
+	for name, value := range cfg.StreamLabels {
+		if !lokiLabelNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid loki stream label name %q", name)
+		}
+		if err := ValidateTemplate(value); err != nil {
+			return nil, fmt.Errorf("invalid loki stream label %s: %w", name, err)
+		}
+	}
+
 	tlsClientConfig, err := setupTLS(&cfg.TLS)
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup TLS: %w", err)
 	}
-	return &Loki{cfg: cfg, transport: &http.Transport{
+	transport := &http.Transport{
 		Proxy:           http.ProxyFromEnvironment,
 		TLSClientConfig: tlsClientConfig,
-	}}, nil
+	}
+	return &Loki{
+		cfg:         cfg,
+		transport:   transport,
+		client:      &http.Client{Transport: transport},
+		labelValues: make(map[string]map[string]bool),
+	}, nil
 }
 
-func generateTimestamp() string {
-	return strconv.FormatInt(time.Now().Unix(), 10) + "000000000"
+// formatTimestamp formats the time of the event in nanoseconds as Loki expects, the events without a time get the
+// current time
+func formatTimestamp(ev *kube.EnhancedEvent) string {
+	ms := ev.GetTimestampMs()
+	if ms <= 0 {
+		return strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return strconv.FormatInt(ms*int64(time.Millisecond), 10)
 }
 
 func (l *Loki) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	eventBody, err := serializeEventWithLayout(l.cfg.Layout, ev)
-	if err != nil {
-		return err
+	return l.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// lokiRequest holds the events pushed in a single request, the events with different headers are pushed separately
+type lokiRequest struct {
+	headers http.Header
+	streams map[string]*promtailStream
+	// order keeps the streams in the order of their first events
+	order []string
+	// events are the positions of the events in the batch
+	events []int
+}
+
+// SendBatch pushes the events grouped by their streams
+func (l *Loki) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	requests := make(map[string]*lokiRequest)
+	order := make([]string, 0)
+
+	for i, ev := range evs {
+		line, err := serializeEventWithLayout(l.cfg.Layout, ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		labels, err := l.streamLabels(ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		headers := l.headers(ev)
+
+		headersKey := fmt.Sprint(headers)
+		req, ok := requests[headersKey]
+		if !ok {
+			req = &lokiRequest{headers: headers, streams: make(map[string]*promtailStream)}
+			requests[headersKey] = req
+			order = append(order, headersKey)
+		}
+
+		streamKey := formatLabels(labels)
+		stream, ok := req.streams[streamKey]
+		if !ok {
+			stream = &promtailStream{Stream: labels}
+			req.streams[streamKey] = stream
+			req.order = append(req.order, streamKey)
+		}
+		stream.Values = append(stream.Values, []string{formatTimestamp(ev), string(line)})
+		req.events = append(req.events, i)
 	}
-	timestamp := generateTimestamp()
-	a := LokiMsg{
-		Streams: []promtailStream{{
-			Stream: l.cfg.StreamLabels,
-			Values: [][]string{{timestamp, string(eventBody)}},
-		}},
+
+	for _, key := range order {
+		req := requests[key]
+		if err := l.push(ctx, req); err != nil {
+			setErrors(errs, req.events, err)
+		}
 	}
-	reqBody, err := json.Marshal(a)
-	if err != nil {
-		return err
+	return errs
+}
+
+func (l *Loki) BatchConfig() batch.WriterConfig {
+	return l.cfg.Batch.WriterConfig(defaultLokiWriterConfig)
+}
+
+// streamLabels renders the stream labels of the event, applying the cardinality limit. The empty values are left out.
+func (l *Loki) streamLabels(ev *kube.EnhancedEvent) (map[string]string, error) {
+	labels := make(map[string]string, len(l.cfg.StreamLabels))
+	for name, text := range l.cfg.StreamLabels {
+		value, err := GetString(ev, text)
+		if err != nil {
+			return nil, fmt.Errorf("cannot render stream label %s: %w", name, err)
+		}
+		if value == "" {
+			continue
+		}
+		labels[name] = truncate(value, lokiMaxLabelValueLength)
 	}
-	req, err := http.NewRequest(http.MethodPost, l.cfg.URL, bytes.NewBuffer(reqBody))
-	if err != nil {
-		return err
+
+	maxValues := l.cfg.MaxLabelValues
+	if maxValues <= 0 {
+		maxValues = DefaultLokiMaxLabelValues
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for name, value := range labels {
+		seen, ok := l.labelValues[name]
+		if !ok {
+			seen = make(map[string]bool)
+			l.labelValues[name] = seen
+		}
+		if seen[value] {
+			continue
+		}
+		if len(seen) >= maxValues {
+			labels[name] = LokiOverflowLabelValue
+			continue
+		}
+		seen[value] = true
+		if len(seen) == maxValues {
+			slog.With("label", name, "values", maxValues).Warn("Loki stream label reached its maximum values, the new ones are replaced")
+		}
+	}
+	if len(labels) == 0 {
+		labels[lokiFallbackLabel] = lokiFallbackLabelValue
+	}
+	return labels, nil
+}
 
+func (l *Loki) headers(ev *kube.EnhancedEvent) http.Header {
+	headers := http.Header{}
 	for k, v := range l.cfg.Headers {
 		realValue, err := GetString(ev, v)
 		if err != nil {
-			req.Header.Add(k, v)
+			headers.Add(k, v)
 		} else {
 			slog.Debug(fmt.Sprintf("request header: {%s: %s}", k, realValue))
-			req.Header.Add(k, realValue)
+			headers.Add(k, realValue)
 		}
 	}
+	if l.cfg.TenantID != "" {
+		headers.Set("X-Scope-OrgID", l.cfg.TenantID)
+	}
+	return headers
+}
 
-	client := http.DefaultClient
-	resp, err := client.Do(req)
+func (l *Loki) push(ctx context.Context, r *lokiRequest) error {
+	streams := make([]promtailStream, 0, len(r.streams))
+	for _, key := range r.order {
+		stream := r.streams[key]
+		// Loki expects the entries of a stream in order
+		sort.SliceStable(stream.Values, func(i, j int) bool {
+			a, _ := strconv.ParseInt(stream.Values[i][0], 10, 64)
+			b, _ := strconv.ParseInt(stream.Values[j][0], 10, 64)
+			return a < b
+		})
+		streams = append(streams, *stream)
+	}
+
+	body, contentType, contentEncoding, err := l.encode(streams)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.cfg.URL, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
+	req.Header = r.headers.Clone()
+	req.Header.Set("Content-Type", contentType)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
 
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
 
 	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-		return errors.New("not successful (2xx) response: " + string(body))
+		err := errors.New("not successful (2xx) response: " + string(respBody))
+		if !retryableStatus(resp.StatusCode) {
+			return Permanent(err)
+		}
+		return err
 	}
 
 	return nil
 }
 
+// encode serializes the push request and returns it with its content type and encoding
+func (l *Loki) encode(streams []promtailStream) ([]byte, string, string, error) {
+	if l.cfg.Encoding == LokiEncodingProtobuf {
+		pb, err := encodeLokiProtobuf(streams)
+		if err != nil {
+			return nil, "", "", err
+		}
+		return snappy.Encode(nil, pb), "application/x-protobuf", "", nil
+	}
+
+	body, err := json.Marshal(LokiMsg{Streams: streams})
+	if err != nil {
+		return nil, "", "", err
+	}
+	if !l.cfg.Gzip {
+		return body, "application/json", "", nil
+	}
+
+	buf := new(bytes.Buffer)
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write(body); err != nil {
+		return nil, "", "", err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, "", "", err
+	}
+	return buf.Bytes(), "application/json", "gzip", nil
+}
+
+// encodeLokiProtobuf encodes the logproto.PushRequest message of Loki:
+//
+//	message PushRequest { repeated StreamAdapter streams = 1; }
+//	message StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	message EntryAdapter { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+func encodeLokiProtobuf(streams []promtailStream) ([]byte, error) {
+	var req []byte
+	for _, stream := range streams {
+		var s []byte
+		s = protowire.AppendTag(s, 1, protowire.BytesType)
+		s = protowire.AppendString(s, formatLabels(stream.Stream))
+
+		for _, value := range stream.Values {
+			ns, err := strconv.ParseInt(value[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp %q: %w", value[0], err)
+			}
+
+			var ts []byte
+			ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(ns/int64(time.Second)))
+			ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+			ts = protowire.AppendVarint(ts, uint64(ns%int64(time.Second)))
+
+			var entry []byte
+			entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+			entry = protowire.AppendBytes(entry, ts)
+			entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+			entry = protowire.AppendString(entry, value[1])
+
+			s = protowire.AppendTag(s, 2, protowire.BytesType)
+			s = protowire.AppendBytes(s, entry)
+		}
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, s)
+	}
+	return req, nil
+}
+
 func (l *Loki) Close() {
 	l.transport.CloseIdleConnections()
 }
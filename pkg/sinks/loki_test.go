@@ -0,0 +1,243 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type lokiPush struct {
+	header http.Header
+	body   []byte
+}
+
+func lokiServer(t *testing.T, pushes *[]lokiPush) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		*pushes = append(*pushes, lokiPush{header: r.Header, body: body})
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func newLokiEvent(namespace, reason string, at time.Time) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Namespace = namespace
+	ev.Reason = reason
+	ev.Message = reason + " in " + namespace
+	ev.FirstTimestamp = metav1.NewTime(at)
+	return ev
+}
+
+func TestLokiSendBatchGroupsStreams(t *testing.T) {
+	pushes := make([]lokiPush, 0)
+	server := lokiServer(t, &pushes)
+	defer server.Close()
+
+	sink, err := NewLoki(&LokiConfig{
+		URL: server.URL,
+		StreamLabels: map[string]string{
+			"job":       "kubernetes-event-exporter",
+			"namespace": "{{ .Namespace }}",
+		},
+		Layout:   map[string]any{"message": "{{ .Message }}"},
+		TenantID: "team-a",
+	})
+	require.NoError(t, err)
+	loki := sink.(*Loki)
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	errs := loki.SendBatch(context.Background(), []*kube.EnhancedEvent{
+		newLokiEvent("a", "BackOff", at.Add(time.Second)),
+		newLokiEvent("b", "Pulled", at),
+		newLokiEvent("a", "Created", at),
+	})
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+
+	require.Len(t, pushes, 1)
+	assert.Equal(t, "team-a", pushes[0].header.Get("X-Scope-OrgID"))
+	assert.Equal(t, "application/json", pushes[0].header.Get("Content-Type"))
+
+	var msg LokiMsg
+	require.NoError(t, json.Unmarshal(pushes[0].body, &msg))
+	assert.Equal(t, []promtailStream{
+		{
+			Stream: map[string]string{"job": "kubernetes-event-exporter", "namespace": "a"},
+			// The entries are in order and the timestamps are the ones of the events in nanoseconds
+			Values: [][]string{
+				{"1704164645006000000", `{"message":"Created in a"}`},
+				{"1704164646006000000", `{"message":"BackOff in a"}`},
+			},
+		},
+		{
+			Stream: map[string]string{"job": "kubernetes-event-exporter", "namespace": "b"},
+			Values: [][]string{{"1704164645006000000", `{"message":"Pulled in b"}`}},
+		},
+	}, msg.Streams)
+}
+
+func TestLokiGzip(t *testing.T) {
+	pushes := make([]lokiPush, 0)
+	server := lokiServer(t, &pushes)
+	defer server.Close()
+
+	sink, err := NewLoki(&LokiConfig{URL: server.URL, Gzip: true, StreamLabels: map[string]string{"job": "events"}})
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Send(context.Background(), newLokiEvent("a", "BackOff", time.Now())))
+
+	require.Len(t, pushes, 1)
+	assert.Equal(t, "gzip", pushes[0].header.Get("Content-Encoding"))
+	gz, err := gzip.NewReader(bytes.NewReader(pushes[0].body))
+	require.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	require.NoError(t, err)
+
+	var msg LokiMsg
+	require.NoError(t, json.Unmarshal(body, &msg))
+	assert.Len(t, msg.Streams, 1)
+}
+
+func TestLokiProtobuf(t *testing.T) {
+	pushes := make([]lokiPush, 0)
+	server := lokiServer(t, &pushes)
+	defer server.Close()
+
+	sink, err := NewLoki(&LokiConfig{
+		URL:          server.URL,
+		Encoding:     LokiEncodingProtobuf,
+		StreamLabels: map[string]string{"namespace": "{{ .Namespace }}", "job": "events"},
+	})
+	require.NoError(t, err)
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	require.NoError(t, sink.Send(context.Background(), newLokiEvent("a", "BackOff", at)))
+
+	require.Len(t, pushes, 1)
+	assert.Equal(t, "application/x-protobuf", pushes[0].header.Get("Content-Type"))
+	pb, err := snappy.Decode(nil, pushes[0].body)
+	require.NoError(t, err)
+
+	// PushRequest.streams
+	streams := protoFields(t, pb)
+	require.Len(t, streams[1], 1)
+
+	stream := protoFields(t, streams[1][0].([]byte))
+	assert.Equal(t, `{job="events", namespace="a"}`, string(stream[1][0].([]byte)))
+	require.Len(t, stream[2], 1)
+
+	entry := protoFields(t, stream[2][0].([]byte))
+	assert.Contains(t, string(entry[2][0].([]byte)), `"message":"BackOff in a"`)
+
+	ts := protoFields(t, entry[1][0].([]byte))
+	assert.Equal(t, []any{uint64(at.Unix())}, ts[1])
+	assert.Equal(t, []any{uint64(6000000)}, ts[2])
+}
+
+// protoFields parses the fields of a message by number, with the values of the varint and bytes fields
+func protoFields(t *testing.T, b []byte) map[protowire.Number][]any {
+	fields := make(map[protowire.Number][]any)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.Greater(t, n, 0)
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, m := protowire.ConsumeVarint(b)
+			require.Greater(t, m, 0)
+			fields[num] = append(fields[num], v)
+			b = b[m:]
+		case protowire.BytesType:
+			v, m := protowire.ConsumeBytes(b)
+			require.Greater(t, m, 0)
+			fields[num] = append(fields[num], v)
+			b = b[m:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+	return fields
+}
+
+func TestLokiLabelCardinality(t *testing.T) {
+	sink, err := NewLoki(&LokiConfig{
+		URL:            "http://localhost",
+		StreamLabels:   map[string]string{"reason": "{{ .Reason }}", "empty": "{{ .Action }}"},
+		MaxLabelValues: 2,
+	})
+	require.NoError(t, err)
+	loki := sink.(*Loki)
+
+	reasons := make([]string, 0)
+	for _, reason := range []string{"BackOff", "Pulled", "BackOff", "Created", "Killing"} {
+		labels, err := loki.streamLabels(newLokiEvent("a", reason, time.Now()))
+		require.NoError(t, err)
+		assert.NotContains(t, labels, "empty")
+		reasons = append(reasons, labels["reason"])
+	}
+	assert.Equal(t, []string{"BackOff", "Pulled", "BackOff", LokiOverflowLabelValue, LokiOverflowLabelValue}, reasons)
+}
+
+func TestLokiEmptyStreamLabels(t *testing.T) {
+	sink, err := NewLoki(&LokiConfig{
+		URL:          "http://localhost",
+		StreamLabels: map[string]string{"action": "{{ .Action }}"},
+	})
+	require.NoError(t, err)
+
+	// Loki rejects the streams without labels, the events with all their labels empty get a fixed one
+	labels, err := sink.(*Loki).streamLabels(newLokiEvent("a", "BackOff", time.Now()))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"source": "kubernetes-event-exporter"}, labels)
+}
+
+func TestLokiErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Tenant") == "throttled" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sink, err := NewLoki(&LokiConfig{
+		URL:          server.URL,
+		StreamLabels: map[string]string{"job": "events"},
+		Headers:      map[string]string{"X-Tenant": "{{ .Namespace }}"},
+	})
+	require.NoError(t, err)
+	loki := sink.(*Loki)
+
+	errs := loki.SendBatch(context.Background(), []*kube.EnhancedEvent{
+		newLokiEvent("throttled", "BackOff", time.Now()),
+		newLokiEvent("rejected", "BackOff", time.Now()),
+	})
+	require.Error(t, errs[0])
+	assert.False(t, IsPermanent(errs[0]))
+	require.Error(t, errs[1])
+	assert.True(t, IsPermanent(errs[1]))
+}
+
+func TestLokiConfigValidation(t *testing.T) {
+	_, err := NewLoki(&LokiConfig{Encoding: "xml"})
+	assert.Error(t, err)
+	_, err = NewLoki(&LokiConfig{Encoding: LokiEncodingProtobuf, Gzip: true})
+	assert.Error(t, err)
+	_, err = NewLoki(&LokiConfig{StreamLabels: map[string]string{"k8s.namespace": "x"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,62 @@
+package sinks
+
+import (
+	"context"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+)
+
+var mattermostPlatform = chatPlatform{
+	name:      "mattermost",
+	rateLimit: 10,
+	burst:     10,
+	textKey:   "text",
+	maxText:   16383,
+	layoutKey: "attachments",
+	// The limits keep the attachments within the maximum length of a post
+	limits: map[string]int{
+		"pretext": 4000,
+		"text":    4000,
+		"title":   1000,
+		"value":   1000,
+	},
+}
+
+type MattermostConfig struct {
+	ChatConfig `yaml:",inline"`
+	// Channel, Username and IconURL are templates overriding the ones of the webhook, when it allows them
+	Channel  string `yaml:"channel"`
+	Username string `yaml:"username"`
+	IconURL  string `yaml:"iconURL"`
+	// Attachments are the message attachments of the posts, with templates in their strings
+	Attachments []any `yaml:"attachments"`
+}
+
+type MattermostSink struct {
+	cfg *MattermostConfig
+	*chatClient
+}
+
+func NewMattermostSink(cfg *MattermostConfig) (Sink, error) {
+	client, err := newChatClient(mattermostPlatform, &cfg.ChatConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.validate(cfg.overrides(), cfg.Attachments); err != nil {
+		return nil, err
+	}
+	return &MattermostSink{cfg: cfg, chatClient: client}, nil
+}
+
+// overrides are the templates overriding the settings of the webhook, by their keys in the payloads
+func (cfg *MattermostConfig) overrides() map[string]string {
+	return map[string]string{"channel": cfg.Channel, "username": cfg.Username, "icon_url": cfg.IconURL}
+}
+
+func (m *MattermostSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	payload, err := m.render(ev, m.cfg.overrides(), m.cfg.Attachments)
+	if err != nil {
+		return err
+	}
+	return m.post(ctx, m.cfg.URL, payload)
+}
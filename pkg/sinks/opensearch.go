@@ -1,19 +1,14 @@
 package sinks
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"log/slog"
 	"net/http"
-	"regexp"
-	"strings"
 	"time"
 
 	opensearch "github.com/opensearch-project/opensearch-go"
 	opensearchapi "github.com/opensearch-project/opensearch-go/opensearchapi"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 )
 
@@ -31,6 +26,8 @@ type OpenSearchConfig struct {
 	Type        string         `yaml:"type"`
 	TLS         TLS            `yaml:"tls"`
 	Layout      map[string]any `yaml:"layout"`
+	// Batch configures the buffering of the events for the _bulk API
+	Batch BatchConfig `yaml:"batch"`
 }
 
 func NewOpenSearch(cfg *OpenSearchConfig) (*OpenSearch, error) {
@@ -54,91 +51,47 @@ func NewOpenSearch(cfg *OpenSearchConfig) (*OpenSearch, error) {
 	return &OpenSearch{
 		client: client,
 		cfg:    cfg,
+		bulk: &bulkIndexer{
+			deDot:       cfg.DeDot,
+			layout:      cfg.Layout,
+			index:       cfg.Index,
+			indexFormat: cfg.IndexFormat,
+			docType:     cfg.Type,
+			useEventID:  cfg.UseEventID,
+		},
 	}, nil
 }
 
 type OpenSearch struct {
 	client *opensearch.Client
 	cfg    *OpenSearchConfig
-}
-
-var osRegex = regexp.MustCompile(`(?s){(.*)}`)
-
-func osFormatIndexName(pattern string, when time.Time) string {
-	m := osRegex.FindAllStringSubmatchIndex(pattern, -1)
-	current := 0
-	var builder strings.Builder
-
-	for i := 0; i < len(m); i++ {
-		pair := m[i]
-
-		builder.WriteString(pattern[current:pair[0]])
-		builder.WriteString(when.Format(pattern[pair[0]+1 : pair[1]-1]))
-		current = pair[1]
-	}
-
-	builder.WriteString(pattern[current:])
-
-	return builder.String()
+	bulk   *bulkIndexer
 }
 
 func (e *OpenSearch) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	var toSend []byte
-
-	if e.cfg.DeDot {
-		de := ev.DeDot()
-		ev = &de
-	}
-	if e.cfg.Layout != nil {
-		res, err := convertLayoutTemplate(e.cfg.Layout, ev)
-		if err != nil {
-			return err
-		}
-
-		toSend, err = json.Marshal(res)
-		if err != nil {
-			return err
-		}
-	} else {
-		toSend = ev.ToJSON()
-	}
-
-	var index string
-	if len(e.cfg.IndexFormat) > 0 {
-		now := time.Now()
-		index = osFormatIndexName(e.cfg.IndexFormat, now)
-	} else {
-		index = e.cfg.Index
-	}
-
-	req := opensearchapi.IndexRequest{
-		Body:  bytes.NewBuffer(toSend),
-		Index: index,
-	}
-
-	// This should not be used for clusters with ES8.0+.
-	if len(e.cfg.Type) > 0 {
-		req.DocumentType = e.cfg.Type
-	}
+	return e.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
 
-	if e.cfg.UseEventID {
-		req.DocumentID = string(ev.UID)
+// SendBatch indexes the events with the _bulk API, only the events failed with a retryable error are retried
+func (e *OpenSearch) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	body, included, errs := e.bulk.body(evs, time.Now())
+	if len(included) == 0 {
+		return errs
 	}
 
-	resp, err := req.Do(ctx, e.client)
+	resp, err := opensearchapi.BulkRequest{Body: body}.Do(ctx, e.client)
 	if err != nil {
-		return err
+		setErrors(errs, included, err)
+		return errs
 	}
-
 	defer resp.Body.Close()
-	if resp.StatusCode > 399 {
-		rb, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
-		slog.Error(fmt.Sprintf("Indexing failed: %s", string(rb)))
-	}
-	return nil
+
+	e.bulk.result(resp.StatusCode, resp.Body, included, errs)
+	return errs
+}
+
+func (e *OpenSearch) BatchConfig() batch.WriterConfig {
+	return e.cfg.Batch.WriterConfig(defaultBulkWriterConfig)
 }
 
 func (e *OpenSearch) Close() {
@@ -2,25 +2,61 @@ package sinks
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/opsgenie/opsgenie-go-sdk-v2/alert"
 	"github.com/opsgenie/opsgenie-go-sdk-v2/client"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 )
 
+const DefaultOpsgenieAliasCacheSize = 4096
+
+var opsgeniePriorities = []string{"P1", "P2", "P3", "P4", "P5"}
+
 type OpsgenieConfig struct {
-	ApiKey      string            `yaml:"apiKey"`
-	URL         client.ApiUrl     `yaml:"URL"`
+	ApiKey string        `yaml:"apiKey"`
+	URL    client.ApiUrl `yaml:"URL"`
+	// Priority is a template rendered to one of P1 to P5, P3 by default
 	Priority    string            `yaml:"priority"`
 	Message     string            `yaml:"message"`
 	Alias       string            `yaml:"alias"`
 	Description string            `yaml:"description"`
 	Tags        []string          `yaml:"tags"`
 	Details     map[string]string `yaml:"details"`
+	// Responders are notified of the alert, VisibleTo are the teams and users who can see it without being notified
+	Responders []OpsgenieResponder `yaml:"responders"`
+	VisibleTo  []OpsgenieResponder `yaml:"visibleTo"`
+	Entity     string              `yaml:"entity"`
+	Source     string              `yaml:"source"`
+	// Close closes the alerts with the aliases of the reasons a recovery event recovers from
+	Close Recoveries `yaml:"close"`
+	// RepeatNote is a template added as a note to an alert created earlier by the sink when an event with the same
+	// alias arrives, instead of creating the alert again. The aliases are remembered in LRU caches of AliasCacheSize
+	// entries, for the repeats and per object and reason to close the alerts.
+	RepeatNote     string `yaml:"repeatNote"`
+	AliasCacheSize int    `yaml:"aliasCacheSize"`
+}
+
+type OpsgenieResponder struct {
+	// Type is team, user, escalation or schedule. Only teams and users can be in visibleTo.
+	Type string `yaml:"type"`
+	// Name is a template rendered to the name of the team, escalation or schedule, or to the username of the user.
+	// The responders rendered without a name nor an id are left out.
+	Name string `yaml:"name"`
+	ID   string `yaml:"id"`
 }
 
 type OpsgenieSink struct {
 	cfg         *OpsgenieConfig
 	alertClient *alert.Client
+	// aliases are the aliases of the alerts created by the sink, to add notes for the repeats
+	aliases *lru.Cache
+	// created are the aliases of the alerts created per object and reason, to close them
+	created *raisedAlerts[string]
 }
 
 func NewOpsgenieSink(config *OpsgenieConfig) (Sink, error) {
@@ -32,6 +68,10 @@ func NewOpsgenieSink(config *OpsgenieConfig) (Sink, error) {
 		config.Priority = "P3"
 	}
 
+	if err := validateOpsgenieConfig(config); err != nil {
+		return nil, err
+	}
+
 	alertClient, err := alert.NewClient(&client.Config{
 		ApiKey:         config.ApiKey,
 		OpsGenieAPIURL: config.URL,
@@ -41,36 +81,130 @@ func NewOpsgenieSink(config *OpsgenieConfig) (Sink, error) {
 		return nil, err
 	}
 
+	size := config.AliasCacheSize
+	if size <= 0 {
+		size = DefaultOpsgenieAliasCacheSize
+	}
+	// lru.New only fails for non-positive sizes
+	aliases, _ := lru.New(size)
+
 	return &OpsgenieSink{
 		cfg:         config,
 		alertClient: alertClient,
+		aliases:     aliases,
+		created:     newRaisedAlerts[string](size),
 	}, nil
 }
 
-func (o *OpsgenieSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	request := alert.CreateAlertRequest{
-		Priority: alert.Priority(o.cfg.Priority),
+func validateOpsgenieConfig(config *OpsgenieConfig) error {
+	templates := map[string]string{
+		"priority":    config.Priority,
+		"message":     config.Message,
+		"alias":       config.Alias,
+		"description": config.Description,
+		"entity":      config.Entity,
+		"source":      config.Source,
+		"repeatNote":  config.RepeatNote,
+	}
+	for i, tag := range config.Tags {
+		templates[fmt.Sprintf("tags[%d]", i)] = tag
+	}
+	for key, detail := range config.Details {
+		templates["details."+key] = detail
+	}
+	for i, r := range config.Responders {
+		if !slices.Contains([]string{"team", "user", "escalation", "schedule"}, r.Type) {
+			return fmt.Errorf("invalid opsgenie responder type %q: can be one of 'team', 'user', 'escalation' or 'schedule'", r.Type)
+		}
+		templates[fmt.Sprintf("responders[%d].name", i)] = r.Name
+	}
+	for i, r := range config.VisibleTo {
+		if r.Type != "team" && r.Type != "user" {
+			return fmt.Errorf("invalid opsgenie visibleTo type %q: can be one of 'team' or 'user'", r.Type)
+		}
+		templates[fmt.Sprintf("visibleTo[%d].name", i)] = r.Name
+	}
+	for name, text := range templates {
+		if err := ValidateTemplate(text); err != nil {
+			return fmt.Errorf("invalid opsgenie %s: %w", name, err)
+		}
 	}
 
-	msg, err := GetString(ev, o.cfg.Message)
-	if err != nil {
+	if err := config.Close.Validate(); err != nil {
 		return err
 	}
-	request.Message = msg
+	if config.Alias == "" && (config.Close != nil || config.RepeatNote != "") {
+		return errors.New("opsgenie alias is required to close alerts or to add notes for the repeats")
+	}
+	return nil
+}
+
+// Send creates an alert for the event. The alerts of the object are closed instead when the event is a recovery, and
+// a note is added to the alert when the event repeats an alert created earlier and repeat notes are enabled.
+func (o *OpsgenieSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	if recovered := o.cfg.Close.recovered(ev); recovered != nil {
+		return o.close(ctx, ev, recovered)
+	}
 
 	// Alias is optional although highly recommended to work
+	alias := ""
 	if o.cfg.Alias != "" {
-		alias, err := GetString(ev, o.cfg.Alias)
+		var err error
+		alias, err = GetString(ev, o.cfg.Alias)
 		if err != nil {
 			return err
 		}
-		request.Alias = alias
+	}
+	if o.cfg.RepeatNote != "" && alias != "" && o.aliases.Contains(alias) {
+		err := o.addNote(ctx, ev, alias)
+		if !opsgenieNotFound(err) {
+			return err
+		}
+		// The alert was deleted in Opsgenie, it is created again
+		o.aliases.Remove(alias)
 	}
 
-	description, err := GetString(ev, o.cfg.Description)
+	request, err := o.createRequest(ev)
 	if err != nil {
 		return err
 	}
+	request.Alias = alias
+
+	_, err = o.alertClient.Create(ctx, request)
+	if err != nil {
+		return opsgenieError(err)
+	}
+	if alias != "" {
+		o.aliases.Add(alias, nil)
+		if o.cfg.Close != nil {
+			o.created.add(ev, alias)
+		}
+	}
+	return nil
+}
+
+func (o *OpsgenieSink) createRequest(ev *kube.EnhancedEvent) (*alert.CreateAlertRequest, error) {
+	request := &alert.CreateAlertRequest{}
+
+	priority, err := GetString(ev, o.cfg.Priority)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(opsgeniePriorities, priority) {
+		return nil, Permanent(fmt.Errorf("invalid opsgenie priority %q", priority))
+	}
+	request.Priority = alert.Priority(priority)
+
+	msg, err := GetString(ev, o.cfg.Message)
+	if err != nil {
+		return nil, err
+	}
+	request.Message = msg
+
+	description, err := GetString(ev, o.cfg.Description)
+	if err != nil {
+		return nil, err
+	}
 	request.Description = description
 
 	if o.cfg.Tags != nil {
@@ -78,7 +212,7 @@ func (o *OpsgenieSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
 		for _, v := range o.cfg.Tags {
 			tag, err := GetString(ev, v)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			tags = append(tags, tag)
 		}
@@ -90,17 +224,129 @@ func (o *OpsgenieSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
 		for k, v := range o.cfg.Details {
 			detail, err := GetString(ev, v)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			details[k] = detail
 		}
 		request.Details = details
 	}
 
-	_, err = o.alertClient.Create(ctx, &request)
+	if request.Responders, err = renderOpsgenieResponders(ev, o.cfg.Responders); err != nil {
+		return nil, err
+	}
+	if request.VisibleTo, err = renderOpsgenieResponders(ev, o.cfg.VisibleTo); err != nil {
+		return nil, err
+	}
+
+	if request.Entity, err = GetString(ev, o.cfg.Entity); err != nil {
+		return nil, err
+	}
+	if request.Source, err = GetString(ev, o.cfg.Source); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func renderOpsgenieResponders(ev *kube.EnhancedEvent, responders []OpsgenieResponder) ([]alert.Responder, error) {
+	var result []alert.Responder
+	for _, r := range responders {
+		name, err := GetString(ev, r.Name)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" && r.ID == "" {
+			continue
+		}
+
+		responder := alert.Responder{Type: alert.ResponderType(r.Type), Id: r.ID}
+		if r.Type == "user" {
+			responder.Username = name
+		} else {
+			responder.Name = name
+		}
+		result = append(result, responder)
+	}
+	return result, nil
+}
+
+// close closes the alerts created for the recovered events, once per distinct alias. The aliases of the alerts created
+// before a restart are rendered from the recovered events instead.
+func (o *OpsgenieSink) close(ctx context.Context, ev *kube.EnhancedEvent, recovered []*kube.EnhancedEvent) error {
+	source, err := GetString(ev, o.cfg.Source)
+	if err != nil {
+		return err
+	}
+
+	closed := make(map[string]bool)
+	var errs []error
+	for _, r := range recovered {
+		aliases := o.created.get(r)
+		if len(aliases) == 0 {
+			alias, err := GetString(r, o.cfg.Alias)
+			if err != nil {
+				return err
+			}
+			aliases = []string{alias}
+		}
+
+		for _, alias := range aliases {
+			if alias == "" || closed[alias] {
+				continue
+			}
+			closed[alias] = true
+
+			_, err = o.alertClient.Close(ctx, &alert.CloseAlertRequest{
+				IdentifierType:  alert.ALIAS,
+				IdentifierValue: alias,
+				Source:          source,
+				Note:            ev.Reason + ": " + ev.Message,
+			})
+			if err != nil {
+				errs = append(errs, opsgenieError(err))
+				continue
+			}
+			o.aliases.Remove(alias)
+			o.created.remove(r, alias)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (o *OpsgenieSink) addNote(ctx context.Context, ev *kube.EnhancedEvent, alias string) error {
+	note, err := GetString(ev, o.cfg.RepeatNote)
+	if err != nil {
+		return err
+	}
+	source, err := GetString(ev, o.cfg.Source)
+	if err != nil {
+		return err
+	}
+
+	_, err = o.alertClient.AddNote(ctx, &alert.AddNoteRequest{
+		IdentifierType:  alert.ALIAS,
+		IdentifierValue: alias,
+		Source:          source,
+		Note:            note,
+	})
+	return opsgenieError(err)
+}
+
+// opsgenieError marks the errors of the requests rejected by Opsgenie as permanent, the client retries the others
+// already
+func opsgenieError(err error) error {
+	var apiErr *client.ApiError
+	if errors.As(err, &apiErr) && !retryableStatus(apiErr.StatusCode) {
+		return Permanent(err)
+	}
 	return err
 }
 
+// opsgenieNotFound tells whether Opsgenie has no alert with the identifier of the request
+func opsgenieNotFound(err error) bool {
+	var apiErr *client.ApiError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
 func (o *OpsgenieSink) Close() {
 	// No-op
 }
@@ -0,0 +1,226 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opsgenie/opsgenie-go-sdk-v2/client"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type opsgenieRequest struct {
+	path string
+	body map[string]any
+}
+
+// newOpsgenieStandIn answers the alert API requests as accepted and records them
+func newOpsgenieStandIn(t *testing.T, status int) (*[]opsgenieRequest, client.ApiUrl, func()) {
+	var mu sync.Mutex
+	var requests []opsgenieRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		mu.Lock()
+		requests = append(requests, opsgenieRequest{path: r.URL.Path + "?" + r.URL.RawQuery, body: body})
+		mu.Unlock()
+
+		w.Header().Set("X-RateLimit-State", "OK")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"result": "Request will be processed", "took": 0.1, "requestId": "43a29c5c"}`))
+	}))
+	// The client talks plain HTTP to the hosts without "api" in their names
+	return &requests, client.ApiUrl(strings.TrimPrefix(server.URL, "http://")), server.Close
+}
+
+func newOpsgenieEvent(reason, eventType string, count int32) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Reason = reason
+	ev.Type = eventType
+	ev.Count = count
+	ev.Message = "Node node-1 status is now: " + reason
+	ev.InvolvedObject.Kind = "Node"
+	ev.InvolvedObject.Name = "node-1"
+	return ev
+}
+
+func TestOpsgenieCreate(t *testing.T) {
+	requests, url, stop := newOpsgenieStandIn(t, http.StatusAccepted)
+	defer stop()
+
+	sink, err := NewOpsgenieSink(&OpsgenieConfig{
+		ApiKey:   "key",
+		URL:      url,
+		Priority: `{{ if eq .Type "Warning" }}P2{{ else }}P5{{ end }}`,
+		Message:  "{{ .Reason }} on {{ .InvolvedObject.Name }}",
+		Alias:    "{{ .InvolvedObject.Name }}/{{ .Reason }}",
+		Responders: []OpsgenieResponder{
+			{Type: "team", Name: `{{ if eq .InvolvedObject.Kind "Node" }}infra{{ end }}`},
+			{Type: "team", Name: `{{ if eq .InvolvedObject.Kind "Pod" }}apps{{ end }}`},
+			{Type: "user", Name: "oncall@example.com"},
+		},
+		VisibleTo: []OpsgenieResponder{{Type: "team", ID: "4513b7ea"}},
+		Entity:    "{{ .InvolvedObject.Kind }}/{{ .InvolvedObject.Name }}",
+		Source:    "kubernetes-event-exporter",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Send(context.Background(), newOpsgenieEvent("NodeNotReady", "Warning", 1)))
+	require.NoError(t, sink.Send(context.Background(), newOpsgenieEvent("RegisteredNode", "Normal", 1)))
+
+	require.Len(t, *requests, 2)
+	assert.Equal(t, "/v2/alerts?", (*requests)[0].path)
+	assert.Equal(t, map[string]any{
+		"message":  "NodeNotReady on node-1",
+		"alias":    "node-1/NodeNotReady",
+		"priority": "P2",
+		"responders": []any{
+			map[string]any{"type": "team", "name": "infra", "username": ""},
+			map[string]any{"type": "user", "username": "oncall@example.com"},
+		},
+		"visibleTo": []any{map[string]any{"type": "team", "id": "4513b7ea", "username": ""}},
+		"entity":    "Node/node-1",
+		"source":    "kubernetes-event-exporter",
+	}, (*requests)[0].body)
+	assert.Equal(t, "P5", (*requests)[1].body["priority"])
+}
+
+func TestOpsgenieRepeatNotesAndClose(t *testing.T) {
+	requests, url, stop := newOpsgenieStandIn(t, http.StatusAccepted)
+	defer stop()
+
+	sink, err := NewOpsgenieSink(&OpsgenieConfig{
+		ApiKey:     "key",
+		URL:        url,
+		Message:    "{{ .Reason }} on {{ .InvolvedObject.Name }}",
+		Alias:      "{{ .InvolvedObject.Name }}-{{ .Reason }}",
+		Source:     "exporter",
+		RepeatNote: "Seen {{ .Count }} times",
+		Close:      Recoveries{"NodeReady": {"NodeNotReady"}},
+	})
+	require.NoError(t, err)
+
+	for _, ev := range []*kube.EnhancedEvent{
+		newOpsgenieEvent("NodeNotReady", "Warning", 1),
+		newOpsgenieEvent("NodeNotReady", "Warning", 2),
+		newOpsgenieEvent("NodeReady", "Normal", 1),
+		// The alert is created again once closed
+		newOpsgenieEvent("NodeNotReady", "Warning", 3),
+	} {
+		require.NoError(t, sink.Send(context.Background(), ev))
+	}
+
+	require.Len(t, *requests, 4)
+	assert.Equal(t, "/v2/alerts?", (*requests)[0].path)
+	assert.Equal(t, "/v2/alerts/node-1-NodeNotReady/notes?identifierType=alias", (*requests)[1].path)
+	assert.Equal(t, "Seen 2 times", (*requests)[1].body["note"])
+	assert.Equal(t, "exporter", (*requests)[1].body["source"])
+	assert.Equal(t, "/v2/alerts/node-1-NodeNotReady/close?identifierType=alias", (*requests)[2].path)
+	assert.Equal(t, "NodeReady: Node node-1 status is now: NodeReady", (*requests)[2].body["note"])
+	assert.Equal(t, "/v2/alerts?", (*requests)[3].path)
+}
+
+func TestOpsgenieRepeatOfDeletedAlert(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+
+		w.Header().Set("X-RateLimit-State", "OK")
+		if strings.HasSuffix(r.URL.Path, "/notes") {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Alert does not exist", "took": 0.1, "requestId": "43a29c5c"}`))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"result": "Request will be processed", "took": 0.1, "requestId": "43a29c5c"}`))
+	}))
+	defer server.Close()
+
+	sink, err := NewOpsgenieSink(&OpsgenieConfig{
+		ApiKey:     "key",
+		URL:        client.ApiUrl(strings.TrimPrefix(server.URL, "http://")),
+		Message:    "{{ .Reason }} on {{ .InvolvedObject.Name }}",
+		Alias:      "{{ .InvolvedObject.Name }}-{{ .Reason }}",
+		RepeatNote: "Seen {{ .Count }} times",
+	})
+	require.NoError(t, err)
+
+	// The alert was deleted in Opsgenie since it was created, the repeats create it again
+	for i := int32(1); i <= 3; i++ {
+		require.NoError(t, sink.Send(context.Background(), newOpsgenieEvent("NodeNotReady", "Warning", i)))
+	}
+
+	assert.Equal(t, []string{
+		"/v2/alerts",
+		"/v2/alerts/node-1-NodeNotReady/notes",
+		"/v2/alerts",
+		"/v2/alerts/node-1-NodeNotReady/notes",
+		"/v2/alerts",
+	}, paths)
+}
+
+func TestOpsgenieCloseCustomAliases(t *testing.T) {
+	requests, url, stop := newOpsgenieStandIn(t, http.StatusAccepted)
+	defer stop()
+
+	// The alias depends on the type, which differs in the recovery event, the alert is closed with the alias created
+	sink, err := NewOpsgenieSink(&OpsgenieConfig{
+		ApiKey:  "key",
+		URL:     url,
+		Message: "{{ .Message }}",
+		Alias:   "{{ .InvolvedObject.Name }}-{{ .Type }}",
+		Close:   Recoveries{"NodeReady": {"NodeNotReady"}},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Send(context.Background(), newOpsgenieEvent("NodeNotReady", "Warning", 1)))
+	require.NoError(t, sink.Send(context.Background(), newOpsgenieEvent("NodeReady", "Normal", 1)))
+	require.Len(t, *requests, 2)
+	assert.Equal(t, "/v2/alerts/node-1-Warning/close?identifierType=alias", (*requests)[1].path)
+
+	// The aliases are rendered from the recovery event without alerts created
+	require.NoError(t, sink.Send(context.Background(), newOpsgenieEvent("NodeReady", "Normal", 1)))
+	require.Len(t, *requests, 3)
+	assert.Equal(t, "/v2/alerts/node-1-Normal/close?identifierType=alias", (*requests)[2].path)
+}
+
+func TestOpsgenieErrors(t *testing.T) {
+	_, url, stop := newOpsgenieStandIn(t, http.StatusUnprocessableEntity)
+	defer stop()
+
+	sink, err := NewOpsgenieSink(&OpsgenieConfig{ApiKey: "key", URL: url, Message: "{{ .Reason }}"})
+	require.NoError(t, err)
+	err = sink.Send(context.Background(), newOpsgenieEvent("NodeNotReady", "Warning", 1))
+	assert.True(t, IsPermanent(err))
+
+	sink, err = NewOpsgenieSink(&OpsgenieConfig{ApiKey: "key", URL: url, Message: "{{ .Reason }}", Priority: "{{ .Type }}"})
+	require.NoError(t, err)
+	err = sink.Send(context.Background(), newOpsgenieEvent("NodeNotReady", "Warning", 1))
+	assert.ErrorContains(t, err, `invalid opsgenie priority "Warning"`)
+	assert.True(t, IsPermanent(err))
+}
+
+func TestOpsgenieConfigErrors(t *testing.T) {
+	for name, cfg := range map[string]*OpsgenieConfig{
+		"invalid opsgenie responder type": {Responders: []OpsgenieResponder{{Type: "group"}}},
+		"invalid opsgenie visibleTo type": {VisibleTo: []OpsgenieResponder{{Type: "schedule"}}},
+		"invalid opsgenie entity":         {Entity: "{{ .InvolvedObject"},
+		"opsgenie alias is required":      {RepeatNote: "{{ .Count }}"},
+		"does not recover from any":       {Alias: "{{ .UID }}", Close: Recoveries{"NodeReady": {}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg.ApiKey = "key"
+			_, err := NewOpsgenieSink(cfg)
+			assert.ErrorContains(t, err, name)
+		})
+	}
+}
@@ -0,0 +1,439 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcgzip "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	OTLPProtocolGRPC         = "grpc"
+	OTLPProtocolHTTPProtobuf = "http/protobuf"
+
+	DefaultOTLPServiceName = "kubernetes-event-exporter"
+	otlpLogsPath           = "/v1/logs"
+	otlpScopeName          = "github.com/resmoio/kubernetes-event-exporter"
+)
+
+var defaultOTLPWriterConfig = batch.WriterConfig{
+	BatchSize:  500,
+	Interval:   time.Second,
+	MaxRetries: 3,
+	Timeout:    30 * time.Second,
+}
+
+// otlpKindAttributes are the semantic convention attributes naming the involved objects of the kinds they exist for
+var otlpKindAttributes = map[string]string{
+	"Pod":         "k8s.pod",
+	"Node":        "k8s.node",
+	"Deployment":  "k8s.deployment",
+	"ReplicaSet":  "k8s.replicaset",
+	"StatefulSet": "k8s.statefulset",
+	"DaemonSet":   "k8s.daemonset",
+	"Job":         "k8s.job",
+	"CronJob":     "k8s.cronjob",
+}
+
+type OTLPConfig struct {
+	// Endpoint is the host:port of the collector for gRPC, or its URL for HTTP, where /v1/logs is appended unless the
+	// URL has a path other than /
+	Endpoint string `yaml:"endpoint"`
+	// Protocol is grpc, by default, or http/protobuf
+	Protocol string            `yaml:"protocol"`
+	Headers  map[string]string `yaml:"headers"`
+	// Insecure connects to the gRPC endpoint without TLS
+	Insecure bool          `yaml:"insecure"`
+	TLS      TLS           `yaml:"tls"`
+	Gzip     bool          `yaml:"gzip"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// ResourceAttributes are added to the resource of the logs, with service.name and k8s.cluster.name
+	ResourceAttributes map[string]string `yaml:"resourceAttributes"`
+	// Attributes are templates added to the attributes of the log records, the ones rendered empty are left out
+	Attributes map[string]string `yaml:"attributes"`
+	// Layout is the body of the log records, the message of the event by default
+	Layout map[string]any `yaml:"layout"`
+	Batch  BatchConfig    `yaml:"batch"`
+}
+
+type OTLPSink struct {
+	cfg        *OTLPConfig
+	grpcConn   *grpc.ClientConn
+	grpcClient collogspb.LogsServiceClient
+	httpClient *http.Client
+	url        string
+}
+
+func NewOTLPSink(cfg *OTLPConfig) (Sink, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("otlp endpoint cannot be empty")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	for name, text := range cfg.Attributes {
+		if err := ValidateTemplate(text); err != nil {
+			return nil, fmt.Errorf("invalid otlp attribute %s: %w", name, err)
+		}
+	}
+	if err := validateTemplates(cfg.Layout); err != nil {
+		return nil, fmt.Errorf("invalid otlp layout: %w", err)
+	}
+
+	tlsClientConfig, err := setupTLS(&cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup TLS: %w", err)
+	}
+
+	o := &OTLPSink{cfg: cfg}
+	switch cfg.Protocol {
+	case "", OTLPProtocolGRPC:
+		cfg.Protocol = OTLPProtocolGRPC
+		creds := credentials.NewTLS(tlsClientConfig)
+		if cfg.Insecure {
+			creds = insecure.NewCredentials()
+		}
+		opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+		if cfg.Gzip {
+			opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(grpcgzip.Name)))
+		}
+		o.grpcConn, err = grpc.NewClient(cfg.Endpoint, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create the otlp grpc client: %w", err)
+		}
+		o.grpcClient = collogspb.NewLogsServiceClient(o.grpcConn)
+	case OTLPProtocolHTTPProtobuf:
+		u, err := url.Parse(cfg.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid otlp endpoint: %w", err)
+		}
+		if u.Path == "" || u.Path == "/" {
+			u.Path = otlpLogsPath
+		}
+		o.url = u.String()
+		o.httpClient = &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsClientConfig,
+			},
+		}
+	default:
+		return nil, fmt.Errorf("invalid otlp protocol %q: can be one of 'grpc' or 'http/protobuf'", cfg.Protocol)
+	}
+	return o, nil
+}
+
+func (o *OTLPSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	return o.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// SendBatch exports the events as the log records of a single request, grouped by their clusters
+func (o *OTLPSink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	included := make([]int, 0, len(evs))
+	resources := make(map[string]*logspb.ResourceLogs)
+	order := make([]string, 0)
+
+	for i, ev := range evs {
+		record, err := o.logRecord(ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+
+		rl, ok := resources[ev.ClusterName]
+		if !ok {
+			rl = &logspb.ResourceLogs{
+				Resource:  &resourcepb.Resource{Attributes: o.resourceAttributes(ev.ClusterName)},
+				ScopeLogs: []*logspb.ScopeLogs{{Scope: &commonpb.InstrumentationScope{Name: otlpScopeName}}},
+			}
+			resources[ev.ClusterName] = rl
+			order = append(order, ev.ClusterName)
+		}
+		rl.ScopeLogs[0].LogRecords = append(rl.ScopeLogs[0].LogRecords, record)
+		included = append(included, i)
+	}
+	if len(included) == 0 {
+		return errs
+	}
+
+	req := &collogspb.ExportLogsServiceRequest{}
+	for _, cluster := range order {
+		req.ResourceLogs = append(req.ResourceLogs, resources[cluster])
+	}
+	if err := o.export(ctx, req); err != nil {
+		setErrors(errs, included, err)
+	}
+	return errs
+}
+
+func (o *OTLPSink) BatchConfig() batch.WriterConfig {
+	return o.cfg.Batch.WriterConfig(defaultOTLPWriterConfig)
+}
+
+func (o *OTLPSink) resourceAttributes(cluster string) []*commonpb.KeyValue {
+	attrs := map[string]string{"service.name": DefaultOTLPServiceName}
+	for k, v := range o.cfg.ResourceAttributes {
+		attrs[k] = v
+	}
+	if cluster != "" {
+		attrs["k8s.cluster.name"] = cluster
+	}
+	return otlpStringAttributes(attrs)
+}
+
+// logRecord converts the event, with the attributes of the k8s semantic conventions and of the events receiver of the
+// OpenTelemetry Collector
+func (o *OTLPSink) logRecord(ev *kube.EnhancedEvent) (*logspb.LogRecord, error) {
+	obj := ev.InvolvedObject
+	attrs := map[string]string{
+		"k8s.namespace.name":             obj.Namespace,
+		"k8s.object.kind":                obj.Kind,
+		"k8s.object.name":                obj.Name,
+		"k8s.object.uid":                 string(obj.UID),
+		"k8s.object.api_version":         obj.APIVersion,
+		"k8s.object.resource_version":    obj.ResourceVersion,
+		"k8s.object.fieldpath":           obj.FieldPath,
+		"k8s.event.name":                 ev.Name,
+		"k8s.event.uid":                  string(ev.UID),
+		"k8s.event.reason":               ev.Reason,
+		"k8s.event.action":               ev.Action,
+		"k8s.event.reporting_controller": ev.ReportingController,
+		"k8s.event.reporting_instance":   ev.ReportingInstance,
+	}
+	if prefix, ok := otlpKindAttributes[obj.Kind]; ok {
+		attrs[prefix+".name"] = obj.Name
+		attrs[prefix+".uid"] = string(obj.UID)
+	}
+	if obj.Kind == "Pod" && ev.Source.Host != "" {
+		attrs["k8s.node.name"] = ev.Source.Host
+	}
+	for name, text := range o.cfg.Attributes {
+		value, err := GetString(ev, text)
+		if err != nil {
+			return nil, fmt.Errorf("cannot render the otlp attribute %s: %w", name, err)
+		}
+		attrs[name] = value
+	}
+
+	record := &logspb.LogRecord{
+		ObservedTimeUnixNano: uint64(time.Now().UnixNano()),
+		SeverityText:         ev.Type,
+		SeverityNumber:       otlpSeverity(ev.Type),
+		EventName:            ev.Reason,
+		Attributes:           otlpStringAttributes(attrs),
+		Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: ev.Message}},
+	}
+	if ms := ev.GetTimestampMs(); ms > 0 {
+		record.TimeUnixNano = uint64(ms) * uint64(time.Millisecond)
+	}
+	if ev.Count > 0 {
+		record.Attributes = append(record.Attributes, &commonpb.KeyValue{
+			Key:   "k8s.event.count",
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(ev.Count)}},
+		})
+	}
+
+	if o.cfg.Layout != nil {
+		body, err := convertLayoutTemplate(o.cfg.Layout, ev)
+		if err != nil {
+			return nil, err
+		}
+		record.Body = otlpAnyValue(body)
+	}
+	return record, nil
+}
+
+func otlpSeverity(eventType string) logspb.SeverityNumber {
+	switch eventType {
+	case "Normal":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_INFO
+	case "Warning":
+		return logspb.SeverityNumber_SEVERITY_NUMBER_WARN
+	}
+	return logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED
+}
+
+// otlpStringAttributes converts the attributes in the order of their keys, leaving out the empty ones
+func otlpStringAttributes(attrs map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k, v := range attrs {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	kvs := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		kvs = append(kvs, &commonpb.KeyValue{
+			Key:   k,
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: attrs[k]}},
+		})
+	}
+	return kvs
+}
+
+// otlpAnyValue converts a rendered layout into a value of the body
+func otlpAnyValue(value any) *commonpb.AnyValue {
+	switch v := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		kvs := make([]*commonpb.KeyValue, 0, len(v))
+		for _, k := range keys {
+			kvs = append(kvs, &commonpb.KeyValue{Key: k, Value: otlpAnyValue(v[k])})
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: &commonpb.KeyValueList{Values: kvs}}}
+	case []any:
+		values := make([]*commonpb.AnyValue, 0, len(v))
+		for _, item := range v {
+			values = append(values, otlpAnyValue(item))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: &commonpb.ArrayValue{Values: values}}}
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+	case int:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v)}}
+	case int64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v}}
+	case uint64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: int64(v)}}
+	case float64:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+	case nil:
+		return &commonpb.AnyValue{}
+	}
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: fmt.Sprint(value)}}
+}
+
+func (o *OTLPSink) export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, o.cfg.Timeout)
+	defer cancel()
+
+	var resp *collogspb.ExportLogsServiceResponse
+	var err error
+	if o.grpcClient != nil {
+		resp, err = o.exportGRPC(ctx, req)
+	} else {
+		resp, err = o.exportHTTP(ctx, req)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The rejected records must not be retried and the collector does not tell which ones they are
+	if ps := resp.GetPartialSuccess(); ps.GetRejectedLogRecords() > 0 {
+		slog.With("rejected", ps.GetRejectedLogRecords(), "err", ps.GetErrorMessage()).Warn("The OTLP collector rejected log records")
+	}
+	return nil
+}
+
+func (o *OTLPSink) exportGRPC(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	for k, v := range o.cfg.Headers {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
+	resp, err := o.grpcClient.Export(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+
+	switch status.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.OutOfRange,
+		codes.Unavailable, codes.DataLoss:
+		return nil, err
+	}
+	return nil, Permanent(err)
+}
+
+func (o *OTLPSink) exportHTTP(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	if o.cfg.Gzip {
+		buf := new(bytes.Buffer)
+		gz := gzip.NewWriter(buf)
+		if _, err := gz.Write(body); err != nil {
+			return nil, Permanent(err)
+		}
+		if err := gz.Close(); err != nil {
+			return nil, Permanent(err)
+		}
+		body = buf.Bytes()
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, Permanent(err)
+	}
+	for k, v := range o.cfg.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	if o.cfg.Gzip {
+		httpReq.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := o.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("otlp export failed with status %d: %s", resp.StatusCode, string(respBody))
+		if !retryableStatus(resp.StatusCode) {
+			return nil, Permanent(err)
+		}
+		return nil, err
+	}
+
+	exportResp := &collogspb.ExportLogsServiceResponse{}
+	if err := proto.Unmarshal(respBody, exportResp); err != nil {
+		// The body of a successful export is optional
+		return &collogspb.ExportLogsServiceResponse{}, nil
+	}
+	return exportResp, nil
+}
+
+func (o *OTLPSink) Close() {
+	if o.grpcConn != nil {
+		_ = o.grpcConn.Close()
+	}
+	if o.httpClient != nil {
+		o.httpClient.CloseIdleConnections()
+	}
+}
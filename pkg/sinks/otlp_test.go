@@ -0,0 +1,267 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// otlpCollector is an in-process logs service recording the requests it receives
+type otlpCollector struct {
+	collogspb.UnimplementedLogsServiceServer
+
+	mu       sync.Mutex
+	requests []*collogspb.ExportLogsServiceRequest
+	metadata []metadata.MD
+	err      error
+}
+
+func (c *otlpCollector) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	md, _ := metadata.FromIncomingContext(ctx)
+	c.requests = append(c.requests, req)
+	c.metadata = append(c.metadata, md)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+func newOTLPCollector(t *testing.T) (*otlpCollector, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	collector := &otlpCollector{}
+	server := grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(server, collector)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+	return collector, lis.Addr().String()
+}
+
+func newOTLPEvent(kind, name, eventType string) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Name = name + ".17a"
+	ev.Namespace = "default"
+	ev.ClusterName = "prod"
+	ev.Type = eventType
+	ev.Reason = "BackOff"
+	ev.Message = "Back-off restarting failed container"
+	ev.Count = 3
+	ev.FirstTimestamp = metav1.NewTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	ev.InvolvedObject.Kind = kind
+	ev.InvolvedObject.Name = name
+	ev.InvolvedObject.Namespace = "default"
+	ev.InvolvedObject.UID = types.UID("uid-" + name)
+	return ev
+}
+
+func otlpAttributes(kvs []*commonpb.KeyValue) map[string]any {
+	attrs := make(map[string]any)
+	for _, kv := range kvs {
+		switch v := kv.Value.Value.(type) {
+		case *commonpb.AnyValue_StringValue:
+			attrs[kv.Key] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			attrs[kv.Key] = v.IntValue
+		}
+	}
+	return attrs
+}
+
+func TestOTLPGRPCExport(t *testing.T) {
+	collector, addr := newOTLPCollector(t)
+
+	sink, err := NewOTLPSink(&OTLPConfig{
+		Endpoint:           addr,
+		Insecure:           true,
+		Gzip:               true,
+		Headers:            map[string]string{"Authorization": "Bearer token"},
+		ResourceAttributes: map[string]string{"deployment.environment": "production"},
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	other := newOTLPEvent("Node", "node-1", "Normal")
+	other.ClusterName = "staging"
+	errs := sink.(*OTLPSink).SendBatch(context.Background(), []*kube.EnhancedEvent{
+		newOTLPEvent("Pod", "nginx", "Warning"),
+		other,
+		newOTLPEvent("ConfigMap", "settings", "Custom"),
+	})
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+
+	require.Len(t, collector.requests, 1)
+	assert.Equal(t, []string{"Bearer token"}, collector.metadata[0].Get("authorization"))
+
+	// The records are grouped by the clusters of the events
+	resourceLogs := collector.requests[0].ResourceLogs
+	require.Len(t, resourceLogs, 2)
+	assert.Equal(t, map[string]any{
+		"service.name":           DefaultOTLPServiceName,
+		"deployment.environment": "production",
+		"k8s.cluster.name":       "prod",
+	}, otlpAttributes(resourceLogs[0].Resource.Attributes))
+	assert.Equal(t, "staging", otlpAttributes(resourceLogs[1].Resource.Attributes)["k8s.cluster.name"])
+
+	records := resourceLogs[0].ScopeLogs[0].LogRecords
+	require.Len(t, records, 2)
+	pod := records[0]
+	assert.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_WARN, pod.SeverityNumber)
+	assert.Equal(t, "Warning", pod.SeverityText)
+	assert.Equal(t, "Back-off restarting failed container", pod.Body.GetStringValue())
+	assert.Equal(t, uint64(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).UnixNano()), pod.TimeUnixNano)
+	assert.NotZero(t, pod.ObservedTimeUnixNano)
+	assert.Equal(t, map[string]any{
+		"k8s.namespace.name": "default",
+		"k8s.object.kind":    "Pod",
+		"k8s.object.name":    "nginx",
+		"k8s.object.uid":     "uid-nginx",
+		"k8s.pod.name":       "nginx",
+		"k8s.pod.uid":        "uid-nginx",
+		"k8s.event.name":     "nginx.17a",
+		"k8s.event.reason":   "BackOff",
+		"k8s.event.count":    int64(3),
+	}, otlpAttributes(pod.Attributes))
+
+	// The kinds without their own attributes only have the object ones
+	configMap := otlpAttributes(records[1].Attributes)
+	assert.Equal(t, "settings", configMap["k8s.object.name"])
+	assert.NotContains(t, configMap, "k8s.configmap.name")
+	assert.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_UNSPECIFIED, records[1].SeverityNumber)
+	assert.Equal(t, "Custom", records[1].SeverityText)
+
+	node := resourceLogs[1].ScopeLogs[0].LogRecords[0]
+	assert.Equal(t, logspb.SeverityNumber_SEVERITY_NUMBER_INFO, node.SeverityNumber)
+	assert.Equal(t, "node-1", otlpAttributes(node.Attributes)["k8s.node.name"])
+}
+
+func TestOTLPGRPCErrors(t *testing.T) {
+	collector, addr := newOTLPCollector(t)
+	sink, err := NewOTLPSink(&OTLPConfig{Endpoint: addr, Insecure: true})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	collector.err = status.Error(codes.Unavailable, "overloaded")
+	err = sink.Send(context.Background(), newOTLPEvent("Pod", "nginx", "Warning"))
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+
+	collector.err = status.Error(codes.InvalidArgument, "bad request")
+	err = sink.Send(context.Background(), newOTLPEvent("Pod", "nginx", "Warning"))
+	require.Error(t, err)
+	assert.True(t, IsPermanent(err))
+}
+
+func TestOTLPHTTPExport(t *testing.T) {
+	var requests []*http.Request
+	var bodies []*collogspb.ExportLogsServiceRequest
+	statusCode := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reader io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			reader = gz
+		}
+		body, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		req := &collogspb.ExportLogsServiceRequest{}
+		require.NoError(t, proto.Unmarshal(body, req))
+		requests = append(requests, r)
+		bodies = append(bodies, req)
+
+		resp, err := proto.Marshal(&collogspb.ExportLogsServiceResponse{})
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(statusCode)
+		_, _ = io.Copy(w, bytes.NewReader(resp))
+	}))
+	defer server.Close()
+
+	sink, err := NewOTLPSink(&OTLPConfig{
+		Endpoint: server.URL,
+		Protocol: OTLPProtocolHTTPProtobuf,
+		Gzip:     true,
+		Headers:  map[string]string{"X-Tenant": "team-a"},
+		Attributes: map[string]string{
+			"team": "{{ .InvolvedObject.Namespace }}",
+		},
+		Layout: map[string]any{
+			"reason":  "{{ .Reason }}",
+			"message": "{{ .Message }}",
+		},
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.Send(context.Background(), newOTLPEvent("Pod", "nginx", "Warning")))
+	require.Len(t, requests, 1)
+	assert.Equal(t, "/v1/logs", requests[0].URL.Path)
+	assert.Equal(t, "application/x-protobuf", requests[0].Header.Get("Content-Type"))
+	assert.Equal(t, "team-a", requests[0].Header.Get("X-Tenant"))
+
+	record := bodies[0].ResourceLogs[0].ScopeLogs[0].LogRecords[0]
+	assert.Equal(t, "default", otlpAttributes(record.Attributes)["team"])
+	// The layout is the body as a map
+	assert.Equal(t, map[string]any{
+		"message": "Back-off restarting failed container",
+		"reason":  "BackOff",
+	}, otlpAttributes(record.Body.GetKvlistValue().Values))
+
+	statusCode = http.StatusServiceUnavailable
+	err = sink.Send(context.Background(), newOTLPEvent("Pod", "nginx", "Warning"))
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+
+	statusCode = http.StatusBadRequest
+	err = sink.Send(context.Background(), newOTLPEvent("Pod", "nginx", "Warning"))
+	require.Error(t, err)
+	assert.True(t, IsPermanent(err))
+
+	// The logs path is appended to the root path, the other paths are kept
+	statusCode = http.StatusOK
+	for endpoint, path := range map[string]string{
+		server.URL + "/":             "/v1/logs",
+		server.URL + "/otlp/v1/logs": "/otlp/v1/logs",
+	} {
+		sink, err := NewOTLPSink(&OTLPConfig{Endpoint: endpoint, Protocol: OTLPProtocolHTTPProtobuf})
+		require.NoError(t, err)
+		require.NoError(t, sink.Send(context.Background(), newOTLPEvent("Pod", "nginx", "Warning")))
+		assert.Equal(t, path, requests[len(requests)-1].URL.Path)
+		sink.Close()
+	}
+}
+
+func TestOTLPConfigErrors(t *testing.T) {
+	_, err := NewOTLPSink(&OTLPConfig{})
+	assert.Error(t, err)
+
+	_, err = NewOTLPSink(&OTLPConfig{Endpoint: "localhost:4317", Protocol: "http/json"})
+	assert.Error(t, err)
+
+	_, err = NewOTLPSink(&OTLPConfig{Endpoint: "localhost:4317", Attributes: map[string]string{"a": "{{ .Reason"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,298 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+)
+
+const (
+	DefaultPagerDutyURL        = "https://events.pagerduty.com/v2/enqueue"
+	DefaultPagerDutySummary    = "{{ .Reason }}: {{ .InvolvedObject.Kind }} {{ with .InvolvedObject.Namespace }}{{ . }}/{{ end }}{{ .InvolvedObject.Name }}: {{ .Message }}"
+	DefaultPagerDutySource     = "{{ with .ClusterName }}{{ . }}/{{ end }}{{ with .InvolvedObject.Namespace }}{{ . }}/{{ end }}{{ .InvolvedObject.Name }}"
+	DefaultPagerDutySeverity   = `{{ if eq .Type "Warning" }}warning{{ else }}info{{ end }}`
+	DefaultPagerDutyDedupKey   = "{{ .InvolvedObject.Kind }}/{{ .InvolvedObject.Namespace }}/{{ .InvolvedObject.Name }}/{{ .Reason }}"
+	DefaultPagerDutyMaxRetries = 3
+	pagerDutyMaxSummaryLength  = 1024
+)
+
+var pagerDutySeverities = []string{"critical", "error", "warning", "info"}
+
+type PagerDutyConfig struct {
+	// RoutingKey is the integration key of the service, a template rendered with the event
+	RoutingKey string `yaml:"routingKey"`
+	// URL is the Events API v2 endpoint, the public one by default
+	URL     string `yaml:"url"`
+	Summary string `yaml:"summary"`
+	Source  string `yaml:"source"`
+	// Severity is a template rendered to one of critical, error, warning or info, from the type of the event by
+	// default. ReasonSeverities override it for the events with the given reasons.
+	Severity         string            `yaml:"severity"`
+	ReasonSeverities map[string]string `yaml:"reasonSeverities"`
+	Component        string            `yaml:"component"`
+	Group            string            `yaml:"group"`
+	Class            string            `yaml:"class"`
+	// DedupKey identifies the alert, the events with the same key are grouped into one incident
+	DedupKey string `yaml:"dedupKey"`
+	// Layout is the custom_details of the alerts, the whole event by default
+	Layout map[string]any `yaml:"layout"`
+	// Resolve sends a resolve for the alerts of the same object when a recovery reason arrives. The keys of the alerts
+	// triggered are remembered in an LRU cache of DedupKeyCacheSize objects and reasons to resolve them.
+	Resolve           Recoveries `yaml:"resolve"`
+	DedupKeyCacheSize int        `yaml:"dedupKeyCacheSize"`
+	// MaxRetries is the number of times the rate limited and failed requests are retried, 3 by default
+	MaxRetries int `yaml:"maxRetries"`
+}
+
+type PagerDutySink struct {
+	cfg    *PagerDutyConfig
+	client *http.Client
+	// triggered are the routing and dedup keys of the alerts triggered, per object and reason
+	triggered *raisedAlerts[pagerDutyKey]
+}
+
+type pagerDutyKey struct {
+	routingKey string
+	dedupKey   string
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key,omitempty"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Client      string            `json:"client,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string `json:"summary"`
+	Source        string `json:"source"`
+	Severity      string `json:"severity"`
+	Timestamp     string `json:"timestamp,omitempty"`
+	Component     string `json:"component,omitempty"`
+	Group         string `json:"group,omitempty"`
+	Class         string `json:"class,omitempty"`
+	CustomDetails any    `json:"custom_details,omitempty"`
+}
+
+func NewPagerDutySink(cfg *PagerDutyConfig) (Sink, error) {
+	if cfg.RoutingKey == "" {
+		return nil, errors.New("pagerduty routingKey cannot be empty")
+	}
+	if cfg.URL == "" {
+		cfg.URL = DefaultPagerDutyURL
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = DefaultPagerDutyMaxRetries
+	}
+	for field, def := range map[*string]string{
+		&cfg.Summary:   DefaultPagerDutySummary,
+		&cfg.Source:    DefaultPagerDutySource,
+		&cfg.Severity:  DefaultPagerDutySeverity,
+		&cfg.DedupKey:  DefaultPagerDutyDedupKey,
+		&cfg.Component: "{{ .InvolvedObject.Kind }}",
+		&cfg.Group:     "{{ .InvolvedObject.Namespace }}",
+		&cfg.Class:     "{{ .Reason }}",
+	} {
+		if *field == "" {
+			*field = def
+		}
+	}
+
+	for name, text := range map[string]string{
+		"routingKey": cfg.RoutingKey,
+		"summary":    cfg.Summary,
+		"source":     cfg.Source,
+		"severity":   cfg.Severity,
+		"component":  cfg.Component,
+		"group":      cfg.Group,
+		"class":      cfg.Class,
+		"dedupKey":   cfg.DedupKey,
+	} {
+		if err := ValidateTemplate(text); err != nil {
+			return nil, fmt.Errorf("invalid pagerduty %s: %w", name, err)
+		}
+	}
+	for reason, severity := range cfg.ReasonSeverities {
+		if !slices.Contains(pagerDutySeverities, severity) {
+			return nil, fmt.Errorf("invalid pagerduty severity %s for %s: can be one of 'critical', 'error', 'warning' or 'info'", severity, reason)
+		}
+	}
+	if err := cfg.Resolve.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &PagerDutySink{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		triggered: newRaisedAlerts[pagerDutyKey](cfg.DedupKeyCacheSize),
+	}, nil
+}
+
+// Send triggers an alert for the event, or resolves the alerts of the object it recovers from
+func (p *PagerDutySink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	if recovered := p.cfg.Resolve.recovered(ev); recovered != nil {
+		return p.resolve(ctx, recovered)
+	}
+
+	event, err := p.trigger(ev)
+	if err != nil {
+		return err
+	}
+	if err := p.post(ctx, event); err != nil {
+		return err
+	}
+	if p.cfg.Resolve != nil {
+		p.triggered.add(ev, pagerDutyKey{routingKey: event.RoutingKey, dedupKey: event.DedupKey})
+	}
+	return nil
+}
+
+func (p *PagerDutySink) trigger(ev *kube.EnhancedEvent) (*pagerDutyEvent, error) {
+	rendered := make(map[string]string)
+	for name, text := range map[string]string{
+		"routingKey": p.cfg.RoutingKey,
+		"summary":    p.cfg.Summary,
+		"source":     p.cfg.Source,
+		"severity":   p.cfg.Severity,
+		"component":  p.cfg.Component,
+		"group":      p.cfg.Group,
+		"class":      p.cfg.Class,
+		"dedupKey":   p.cfg.DedupKey,
+	} {
+		value, err := GetString(ev, text)
+		if err != nil {
+			return nil, fmt.Errorf("cannot render the pagerduty %s: %w", name, err)
+		}
+		rendered[name] = value
+	}
+
+	severity := rendered["severity"]
+	if s, ok := p.cfg.ReasonSeverities[ev.Reason]; ok {
+		severity = s
+	}
+	if !slices.Contains(pagerDutySeverities, severity) {
+		return nil, Permanent(fmt.Errorf("invalid pagerduty severity %q", severity))
+	}
+	if rendered["source"] == "" {
+		return nil, Permanent(errors.New("pagerduty source rendered empty"))
+	}
+
+	var details any = json.RawMessage(ev.ToJSON())
+	if p.cfg.Layout != nil {
+		res, err := convertLayoutTemplate(p.cfg.Layout, ev)
+		if err != nil {
+			return nil, err
+		}
+		details = res
+	}
+
+	payload := &pagerDutyPayload{
+		Summary:       truncate(rendered["summary"], pagerDutyMaxSummaryLength),
+		Source:        rendered["source"],
+		Severity:      severity,
+		Component:     rendered["component"],
+		Group:         rendered["group"],
+		Class:         rendered["class"],
+		CustomDetails: details,
+	}
+	if ms := ev.GetTimestampMs(); ms > 0 {
+		payload.Timestamp = time.UnixMilli(ms).UTC().Format(time.RFC3339Nano)
+	}
+
+	return &pagerDutyEvent{
+		RoutingKey:  rendered["routingKey"],
+		EventAction: "trigger",
+		DedupKey:    rendered["dedupKey"],
+		Payload:     payload,
+		Client:      "kubernetes-event-exporter",
+	}, nil
+}
+
+// resolve resolves the alerts triggered for the recovered events, once per distinct routing and dedup key. The keys of
+// the alerts triggered before a restart are rendered from the recovered events instead.
+func (p *PagerDutySink) resolve(ctx context.Context, recovered []*kube.EnhancedEvent) error {
+	sent := make(map[pagerDutyKey]bool)
+	var errs []error
+	for _, ev := range recovered {
+		keys := p.triggered.get(ev)
+		if len(keys) == 0 {
+			routingKey, err := GetString(ev, p.cfg.RoutingKey)
+			if err != nil {
+				return err
+			}
+			dedupKey, err := GetString(ev, p.cfg.DedupKey)
+			if err != nil {
+				return err
+			}
+			keys = []pagerDutyKey{{routingKey: routingKey, dedupKey: dedupKey}}
+		}
+
+		for _, key := range keys {
+			if sent[key] {
+				continue
+			}
+			sent[key] = true
+
+			err := p.post(ctx, &pagerDutyEvent{
+				RoutingKey:  key.routingKey,
+				EventAction: "resolve",
+				DedupKey:    key.dedupKey,
+			})
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			p.triggered.remove(ev, key)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// post sends the event, retrying the rate limited and failed requests up to MaxRetries times
+func (p *PagerDutySink) post(ctx context.Context, event *pagerDutyEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return Permanent(err)
+	}
+	return withRetries(ctx, "pagerduty", p.cfg.MaxRetries, func() (time.Duration, error) {
+		return p.postOnce(ctx, event, body)
+	})
+}
+
+// postOnce posts the body, it returns how long to wait before retrying when the request is rate limited or -1
+func (p *PagerDutySink) postOnce(ctx context.Context, event *pagerDutyEvent, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return -1, Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return -1, nil
+	}
+	rb, _ := io.ReadAll(resp.Body)
+	err = fmt.Errorf("pagerduty %s of %s failed with status %d: %s", event.EventAction, event.DedupKey, resp.StatusCode, string(rb))
+	if !retryableStatus(resp.StatusCode) {
+		return -1, Permanent(err)
+	}
+	return retryAfter(resp.Header.Get("Retry-After"), -1), err
+}
+
+func (p *PagerDutySink) Close() {
+	p.client.CloseIdleConnections()
+}
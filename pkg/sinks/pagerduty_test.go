@@ -0,0 +1,242 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newPagerDutyServer(t *testing.T, status *int) (*[]map[string]any, *httptest.Server) {
+	var events []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]any
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events = append(events, event)
+		if status != nil && *status != 0 {
+			w.WriteHeader(*status)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return &events, server
+}
+
+func newNodeEvent(reason, eventType string) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Reason = reason
+	ev.Type = eventType
+	ev.Message = "Node node-1 status is now: " + reason
+	ev.ClusterName = "prod"
+	ev.InvolvedObject.Kind = "Node"
+	ev.InvolvedObject.Name = "node-1"
+	ev.FirstTimestamp = metav1.NewTime(time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC))
+	return ev
+}
+
+func TestPagerDutyTrigger(t *testing.T) {
+	events, server := newPagerDutyServer(t, nil)
+	defer server.Close()
+
+	sink, err := NewPagerDutySink(&PagerDutyConfig{
+		URL:        server.URL,
+		RoutingKey: `{{ if eq .InvolvedObject.Kind "Node" }}infra-key{{ else }}apps-key{{ end }}`,
+		Layout:     map[string]any{"message": "{{ .Message }}"},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Send(context.Background(), newNodeEvent("NodeNotReady", "Warning")))
+	require.Len(t, *events, 1)
+	assert.Equal(t, map[string]any{
+		"routing_key":  "infra-key",
+		"event_action": "trigger",
+		"dedup_key":    "Node//node-1/NodeNotReady",
+		"client":       "kubernetes-event-exporter",
+		"payload": map[string]any{
+			"summary":        "NodeNotReady: Node node-1: Node node-1 status is now: NodeNotReady",
+			"source":         "prod/node-1",
+			"severity":       "warning",
+			"timestamp":      "2024-05-06T07:08:09Z",
+			"component":      "Node",
+			"class":          "NodeNotReady",
+			"custom_details": map[string]any{"message": "Node node-1 status is now: NodeNotReady"},
+		},
+	}, (*events)[0])
+}
+
+func TestPagerDutySeverities(t *testing.T) {
+	events, server := newPagerDutyServer(t, nil)
+	defer server.Close()
+
+	sink, err := NewPagerDutySink(&PagerDutyConfig{
+		URL:              server.URL,
+		RoutingKey:       "key",
+		ReasonSeverities: map[string]string{"OOMKilling": "critical"},
+	})
+	require.NoError(t, err)
+
+	for _, ev := range []*kube.EnhancedEvent{
+		newNodeEvent("OOMKilling", "Warning"),
+		newNodeEvent("NodeNotReady", "Warning"),
+		newNodeEvent("RegisteredNode", "Normal"),
+	} {
+		require.NoError(t, sink.Send(context.Background(), ev))
+	}
+
+	severities := make([]any, 0)
+	for _, event := range *events {
+		severities = append(severities, event["payload"].(map[string]any)["severity"])
+	}
+	assert.Equal(t, []any{"critical", "warning", "info"}, severities)
+
+	sink, err = NewPagerDutySink(&PagerDutyConfig{URL: server.URL, RoutingKey: "key", Severity: "{{ .Reason }}"})
+	require.NoError(t, err)
+	err = sink.Send(context.Background(), newNodeEvent("NodeNotReady", "Warning"))
+	assert.True(t, IsPermanent(err), "an invalid severity is not retried")
+}
+
+func TestPagerDutyResolve(t *testing.T) {
+	events, server := newPagerDutyServer(t, nil)
+	defer server.Close()
+
+	sink, err := NewPagerDutySink(&PagerDutyConfig{
+		URL:        server.URL,
+		RoutingKey: "key",
+		Resolve:    Recoveries{"NodeReady": {"NodeNotReady", "NodeNotSchedulable"}},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Send(context.Background(), newNodeEvent("NodeNotReady", "Warning")))
+	require.NoError(t, sink.Send(context.Background(), newNodeEvent("NodeReady", "Normal")))
+
+	require.Len(t, *events, 3)
+	assert.Equal(t, "trigger", (*events)[0]["event_action"])
+	assert.Equal(t, map[string]any{
+		"routing_key":  "key",
+		"event_action": "resolve",
+		"dedup_key":    "Node//node-1/NodeNotReady",
+	}, (*events)[1])
+	assert.Equal(t, "Node//node-1/NodeNotSchedulable", (*events)[2]["dedup_key"])
+}
+
+func TestPagerDutyResolveCustomKeys(t *testing.T) {
+	events, server := newPagerDutyServer(t, nil)
+	defer server.Close()
+
+	// The keys depend on fields that differ in the recovery event, the alerts are resolved with the keys triggered
+	sink, err := NewPagerDutySink(&PagerDutyConfig{
+		URL:        server.URL,
+		RoutingKey: "{{ .Type }}-key",
+		DedupKey:   "{{ .InvolvedObject.Name }}/{{ .Message }}",
+		Resolve:    Recoveries{"NodeReady": {"NodeNotReady"}},
+	})
+	require.NoError(t, err)
+
+	notReady := newNodeEvent("NodeNotReady", "Warning")
+	require.NoError(t, sink.Send(context.Background(), notReady))
+	notReady.Message = "Node node-1 is not ready"
+	require.NoError(t, sink.Send(context.Background(), notReady))
+	require.NoError(t, sink.Send(context.Background(), newNodeEvent("NodeReady", "Normal")))
+
+	require.Len(t, *events, 4)
+	assert.Equal(t, map[string]any{
+		"routing_key":  "Warning-key",
+		"event_action": "resolve",
+		"dedup_key":    "node-1/Node node-1 status is now: NodeNotReady",
+	}, (*events)[2])
+	assert.Equal(t, "node-1/Node node-1 is not ready", (*events)[3]["dedup_key"])
+	assert.Equal(t, "Warning-key", (*events)[3]["routing_key"])
+
+	// The resolved alerts are forgotten, the keys are rendered from the recovery event without alerts triggered
+	require.NoError(t, sink.Send(context.Background(), newNodeEvent("NodeReady", "Normal")))
+	require.Len(t, *events, 5)
+	assert.Equal(t, "Normal-key", (*events)[4]["routing_key"])
+	assert.Equal(t, "node-1/Node node-1 status is now: NodeReady", (*events)[4]["dedup_key"])
+}
+
+func TestPagerDutyResolveOnce(t *testing.T) {
+	events, server := newPagerDutyServer(t, nil)
+	defer server.Close()
+
+	// Without the reason in the dedup key, the object has a single alert to resolve
+	sink, err := NewPagerDutySink(&PagerDutyConfig{
+		URL:        server.URL,
+		RoutingKey: "key",
+		DedupKey:   "{{ .InvolvedObject.Name }}",
+		Resolve:    Recoveries{"NodeReady": {"NodeNotReady", "NodeNotSchedulable"}},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Send(context.Background(), newNodeEvent("NodeReady", "Normal")))
+	require.Len(t, *events, 1)
+	assert.Equal(t, "node-1", (*events)[0]["dedup_key"])
+}
+
+func TestPagerDutyRetries(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	sink, err := NewPagerDutySink(&PagerDutyConfig{URL: server.URL, RoutingKey: "key"})
+	require.NoError(t, err)
+
+	// The rate limited trigger is sent again after the Retry-After delay
+	require.NoError(t, sink.Send(context.Background(), newNodeEvent("NodeNotReady", "Warning")))
+	assert.Equal(t, 2, requests)
+}
+
+func TestPagerDutyErrors(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	status := http.StatusBadRequest
+	_, server := newPagerDutyServer(t, &status)
+	defer server.Close()
+
+	sink, err := NewPagerDutySink(&PagerDutyConfig{URL: server.URL, RoutingKey: "key"})
+	require.NoError(t, err)
+
+	err = sink.Send(context.Background(), newNodeEvent("NodeNotReady", "Warning"))
+	assert.True(t, IsPermanent(err))
+
+	// The failed requests are retried up to MaxRetries times
+	events, server := newPagerDutyServer(t, &status)
+	defer server.Close()
+	sink, err = NewPagerDutySink(&PagerDutyConfig{URL: server.URL, RoutingKey: "key", MaxRetries: 2})
+	require.NoError(t, err)
+
+	status = http.StatusServiceUnavailable
+	err = sink.Send(context.Background(), newNodeEvent("NodeNotReady", "Warning"))
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+	assert.Len(t, *events, 3)
+}
+
+func TestPagerDutyConfigErrors(t *testing.T) {
+	for name, cfg := range map[string]*PagerDutyConfig{
+		"routingKey cannot be empty": {},
+		"invalid pagerduty dedupKey": {RoutingKey: "key", DedupKey: "{{ .Reason"},
+		"invalid pagerduty severity": {RoutingKey: "key", ReasonSeverities: map[string]string{"OOMKilling": "high"}},
+		"does not recover from any":  {RoutingKey: "key", Resolve: Recoveries{"NodeReady": nil}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewPagerDutySink(cfg)
+			assert.ErrorContains(t, err, name)
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package sinks
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/metrics"
+)
 
 // Receiver allows receiving
 type ReceiverConfig struct {
@@ -26,13 +30,41 @@ type ReceiverConfig struct {
 	BigQuery      *BigQueryConfig      `yaml:"bigquery"`
 	EventBridge   *EventBridgeConfig   `yaml:"eventbridge"`
 	Pipe          *PipeConfig          `yaml:"pipe"`
+	PagerDuty     *PagerDutyConfig     `yaml:"pagerduty"`
+	Alertmanager  *AlertmanagerConfig  `yaml:"alertmanager"`
+	Discord       *DiscordConfig       `yaml:"discord"`
+	Mattermost    *MattermostConfig    `yaml:"mattermost"`
+	GoogleChat    *GoogleChatConfig    `yaml:"googlechat"`
+	RocketChat    *RocketChatConfig    `yaml:"rocketchat"`
+	OTLP          *OTLPConfig          `yaml:"otlp"`
+	FluentForward *FluentForwardConfig `yaml:"fluentforward"`
+	GELF          *GELFConfig          `yaml:"gelf"`
+	// Group sends periodic digests of the events to the sink instead of every event
+	Group *GroupConfig `yaml:"group"`
+	// Transforms are applied to the events sent to this receiver, after the global ones
+	Transforms Transforms `yaml:"transforms"`
 }
 
 func (r *ReceiverConfig) Validate() error {
 	return nil
 }
 
-func (r *ReceiverConfig) GetSink() (Sink, error) {
+// GetSink creates the sink of the receiver, the sinks reporting their own metrics use the metrics store
+func (r *ReceiverConfig) GetSink(metricsStore *metrics.Store) (Sink, error) {
+	sink, err := r.getSink(metricsStore)
+	if err != nil || r.Group == nil {
+		return sink, err
+	}
+
+	group, err := NewGroupSink(r.Group, sink)
+	if err != nil {
+		sink.Close()
+		return nil, err
+	}
+	return group, nil
+}
+
+func (r *ReceiverConfig) getSink(metricsStore *metrics.Store) (Sink, error) {
 	if r.InMemory != nil {
 		// This reference is used for test purposes to count the events in the sink.
 		// It should not be used in production since it will only cause memory leak and (b)OOM
@@ -95,7 +127,7 @@ func (r *ReceiverConfig) GetSink() (Sink, error) {
 	}
 
 	if r.Kafka != nil {
-		return NewKafkaSink(r.Kafka)
+		return NewKafkaSink(r.Kafka, metricsStore)
 	}
 
 	if r.Pubsub != nil {
@@ -122,5 +154,41 @@ func (r *ReceiverConfig) GetSink() (Sink, error) {
 		return NewLoki(r.Loki)
 	}
 
+	if r.PagerDuty != nil {
+		return NewPagerDutySink(r.PagerDuty)
+	}
+
+	if r.Alertmanager != nil {
+		return NewAlertmanagerSink(r.Alertmanager)
+	}
+
+	if r.Discord != nil {
+		return NewDiscordSink(r.Discord)
+	}
+
+	if r.Mattermost != nil {
+		return NewMattermostSink(r.Mattermost)
+	}
+
+	if r.GoogleChat != nil {
+		return NewGoogleChatSink(r.GoogleChat)
+	}
+
+	if r.RocketChat != nil {
+		return NewRocketChatSink(r.RocketChat)
+	}
+
+	if r.OTLP != nil {
+		return NewOTLPSink(r.OTLP)
+	}
+
+	if r.FluentForward != nil {
+		return NewFluentForwardSink(r.FluentForward)
+	}
+
+	if r.GELF != nil {
+		return NewGELFSink(r.GELF)
+	}
+
 	return nil, errors.New("unknown sink")
 }
@@ -0,0 +1,103 @@
+package sinks
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+)
+
+// DefaultRaisedCacheSize is the number of objects and reasons whose alerts are remembered to resolve them
+const DefaultRaisedCacheSize = 4096
+
+// Recoveries maps the reasons of the recovery events to the reasons of the events they recover from, such as
+// NodeReady to NodeNotReady. The alerting sinks resolve the alerts raised for the same object when a recovery arrives.
+type Recoveries map[string][]string
+
+func (r Recoveries) Validate() error {
+	for reason, recovered := range r {
+		if len(recovered) == 0 {
+			return errors.New("recovery reason " + reason + " does not recover from any reason")
+		}
+	}
+	return nil
+}
+
+// recovered returns copies of a recovery event with the reasons it recovers from, so that the keys of the alerts
+// raised for them can be rendered from the same templates. It returns nil for the other events.
+func (r Recoveries) recovered(ev *kube.EnhancedEvent) []*kube.EnhancedEvent {
+	reasons := r[ev.Reason]
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	evs := make([]*kube.EnhancedEvent, 0, len(reasons))
+	for _, reason := range reasons {
+		c := *ev
+		c.Reason = reason
+		evs = append(evs, &c)
+	}
+	return evs
+}
+
+// raisedAlerts remembers the keys of the alerts raised per object and reason, so that a recovery resolves them with the
+// keys they were raised with, even when the keys are rendered from fields that differ in the recovery event such as the
+// message. The keys are remembered in memory, the alerts raised before a restart are not found.
+type raisedAlerts[K comparable] struct {
+	mu    sync.Mutex
+	cache *lru.Cache
+}
+
+func newRaisedAlerts[K comparable](size int) *raisedAlerts[K] {
+	if size <= 0 {
+		size = DefaultRaisedCacheSize
+	}
+	// lru.New only fails for non-positive sizes
+	cache, _ := lru.New(size)
+	return &raisedAlerts[K]{cache: cache}
+}
+
+func raisedObject(ev *kube.EnhancedEvent) string {
+	o := ev.InvolvedObject
+	return strings.Join([]string{ev.ClusterName, o.Kind, o.Namespace, o.Name, ev.Reason}, "/")
+}
+
+// add remembers the key of an alert raised for the event
+func (r *raisedAlerts[K]) add(ev *kube.EnhancedEvent, key K) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	object := raisedObject(ev)
+	keys, _ := r.cache.Get(object)
+	if ks, _ := keys.([]K); !slices.Contains(ks, key) {
+		r.cache.Add(object, append(ks, key))
+	}
+}
+
+// get returns the keys of the alerts raised for the object and reason of a recovered event
+func (r *raisedAlerts[K]) get(ev *kube.EnhancedEvent) []K {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys, _ := r.cache.Get(raisedObject(ev))
+	ks, _ := keys.([]K)
+	return slices.Clone(ks)
+}
+
+// remove forgets the key of an alert once resolved
+func (r *raisedAlerts[K]) remove(ev *kube.EnhancedEvent, key K) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	object := raisedObject(ev)
+	keys, ok := r.cache.Get(object)
+	if !ok {
+		return
+	}
+	ks := slices.DeleteFunc(slices.Clone(keys.([]K)), func(k K) bool { return k == key })
+	if len(ks) == 0 {
+		r.cache.Remove(object)
+		return
+	}
+	r.cache.Add(object, ks)
+}
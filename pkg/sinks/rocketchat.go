@@ -0,0 +1,64 @@
+package sinks
+
+import (
+	"context"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+)
+
+// rocketChatMaxTextLength is the default Message_MaxAllowedSize of Rocket.Chat
+const rocketChatMaxTextLength = 5000
+
+var rocketChatPlatform = chatPlatform{
+	name:      "rocketchat",
+	rateLimit: 5,
+	burst:     5,
+	textKey:   "text",
+	maxText:   rocketChatMaxTextLength,
+	layoutKey: "attachments",
+	limits: map[string]int{
+		"text":  rocketChatMaxTextLength,
+		"title": 1000,
+		"value": 1000,
+	},
+}
+
+type RocketChatConfig struct {
+	ChatConfig `yaml:",inline"`
+	// Channel, Alias, Emoji and Avatar are templates overriding the ones of the integration
+	Channel string `yaml:"channel"`
+	Alias   string `yaml:"alias"`
+	Emoji   string `yaml:"emoji"`
+	Avatar  string `yaml:"avatar"`
+	// Attachments are the message attachments, with templates in their strings
+	Attachments []any `yaml:"attachments"`
+}
+
+type RocketChatSink struct {
+	cfg *RocketChatConfig
+	*chatClient
+}
+
+func NewRocketChatSink(cfg *RocketChatConfig) (Sink, error) {
+	client, err := newChatClient(rocketChatPlatform, &cfg.ChatConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.validate(cfg.overrides(), cfg.Attachments); err != nil {
+		return nil, err
+	}
+	return &RocketChatSink{cfg: cfg, chatClient: client}, nil
+}
+
+// overrides are the templates overriding the settings of the integration, by their keys in the payloads
+func (cfg *RocketChatConfig) overrides() map[string]string {
+	return map[string]string{"channel": cfg.Channel, "alias": cfg.Alias, "emoji": cfg.Emoji, "avatar": cfg.Avatar}
+}
+
+func (r *RocketChatSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	payload, err := r.render(ev, r.cfg.overrides(), r.cfg.Attachments)
+	if err != nil {
+		return err
+	}
+	return r.post(ctx, r.cfg.URL, payload)
+}
@@ -0,0 +1,360 @@
+package sinks
+
+// This provides the wire format of the Confluent Schema Registry for the kafka sink.
+// The values are framed with a zero magic byte followed by the 4-byte big-endian ID
+// of the schema in the registry, the Protobuf values also carry the indexes of the
+// message type in the schema:
+// https://docs.confluent.io/platform/current/schema-registry/fundamentals/serdes-develop/index.html#wire-format
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	goavro "github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const schemaRegistryContentType = "application/vnd.schemaregistry.v1+json"
+
+// SchemaRegistryConfig encodes the values with a schema registered in a Confluent Schema Registry
+type SchemaRegistryConfig struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	TLS      TLS    `yaml:"tls"`
+	// Format is the format of the schema, one of avro, json or protobuf
+	Format string `yaml:"format"`
+	// Schema is the Avro schema, the JSON Schema or the .proto file the values are encoded with
+	Schema string `yaml:"schema"`
+	// DescriptorSetFile is the FileDescriptorSet compiled from the .proto file, such as with protoc --descriptor_set_out,
+	// which the protobuf values are encoded with
+	DescriptorSetFile string `yaml:"descriptorSetFile"`
+	// MessageType is the full name of the protobuf message the values are encoded as
+	MessageType string `yaml:"messageType"`
+	// SubjectNameStrategy is one of topicName, recordName or topicRecordName, topicName by default
+	SubjectNameStrategy string `yaml:"subjectNameStrategy"`
+	// LookupOnly looks the schema up instead of registering it, for the registries the producers cannot write to
+	LookupOnly bool `yaml:"lookupOnly"`
+}
+
+// schemaSerializer serializes the JSON values of the events in a format of the registry
+type schemaSerializer interface {
+	// schemaType is the type of the schema in the registry API, empty for Avro which is left out of the requests for
+	// the registries that resolve the schema provider from it
+	schemaType() string
+	// recordName is the fully qualified name of the record, used by the record name strategies
+	recordName() string
+	// serialize returns the payload following the schema ID
+	serialize(value []byte) ([]byte, error)
+}
+
+// schemaRegistration is the body of the registration and of the lookup of a schema
+type schemaRegistration struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"`
+}
+
+// SchemaRegistryEncoder frames the values with the ID of their schema in the registry. The IDs are cached by subject.
+type SchemaRegistryEncoder struct {
+	cfg        *SchemaRegistryConfig
+	client     *http.Client
+	serializer schemaSerializer
+
+	mu  sync.Mutex
+	ids map[string]uint32
+}
+
+func NewSchemaRegistryEncoder(cfg *SchemaRegistryConfig) (*SchemaRegistryEncoder, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("schema registry url cannot be empty")
+	}
+
+	// The protobuf values are encoded with the descriptor set, the schema is what gets registered for every format
+	if strings.TrimSpace(cfg.Schema) == "" {
+		return nil, errors.New("schema registry schema cannot be empty")
+	}
+
+	var serializer schemaSerializer
+	var err error
+	switch cfg.Format {
+	case "avro", "":
+		serializer, err = newAvroSerializer(cfg.Schema)
+	case "json":
+		serializer, err = newJSONSchemaSerializer(cfg.Schema)
+	case "protobuf":
+		serializer, err = newProtobufSerializer(cfg.DescriptorSetFile, cfg.MessageType)
+	default:
+		return nil, fmt.Errorf("invalid schema registry format: %s: can be one of 'avro', 'json' or 'protobuf'", cfg.Format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s schema: %w", cfg.Format, err)
+	}
+
+	switch cfg.SubjectNameStrategy {
+	case "", "topicName":
+	case "recordName", "topicRecordName":
+		if serializer.recordName() == "" {
+			return nil, fmt.Errorf("the %s strategy requires a schema with a record name", cfg.SubjectNameStrategy)
+		}
+	default:
+		return nil, fmt.Errorf("invalid subject name strategy: %s: can be one of 'topicName', 'recordName' or 'topicRecordName'", cfg.SubjectNameStrategy)
+	}
+
+	tlsClientConfig, err := setupTLS(&cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup TLS: %w", err)
+	}
+
+	return &SchemaRegistryEncoder{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsClientConfig},
+		},
+		serializer: serializer,
+		ids:        make(map[string]uint32),
+	}, nil
+}
+
+func (e *SchemaRegistryEncoder) encode(ctx context.Context, topic string, value []byte) ([]byte, error) {
+	id, err := e.schemaID(ctx, e.subject(topic))
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := e.serializer.serialize(value)
+	if err != nil {
+		return nil, Permanent(err)
+	}
+
+	buf := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(buf[1:], id)
+	return append(buf, payload...), nil
+}
+
+func (e *SchemaRegistryEncoder) subject(topic string) string {
+	switch e.cfg.SubjectNameStrategy {
+	case "recordName":
+		return e.serializer.recordName()
+	case "topicRecordName":
+		return topic + "-" + e.serializer.recordName()
+	}
+	return topic + "-value"
+}
+
+// schemaID returns the ID of the schema under the subject, registering it unless it is configured to only look it up
+func (e *SchemaRegistryEncoder) schemaID(ctx context.Context, subject string) (uint32, error) {
+	e.mu.Lock()
+	id, ok := e.ids[subject]
+	e.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	path := "/subjects/" + url.PathEscape(subject)
+	if !e.cfg.LookupOnly {
+		path += "/versions"
+	}
+	body, err := json.Marshal(schemaRegistration{Schema: e.cfg.Schema, SchemaType: e.serializer.schemaType()})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(e.cfg.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", schemaRegistryContentType)
+	req.Header.Set("Accept", schemaRegistryContentType)
+	if e.cfg.Username != "" {
+		req.SetBasicAuth(e.cfg.Username, e.cfg.Password)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		rb, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("schema registry returned %d for subject %s: %s", resp.StatusCode, subject, string(rb))
+		if !retryableStatus(resp.StatusCode) {
+			err = Permanent(err)
+		}
+		return 0, err
+	}
+
+	var result struct {
+		ID uint32 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("cannot decode the schema registry response: %w", err)
+	}
+
+	e.mu.Lock()
+	e.ids[subject] = result.ID
+	e.mu.Unlock()
+	return result.ID, nil
+}
+
+type avroSerializer struct {
+	codec *goavro.Codec
+	name  string
+}
+
+func newAvroSerializer(schema string) (*avroSerializer, error) {
+	codec, err := goavro.NewCodecForStandardJSON(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var record struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	}
+	// Schemas of the primitive types are not objects and have no name
+	_ = json.Unmarshal([]byte(schema), &record)
+	name := record.Name
+	if record.Namespace != "" && !strings.Contains(name, ".") {
+		name = record.Namespace + "." + name
+	}
+	return &avroSerializer{codec: codec, name: name}, nil
+}
+
+func (a *avroSerializer) schemaType() string {
+	return ""
+}
+
+func (a *avroSerializer) recordName() string {
+	return a.name
+}
+
+func (a *avroSerializer) serialize(value []byte) ([]byte, error) {
+	native, _, err := a.codec.NativeFromTextual(value)
+	if err != nil {
+		return nil, err
+	}
+	return a.codec.BinaryFromNative(nil, native)
+}
+
+// jsonSchemaSerializer sends the JSON values as they are, they are not validated against the schema
+type jsonSchemaSerializer struct {
+	title string
+}
+
+func newJSONSchemaSerializer(schema string) (*jsonSchemaSerializer, error) {
+	var s map[string]any
+	if err := json.Unmarshal([]byte(schema), &s); err != nil {
+		return nil, err
+	}
+	title, _ := s["title"].(string)
+	return &jsonSchemaSerializer{title: title}, nil
+}
+
+func (j *jsonSchemaSerializer) schemaType() string {
+	return "JSON"
+}
+
+func (j *jsonSchemaSerializer) recordName() string {
+	return j.title
+}
+
+func (j *jsonSchemaSerializer) serialize(value []byte) ([]byte, error) {
+	return value, nil
+}
+
+// protobufSerializer converts the JSON values into the message type with the protobuf JSON mapping, the fields not
+// in the message are dropped
+type protobufSerializer struct {
+	message protoreflect.MessageDescriptor
+	// indexes is the encoded path of the message type in the schema, which precedes the message
+	indexes []byte
+}
+
+func newProtobufSerializer(descriptorSetFile, messageType string) (*protobufSerializer, error) {
+	if descriptorSetFile == "" || messageType == "" {
+		return nil, errors.New("protobuf requires a descriptorSetFile and a messageType")
+	}
+	b, err := os.ReadFile(descriptorSetFile)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, set); err != nil {
+		return nil, fmt.Errorf("cannot decode the descriptor set: %w", err)
+	}
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, err
+	}
+	d, err := files.FindDescriptorByName(protoreflect.FullName(messageType))
+	if err != nil {
+		return nil, err
+	}
+	message, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", messageType)
+	}
+	return &protobufSerializer{message: message, indexes: protobufIndexes(message)}, nil
+}
+
+// protobufIndexes encodes the indexes of the message and its parents in the file as zigzag varints, preceded by their
+// count. The first message of the file, the most common case, is a single zero.
+func protobufIndexes(message protoreflect.MessageDescriptor) []byte {
+	var path []int
+	var d protoreflect.Descriptor = message
+	for {
+		if _, ok := d.(protoreflect.MessageDescriptor); !ok {
+			break
+		}
+		path = append([]int{d.Index()}, path...)
+		d = d.Parent()
+	}
+	if len(path) == 1 && path[0] == 0 {
+		return []byte{0}
+	}
+
+	b := protowire.AppendVarint(nil, protowire.EncodeZigZag(int64(len(path))))
+	for _, i := range path {
+		b = protowire.AppendVarint(b, protowire.EncodeZigZag(int64(i)))
+	}
+	return b
+}
+
+func (p *protobufSerializer) schemaType() string {
+	return "PROTOBUF"
+}
+
+func (p *protobufSerializer) recordName() string {
+	return string(p.message.FullName())
+}
+
+func (p *protobufSerializer) serialize(value []byte) ([]byte, error) {
+	msg := dynamicpb.NewMessage(p.message)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(value, msg); err != nil {
+		return nil, err
+	}
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, p.indexes...), b...), nil
+}
@@ -0,0 +1,322 @@
+package sinks
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	goavro "github.com/linkedin/goavro/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const testAvroSchema = `{
+	"type": "record",
+	"name": "Event",
+	"namespace": "io.k8s",
+	"fields": [
+		{"name": "reason", "type": "string"},
+		{"name": "count", "type": "int"}
+	]
+}`
+
+// registryStandIn implements the registration and the lookup of the schemas by subject
+type registryStandIn struct {
+	mu       sync.Mutex
+	subjects map[string]uint32
+	types    map[string]string
+	requests int
+	status   int
+}
+
+func newRegistryStandIn(t *testing.T) (*registryStandIn, *httptest.Server) {
+	r := &registryStandIn{subjects: make(map[string]uint32), types: make(map[string]string)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests++
+
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.Equal(t, schemaRegistryContentType, req.Header.Get("Content-Type"))
+		if r.status != 0 {
+			w.WriteHeader(r.status)
+			return
+		}
+
+		var body struct {
+			Schema     string  `json:"schema"`
+			SchemaType *string `json:"schemaType"`
+		}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+		assert.NotEmpty(t, body.Schema)
+
+		path := strings.TrimPrefix(req.URL.Path, "/subjects/")
+		subject, register := strings.CutSuffix(path, "/versions")
+		id, ok := r.subjects[subject]
+		if !ok && !register {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":40401,"message":"Subject not found"}`))
+			return
+		}
+		if !ok {
+			id = uint32(len(r.subjects) + 1)
+			r.subjects[subject] = id
+			if body.SchemaType != nil {
+				r.types[subject] = *body.SchemaType
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"id": id})
+	}))
+	return r, server
+}
+
+func (r *registryStandIn) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.requests
+}
+
+func splitFrame(t *testing.T, b []byte) (uint32, []byte) {
+	require.Greater(t, len(b), 5)
+	assert.Equal(t, byte(0), b[0], "magic byte")
+	return binary.BigEndian.Uint32(b[1:5]), b[5:]
+}
+
+func TestSchemaRegistryAvro(t *testing.T) {
+	registry, server := newRegistryStandIn(t)
+	defer server.Close()
+
+	encoder, err := NewSchemaRegistryEncoder(&SchemaRegistryConfig{URL: server.URL, Format: "avro", Schema: testAvroSchema})
+	require.NoError(t, err)
+
+	for range 3 {
+		b, err := encoder.encode(context.Background(), "events", []byte(`{"reason":"BackOff","count":3}`))
+		require.NoError(t, err)
+
+		id, payload := splitFrame(t, b)
+		assert.Equal(t, registry.subjects["events-value"], id)
+		codec, err := goavro.NewCodec(testAvroSchema)
+		require.NoError(t, err)
+		native, _, err := codec.NativeFromBinary(payload)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"reason": "BackOff", "count": int32(3)}, native)
+	}
+	assert.Equal(t, 1, registry.count(), "the schema ID is cached")
+	assert.NotContains(t, registry.types, "events-value", "the avro schemas are registered without a schemaType")
+
+	_, err = encoder.encode(context.Background(), "other", []byte(`{"reason":"BackOff","count":3}`))
+	require.NoError(t, err)
+	assert.Equal(t, 2, registry.count(), "each topic has its own subject")
+
+	_, err = encoder.encode(context.Background(), "events", []byte(`{"reason":"BackOff"}`))
+	assert.True(t, IsPermanent(err), "the values not matching the schema are not retried")
+}
+
+func TestSchemaRegistrySubjectNameStrategies(t *testing.T) {
+	for strategy, subject := range map[string]string{
+		"":                "events-value",
+		"topicName":       "events-value",
+		"recordName":      "io.k8s.Event",
+		"topicRecordName": "events-io.k8s.Event",
+	} {
+		t.Run(strategy, func(t *testing.T) {
+			registry, server := newRegistryStandIn(t)
+			defer server.Close()
+
+			encoder, err := NewSchemaRegistryEncoder(&SchemaRegistryConfig{
+				URL:                 server.URL,
+				Schema:              testAvroSchema,
+				SubjectNameStrategy: strategy,
+			})
+			require.NoError(t, err)
+
+			_, err = encoder.encode(context.Background(), "events", []byte(`{"reason":"BackOff","count":1}`))
+			require.NoError(t, err)
+			assert.Contains(t, registry.subjects, subject)
+		})
+	}
+}
+
+func TestSchemaRegistryJSONSchema(t *testing.T) {
+	registry, server := newRegistryStandIn(t)
+	defer server.Close()
+
+	encoder, err := NewSchemaRegistryEncoder(&SchemaRegistryConfig{
+		URL:                 server.URL,
+		Format:              "json",
+		Schema:              `{"title": "KubernetesEvent", "type": "object"}`,
+		SubjectNameStrategy: "recordName",
+	})
+	require.NoError(t, err)
+
+	b, err := encoder.encode(context.Background(), "events", []byte(`{"reason":"BackOff"}`))
+	require.NoError(t, err)
+	id, payload := splitFrame(t, b)
+	assert.Equal(t, registry.subjects["KubernetesEvent"], id)
+	assert.Equal(t, `{"reason":"BackOff"}`, string(payload))
+	assert.Equal(t, "JSON", registry.types["KubernetesEvent"])
+}
+
+// writeDescriptorSet writes a descriptor set of the k8s package, where Event is the second message of the file
+func writeDescriptorSet(t *testing.T) string {
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+		}
+	}
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("event.proto"),
+		Package: proto.String("k8s"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Header"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("cluster", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			}},
+			{Name: proto.String("Event"), Field: []*descriptorpb.FieldDescriptorProto{
+				field("reason", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("count", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+			}},
+		},
+	}}}
+	b, err := proto.Marshal(set)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "event.desc")
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+	return path
+}
+
+func TestSchemaRegistryProtobuf(t *testing.T) {
+	registry, server := newRegistryStandIn(t)
+	defer server.Close()
+
+	encoder, err := NewSchemaRegistryEncoder(&SchemaRegistryConfig{
+		URL:               server.URL,
+		Format:            "protobuf",
+		Schema:            `syntax = "proto3"; package k8s; message Header { string cluster = 1; } message Event { string reason = 1; int32 count = 2; }`,
+		DescriptorSetFile: writeDescriptorSet(t),
+		MessageType:       "k8s.Event",
+	})
+	require.NoError(t, err)
+
+	b, err := encoder.encode(context.Background(), "events", []byte(`{"reason":"BackOff","count":3,"message":"dropped"}`))
+	require.NoError(t, err)
+	id, payload := splitFrame(t, b)
+	assert.Equal(t, registry.subjects["events-value"], id)
+	assert.Equal(t, "PROTOBUF", registry.types["events-value"])
+
+	// One index, the second message of the file, as zigzag varints
+	assert.Equal(t, []byte{2, 2}, payload[:2])
+	serializer := encoder.serializer.(*protobufSerializer)
+	msg := dynamicpb.NewMessage(serializer.message)
+	require.NoError(t, proto.Unmarshal(payload[2:], msg))
+	assert.Equal(t, "BackOff", msg.Get(serializer.message.Fields().ByName("reason")).String())
+	assert.Equal(t, int64(3), msg.Get(serializer.message.Fields().ByName("count")).Int())
+}
+
+func TestProtobufIndexesFirstMessage(t *testing.T) {
+	serializer, err := newProtobufSerializer(writeDescriptorSet(t), "k8s.Header")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0}, serializer.indexes)
+	assert.Equal(t, protoreflect.FullName("k8s.Header"), serializer.message.FullName())
+}
+
+func TestSchemaRegistryLookupOnly(t *testing.T) {
+	registry, server := newRegistryStandIn(t)
+	defer server.Close()
+
+	encoder, err := NewSchemaRegistryEncoder(&SchemaRegistryConfig{URL: server.URL, Schema: testAvroSchema, LookupOnly: true})
+	require.NoError(t, err)
+
+	_, err = encoder.encode(context.Background(), "events", []byte(`{"reason":"BackOff","count":1}`))
+	assert.True(t, IsPermanent(err), "a missing subject is not retried")
+	assert.Empty(t, registry.subjects)
+
+	registry.subjects["events-value"] = 42
+	b, err := encoder.encode(context.Background(), "events", []byte(`{"reason":"BackOff","count":1}`))
+	require.NoError(t, err)
+	id, _ := splitFrame(t, b)
+	assert.Equal(t, uint32(42), id)
+}
+
+func TestSchemaRegistryUnavailable(t *testing.T) {
+	registry, server := newRegistryStandIn(t)
+	defer server.Close()
+
+	encoder, err := NewSchemaRegistryEncoder(&SchemaRegistryConfig{URL: server.URL, Schema: testAvroSchema})
+	require.NoError(t, err)
+
+	registry.status = http.StatusServiceUnavailable
+	_, err = encoder.encode(context.Background(), "events", []byte(`{"reason":"BackOff","count":1}`))
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+
+	registry.status = 0
+	_, err = encoder.encode(context.Background(), "events", []byte(`{"reason":"BackOff","count":1}`))
+	assert.NoError(t, err, "the failures are not cached")
+}
+
+func TestSchemaRegistryConfigErrors(t *testing.T) {
+	for name, cfg := range map[string]*SchemaRegistryConfig{
+		"url cannot be empty":          {Schema: testAvroSchema},
+		"invalid schema registry":      {URL: "http://registry", Format: "thrift", Schema: testAvroSchema},
+		"invalid avro schema":          {URL: "http://registry", Format: "avro", Schema: `{"type": "record"}`},
+		"invalid json schema":          {URL: "http://registry", Format: "json", Schema: `{`},
+		"requires a descriptorSetFile": {URL: "http://registry", Format: "protobuf", Schema: "syntax = \"proto3\";", MessageType: "k8s.Event"},
+		"schema cannot be empty": {
+			URL: "http://registry", Format: "protobuf", DescriptorSetFile: writeDescriptorSet(t), MessageType: "k8s.Event",
+		},
+		"requires a schema with a record name": {
+			URL: "http://registry", Format: "json", Schema: `{"type": "object"}`, SubjectNameStrategy: "recordName",
+		},
+		"invalid subject name strategy": {URL: "http://registry", Schema: testAvroSchema, SubjectNameStrategy: "topic"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewSchemaRegistryEncoder(cfg)
+			assert.ErrorContains(t, err, name)
+		})
+	}
+
+	for _, format := range []string{"avro", "json"} {
+		_, err := NewSchemaRegistryEncoder(&SchemaRegistryConfig{URL: "http://registry", Format: format, Schema: " "})
+		assert.ErrorContains(t, err, "schema cannot be empty", format)
+	}
+}
+
+func TestKafkaSchemaRegistryLayout(t *testing.T) {
+	registry, server := newRegistryStandIn(t)
+	defer server.Close()
+
+	cfg := &KafkaConfig{
+		Topic:          "events",
+		Key:            DefaultKafkaKey,
+		Layout:         map[string]any{"reason": "{{ .Reason }}", "count": "{{ .Count }}"},
+		SchemaRegistry: &SchemaRegistryConfig{URL: server.URL, Format: "json", Schema: `{"type": "object"}`},
+	}
+	encoder, err := NewSchemaRegistryEncoder(cfg.SchemaRegistry)
+	require.NoError(t, err)
+	k := &KafkaSink{cfg: cfg, encoder: encoder}
+
+	msg, err := k.message(context.Background(), newKafkaEvent())
+	require.NoError(t, err)
+	value, err := msg.Value.Encode()
+	require.NoError(t, err)
+	id, payload := splitFrame(t, value)
+	assert.Equal(t, registry.subjects["events-value"], id)
+	assert.JSONEq(t, `{"reason":"BackOff","count":"0"}`, string(payload))
+}
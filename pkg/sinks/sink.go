@@ -6,8 +6,15 @@ import (
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 )
 
@@ -19,11 +26,83 @@ type Sink interface {
 	Close()
 }
 
-// BatchSink is an extension Sink that can handle batch events.
-// NOTE: Currently no provider implements it nor the receivers can handle it.
+// BatchSink is an extension Sink that can handle batch events. The registries buffer the events of a BatchSink with a
+// batch.Writer configured by BatchConfig and send them with SendBatch, Send is still used where batching does not apply.
 type BatchSink interface {
 	Sink
-	SendBatch([]*kube.EnhancedEvent) error
+	// SendBatch returns an error per event, nil for the ones sent. The events with an error are retried unless the
+	// error is Permanent.
+	SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error
+	BatchConfig() batch.WriterConfig
+}
+
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanentError) Unwrap() error {
+	return p.err
+}
+
+// Permanent marks an error which is not worth retrying, such as an event rejected by the destination
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent tells whether the error is marked as Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// retryableStatus tells whether a request failed with an HTTP status code may succeed when retried
+func retryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date, it returns the fallback when the header
+// is missing or invalid
+func retryAfter(header string, fallback time.Duration) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return fallback
+}
+
+// retryBackoff is the wait before the first retry of a failed request, it doubles for the next ones. Tests shorten it.
+var retryBackoff = 500 * time.Millisecond
+
+// maxRetryWait bounds the waits before the retries, so that a sink is not blocked for long
+const maxRetryWait = time.Minute
+
+// withRetries calls send until it succeeds, fails permanently or maxRetries retries are done. send returns how long to
+// wait before retrying, such as the Retry-After delay of a rate limited request, or a negative duration to back off.
+func withRetries(ctx context.Context, sink string, maxRetries int, send func() (time.Duration, error)) error {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		wait, err := send()
+		if err == nil || IsPermanent(err) || attempt >= maxRetries {
+			return err
+		}
+		if wait < 0 {
+			wait = backoff
+			backoff *= 2
+		}
+
+		slog.With("sink", sink, "retryAfter", wait, "err", err).Warn("Retrying the request")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(min(wait, maxRetryWait)):
+		}
+	}
 }
 
 type TLS struct {
@@ -65,3 +144,25 @@ func setupTLS(cfg *TLS) (*tls.Config, error) {
 	}
 	return tlsClientConfig, nil
 }
+
+// formatLabels formats the labels in the Prometheus format, sorted by name, such as {kind="Pod", reason="BackOff"}
+func formatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
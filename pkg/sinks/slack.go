@@ -2,15 +2,31 @@ package sinks
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"sort"
+	"sync"
+	"time"
 
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 	"github.com/slack-go/slack"
 )
 
+const (
+	DefaultSlackThreadKey       = "{{ .InvolvedObject.Kind }}/{{ .InvolvedObject.Namespace }}/{{ .InvolvedObject.Name }}"
+	DefaultSlackThreadTTL       = 24 * time.Hour
+	DefaultSlackThreadCacheSize = 4096
+	DefaultSlackMaxRetries      = 3
+)
+
 type SlackConfig struct {
-	Token      string            `yaml:"token"`
+	Token string `yaml:"token"`
+	// WebhookURL is an incoming webhook to post to instead of using a token, the channel is the one of the webhook
+	WebhookURL string            `yaml:"webhookURL"`
 	Channel    string            `yaml:"channel"`
 	Message    string            `yaml:"message"`
 	Color      string            `yaml:"color"`
@@ -18,38 +34,163 @@ type SlackConfig struct {
 	Title      string            `yaml:"title"`
 	AuthorName string            `yaml:"author_name"`
 	Fields     map[string]string `yaml:"fields"`
+	// Blocks are Block Kit blocks with templates in their strings, the message is the notification text then
+	Blocks []any `yaml:"blocks"`
+	// Thread posts the later events of the same object in the thread of the first message, it requires a token
+	Thread *SlackThreadConfig `yaml:"thread"`
+	// MaxRetries is the number of times a rate limited message is retried after the Retry-After delay, and a failed one
+	// with a backoff, 3 by default
+	MaxRetries int `yaml:"maxRetries"`
+}
+
+type SlackThreadConfig struct {
+	// Key is a template identifying the thread of an event within a channel, the involved object by default
+	Key string `yaml:"key"`
+	// TTL is how long the replies go to a thread after its first message, 24h by default
+	TTL time.Duration `yaml:"ttl"`
+	// CacheSize is the number of threads remembered, the least recently used ones are forgotten first
+	CacheSize int `yaml:"cacheSize"`
+	// Broadcast also sends the replies to the channel
+	Broadcast bool `yaml:"broadcast"`
 }
 
 type SlackSink struct {
-	cfg    *SlackConfig
-	client *slack.Client
+	cfg        *SlackConfig
+	client     *slack.Client
+	httpClient *http.Client
+	threads    *lru.Cache
+
+	mu sync.Mutex
+	// limited holds until when the channels are rate limited
+	limited map[string]time.Time
+}
+
+// slackThread is the message starting the thread of an object, the channel is the id returned by Slack
+type slackThread struct {
+	channel string
+	ts      string
+	expires time.Time
 }
 
 func NewSlackSink(cfg *SlackConfig) (Sink, error) {
-	return &SlackSink{
-		cfg:    cfg,
-		client: slack.New(cfg.Token),
-	}, nil
+	if cfg.Token == "" && cfg.WebhookURL == "" {
+		return nil, errors.New("slack token or webhookURL is required")
+	}
+	if cfg.WebhookURL != "" && cfg.Thread != nil {
+		return nil, errors.New("slack threads require a token, the webhooks do not return the messages they post")
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = DefaultSlackMaxRetries
+	}
+	if err := validateTemplates(cfg.Blocks); err != nil {
+		return nil, fmt.Errorf("invalid slack blocks: %w", err)
+	}
+
+	s := &SlackSink{
+		cfg:        cfg,
+		client:     slack.New(cfg.Token),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		limited:    make(map[string]time.Time),
+	}
+
+	if cfg.Thread != nil {
+		if cfg.Thread.Key == "" {
+			cfg.Thread.Key = DefaultSlackThreadKey
+		}
+		if err := ValidateTemplate(cfg.Thread.Key); err != nil {
+			return nil, fmt.Errorf("invalid slack thread key: %w", err)
+		}
+		if cfg.Thread.TTL <= 0 {
+			cfg.Thread.TTL = DefaultSlackThreadTTL
+		}
+		size := cfg.Thread.CacheSize
+		if size <= 0 {
+			size = DefaultSlackThreadCacheSize
+		}
+		// lru.New only fails for non-positive sizes
+		s.threads, _ = lru.New(size)
+	}
+	return s, nil
 }
 
 func (s *SlackSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	channel, err := GetString(ev, s.cfg.Channel)
+	message, attachments, blocks, err := s.message(ev)
 	if err != nil {
 		return err
 	}
 
-	message, err := GetString(ev, s.cfg.Message)
+	if s.cfg.WebhookURL != "" {
+		msg := &slack.WebhookMessage{Text: message, Attachments: attachments}
+		if blocks != nil {
+			msg.Blocks = &slack.Blocks{BlockSet: blocks}
+		}
+		return s.sendToChannel(ctx, s.cfg.WebhookURL, func() error {
+			return slack.PostWebhookCustomHTTPContext(ctx, s.cfg.WebhookURL, s.httpClient, msg)
+		})
+	}
+
+	channel, err := GetString(ev, s.cfg.Channel)
 	if err != nil {
 		return err
 	}
 
 	options := []slack.MsgOption{slack.MsgOptionText(message, true)}
+	if attachments != nil {
+		options = append(options, slack.MsgOptionAttachments(attachments...))
+	}
+	if blocks != nil {
+		options = append(options, slack.MsgOptionBlocks(blocks...))
+	}
+
+	threadKey := ""
+	if s.cfg.Thread != nil {
+		key, err := GetString(ev, s.cfg.Thread.Key)
+		if err != nil {
+			return err
+		}
+		threadKey = channel + "/" + key
+		if thread, ok := s.thread(threadKey); ok {
+			channel = thread.channel
+			options = append(options, slack.MsgOptionTS(thread.ts))
+			if s.cfg.Thread.Broadcast {
+				options = append(options, slack.MsgOptionBroadcast())
+			}
+			threadKey = ""
+		}
+	}
+
+	return s.sendToChannel(ctx, channel, func() error {
+		_ch, _ts, _text, err := s.client.SendMessageContext(ctx, channel, options...)
+		l := slog.With("ch", _ch, "ts", _ts, "text", _text)
+		if err != nil {
+			l.Error(
+				"Slack Response", "err", err)
+			return err
+		}
+		l.Debug("Slack Response")
+
+		// The message starts the thread of the object
+		if threadKey != "" {
+			s.threads.Add(threadKey, slackThread{channel: _ch, ts: _ts, expires: time.Now().Add(s.cfg.Thread.TTL)})
+		}
+		return nil
+	})
+}
+
+// message renders the text, the legacy attachment of the fields and the blocks of the event
+func (s *SlackSink) message(ev *kube.EnhancedEvent) (string, []slack.Attachment, []slack.Block, error) {
+	message, err := GetString(ev, s.cfg.Message)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	var attachments []slack.Attachment
 	if s.cfg.Fields != nil {
 		fields := make([]slack.AttachmentField, 0)
 		for k, v := range s.cfg.Fields {
 			fieldText, err := GetString(ev, v)
 			if err != nil {
-				return err
+				return "", nil, nil, err
 			}
 
 			fields = append(fields, slack.AttachmentField{
@@ -69,43 +210,104 @@ func (s *SlackSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
 		if s.cfg.AuthorName != "" {
 			slackAttachment.AuthorName, err = GetString(ev, s.cfg.AuthorName)
 			if err != nil {
-				return err
+				return "", nil, nil, err
 			}
 		}
 		if s.cfg.Color != "" {
 			slackAttachment.Color, err = GetString(ev, s.cfg.Color)
 			if err != nil {
-				return err
+				return "", nil, nil, err
 			}
 		}
 		if s.cfg.Title != "" {
 			slackAttachment.Title, err = GetString(ev, s.cfg.Title)
 			if err != nil {
-				return err
+				return "", nil, nil, err
 			}
 		}
 		if s.cfg.Footer != "" {
 			slackAttachment.Footer, err = GetString(ev, s.cfg.Footer)
 			if err != nil {
-				return err
+				return "", nil, nil, err
 			}
 		}
 
-		options = append(options, slack.MsgOptionAttachments(slackAttachment))
+		attachments = []slack.Attachment{slackAttachment}
 	}
 
-	_ch, _ts, _text, err := s.client.SendMessageContext(ctx, channel, options...)
-	l := slog.With("ch", _ch, "ts", _ts, "text", _text)
-	if err != nil {
-		l.Error(
-			"Slack Response", "err", err)
-	} else {
-		l.Debug("Slack Response")
+	var blocks []slack.Block
+	if s.cfg.Blocks != nil {
+		rendered, err := convertTemplate(s.cfg.Blocks, ev)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		b, err := json.Marshal(rendered)
+		if err != nil {
+			return "", nil, nil, Permanent(err)
+		}
+		var set slack.Blocks
+		if err := json.Unmarshal(b, &set); err != nil {
+			return "", nil, nil, Permanent(fmt.Errorf("invalid slack blocks: %w", err))
+		}
+		blocks = set.BlockSet
+	}
+	return message, attachments, blocks, nil
+}
+
+// thread returns the thread of the key unless it has expired
+func (s *SlackSink) thread(key string) (slackThread, bool) {
+	v, ok := s.threads.Get(key)
+	if !ok {
+		return slackThread{}, false
+	}
+	thread := v.(slackThread)
+	if time.Now().After(thread.expires) {
+		s.threads.Remove(key)
+		return slackThread{}, false
 	}
+	return thread, true
+}
 
+// sendToChannel sends to the channel once it is no longer rate limited. The rate limited messages are retried after
+// the Retry-After delay, which holds back the other messages to the channel as well, and the failed ones with a
+// backoff. The rejected messages are not retried.
+func (s *SlackSink) sendToChannel(ctx context.Context, channel string, send func() error) error {
+	return withRetries(ctx, "slack", s.cfg.MaxRetries, func() (time.Duration, error) {
+		s.mu.Lock()
+		until := s.limited[channel]
+		s.mu.Unlock()
+		if wait := time.Until(until); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return -1, ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+
+		err := send()
+		var rateLimited *slack.RateLimitedError
+		if !errors.As(err, &rateLimited) {
+			return -1, slackError(err)
+		}
+
+		wait := min(rateLimited.RetryAfter, maxRetryWait)
+		s.mu.Lock()
+		s.limited[channel] = time.Now().Add(wait)
+		s.mu.Unlock()
+		return wait, err
+	})
+}
+
+// slackError marks the messages rejected by Slack as permanent
+func slackError(err error) error {
+	var apiErr slack.SlackErrorResponse
+	var statusErr slack.StatusCodeError
+	if errors.As(err, &apiErr) || (errors.As(err, &statusErr) && !statusErr.Retryable()) {
+		return Permanent(err)
+	}
 	return err
 }
 
 func (s *SlackSink) Close() {
-	// No-op
+	s.httpClient.CloseIdleConnections()
 }
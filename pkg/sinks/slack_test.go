@@ -0,0 +1,217 @@
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slackStandIn answers chat.postMessage and the webhook posts, rate limiting the first limited requests and failing the
+// next failures ones
+type slackStandIn struct {
+	*httptest.Server
+	mu       sync.Mutex
+	forms    []url.Values
+	webhooks []map[string]any
+	limited  int
+	failures int
+}
+
+func newSlackStandIn(t *testing.T) *slackStandIn {
+	s := &slackStandIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.limited > 0 {
+			s.limited--
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.URL.Path == "/webhook" {
+			var body map[string]any
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			s.webhooks = append(s.webhooks, body)
+			_, _ = w.Write([]byte("ok"))
+			return
+		}
+
+		require.Equal(t, "/chat.postMessage", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		s.forms = append(s.forms, r.PostForm)
+		if r.PostForm.Get("channel") == "#missing" {
+			_, _ = w.Write([]byte(`{"ok": false, "error": "channel_not_found"}`))
+			return
+		}
+		_, _ = fmt.Fprintf(w, `{"ok": true, "channel": "C0123", "ts": "1700000000.00%04d"}`, len(s.forms))
+	}))
+	return s
+}
+
+func newSlackTestSink(t *testing.T, server *slackStandIn, cfg *SlackConfig) *SlackSink {
+	cfg.Token = "xoxb-token"
+	sink, err := NewSlackSink(cfg)
+	require.NoError(t, err)
+	s := sink.(*SlackSink)
+	s.client = slack.New(cfg.Token, slack.OptionAPIURL(server.URL+"/"))
+	return s
+}
+
+func newSlackEvent(name, reason string) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Reason = reason
+	ev.Message = reason + " " + name
+	ev.InvolvedObject.Kind = "Pod"
+	ev.InvolvedObject.Namespace = "default"
+	ev.InvolvedObject.Name = name
+	return ev
+}
+
+func TestSlackBlocks(t *testing.T) {
+	server := newSlackStandIn(t)
+	defer server.Close()
+
+	sink := newSlackTestSink(t, server, &SlackConfig{
+		Channel: "#alerts",
+		Message: "{{ .Message }}",
+		Blocks: []any{
+			map[string]any{
+				"type": "header",
+				"text": map[string]any{"type": "plain_text", "text": "{{ .Reason }}"},
+			},
+			map[string]any{
+				"type": "section",
+				"fields": []any{
+					map[string]any{"type": "mrkdwn", "text": "*Object*\n{{ .InvolvedObject.Namespace }}/{{ .InvolvedObject.Name }}"},
+				},
+			},
+		},
+	})
+
+	require.NoError(t, sink.Send(context.Background(), newSlackEvent("api-0", "BackOff")))
+	require.Len(t, server.forms, 1)
+	assert.Equal(t, "#alerts", server.forms[0].Get("channel"))
+	assert.Equal(t, "BackOff api-0", server.forms[0].Get("text"))
+
+	var blocks []map[string]any
+	require.NoError(t, json.Unmarshal([]byte(server.forms[0].Get("blocks")), &blocks))
+	require.Len(t, blocks, 2)
+	assert.Equal(t, map[string]any{"type": "plain_text", "text": "BackOff"}, blocks[0]["text"])
+	assert.Equal(t, []any{map[string]any{"type": "mrkdwn", "text": "*Object*\ndefault/api-0"}}, blocks[1]["fields"])
+}
+
+func TestSlackThreads(t *testing.T) {
+	server := newSlackStandIn(t)
+	defer server.Close()
+
+	sink := newSlackTestSink(t, server, &SlackConfig{
+		Channel: "#alerts",
+		Message: "{{ .Message }}",
+		Thread:  &SlackThreadConfig{Broadcast: true},
+	})
+
+	for _, ev := range []*kube.EnhancedEvent{
+		newSlackEvent("api-0", "BackOff"),
+		newSlackEvent("api-1", "BackOff"),
+		newSlackEvent("api-0", "Started"),
+	} {
+		require.NoError(t, sink.Send(context.Background(), ev))
+	}
+
+	require.Len(t, server.forms, 3)
+	assert.Equal(t, "", server.forms[0].Get("thread_ts"))
+	assert.Equal(t, "", server.forms[1].Get("thread_ts"))
+	// The reply goes to the thread of the first message of api-0, in the channel id returned for it
+	assert.Equal(t, "1700000000.000001", server.forms[2].Get("thread_ts"))
+	assert.Equal(t, "C0123", server.forms[2].Get("channel"))
+	assert.Equal(t, "true", server.forms[2].Get("reply_broadcast"))
+
+	// The expired threads are started again
+	v, _ := sink.threads.Get("#alerts/Pod/default/api-0")
+	thread := v.(slackThread)
+	thread.expires = thread.expires.Add(-DefaultSlackThreadTTL)
+	sink.threads.Add("#alerts/Pod/default/api-0", thread)
+	require.NoError(t, sink.Send(context.Background(), newSlackEvent("api-0", "Killing")))
+	assert.Equal(t, "", server.forms[3].Get("thread_ts"))
+}
+
+func TestSlackRateLimit(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	server := newSlackStandIn(t)
+	defer server.Close()
+
+	sink := newSlackTestSink(t, server, &SlackConfig{Channel: "#alerts", Message: "{{ .Message }}", MaxRetries: 2})
+
+	server.limited = 2
+	require.NoError(t, sink.Send(context.Background(), newSlackEvent("api-0", "BackOff")))
+	assert.Len(t, server.forms, 1)
+
+	server.limited = 3
+	err := sink.Send(context.Background(), newSlackEvent("api-0", "BackOff"))
+	var rateLimited *slack.RateLimitedError
+	assert.ErrorAs(t, err, &rateLimited)
+	assert.False(t, IsPermanent(err))
+
+	// The server errors are retried with a backoff
+	server.limited = 0
+	server.failures = 2
+	require.NoError(t, sink.Send(context.Background(), newSlackEvent("api-0", "BackOff")))
+	assert.Len(t, server.forms, 2)
+
+	sink.cfg.Channel = "#missing"
+	err = sink.Send(context.Background(), newSlackEvent("api-0", "BackOff"))
+	assert.ErrorContains(t, err, "channel_not_found")
+	assert.True(t, IsPermanent(err))
+}
+
+func TestSlackWebhook(t *testing.T) {
+	server := newSlackStandIn(t)
+	defer server.Close()
+
+	sink, err := NewSlackSink(&SlackConfig{
+		WebhookURL: server.URL + "/webhook",
+		Message:    "{{ .Message }}",
+		Fields:     map[string]string{"reason": "{{ .Reason }}"},
+	})
+	require.NoError(t, err)
+
+	server.limited = 1
+	require.NoError(t, sink.Send(context.Background(), newSlackEvent("api-0", "BackOff")))
+	require.Len(t, server.webhooks, 1)
+	assert.Equal(t, "BackOff api-0", server.webhooks[0]["text"])
+	assert.Equal(t, []any{map[string]any{"title": "reason", "value": "BackOff", "short": false}},
+		server.webhooks[0]["attachments"].([]any)[0].(map[string]any)["fields"])
+}
+
+func TestSlackConfigErrors(t *testing.T) {
+	for name, cfg := range map[string]*SlackConfig{
+		"token or webhookURL is required": {},
+		"slack threads require a token":   {WebhookURL: "https://hooks.slack.com/services/x", Thread: &SlackThreadConfig{}},
+		"invalid slack blocks":            {Token: "xoxb", Blocks: []any{map[string]any{"type": "{{ .Reason"}}},
+		"invalid slack thread key":        {Token: "xoxb", Thread: &SlackThreadConfig{Key: "{{ .Reason"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewSlackSink(cfg)
+			assert.ErrorContains(t, err, name)
+		})
+	}
+}
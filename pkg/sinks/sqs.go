@@ -2,17 +2,33 @@ package sinks
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 )
 
+// The limits of the SendMessageBatch API
+const (
+	sqsMaxMessages   = 10
+	sqsMaxBatchBytes = 256 * 1024
+	sqsMaxIDLength   = 128
+)
+
 type SQSConfig struct {
 	QueueName string         `yaml:"queueName"`
 	Region    string         `yaml:"region"`
+	Endpoint  string         `yaml:"endpoint"`
 	Layout    map[string]any `yaml:"layout"`
+	// MessageGroupID is a template rendered with the event, it is required for the FIFO queues
+	MessageGroupID string `yaml:"messageGroupId"`
+	// MessageDeduplicationID is a template rendered with the event, for the FIFO queues without content-based
+	// deduplication
+	MessageDeduplicationID string      `yaml:"messageDeduplicationId"`
+	Batch                  BatchConfig `yaml:"batch"`
 }
 
 type SQSSink struct {
@@ -22,10 +38,16 @@ type SQSSink struct {
 }
 
 func NewSQSSink(cfg *SQSConfig) (Sink, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(cfg.Region),
-	},
-	)
+	for name, text := range map[string]string{
+		"messageGroupId":         cfg.MessageGroupID,
+		"messageDeduplicationId": cfg.MessageDeduplicationID,
+	} {
+		if err := ValidateTemplate(text); err != nil {
+			return nil, fmt.Errorf("invalid sqs %s: %w", name, err)
+		}
+	}
+
+	sess, err := newAWSSession(cfg.Region, cfg.Endpoint)
 	if err != nil {
 		return nil, err
 	}
@@ -46,17 +68,88 @@ func NewSQSSink(cfg *SQSConfig) (Sink, error) {
 }
 
 func (s *SQSSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	toSend, e := serializeEventWithLayout(s.cfg.Layout, ev)
-	if e != nil {
-		return e
+	return s.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// SendBatch sends the events with SendMessageBatch, split by its limits. The messages failed because of the sender are
+// not retried.
+func (s *SQSSink) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	errs := make([]error, len(evs))
+	entries := make([]*sqs.SendMessageBatchRequestEntry, len(evs))
+	sizes := make([]int, len(evs))
+	included := make([]int, 0, len(evs))
+
+	for i, ev := range evs {
+		entry, err := s.entry(ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		sizes[i] = len(*entry.MessageBody)
+		if sizes[i] > sqsMaxBatchBytes {
+			errs[i] = Permanent(fmt.Errorf("sqs message of %d bytes is too large", sizes[i]))
+			continue
+		}
+		entries[i] = entry
+		included = append(included, i)
+	}
+
+	for _, chunk := range chunkBySize(included, sizes, sqsMaxMessages, sqsMaxBatchBytes) {
+		input := &sqs.SendMessageBatchInput{
+			QueueUrl: &s.queueURL,
+			Entries:  make([]*sqs.SendMessageBatchRequestEntry, 0, len(chunk)),
+		}
+		// The ids of the entries are their positions in the request
+		for n, i := range chunk {
+			entry := *entries[i]
+			entry.Id = aws.String(strconv.Itoa(n))
+			input.Entries = append(input.Entries, &entry)
+		}
+
+		out, err := s.svc.SendMessageBatchWithContext(ctx, input)
+		if err != nil {
+			setErrors(errs, chunk, awsRequestError(err))
+			continue
+		}
+		for _, failed := range out.Failed {
+			n, err := strconv.Atoi(aws.StringValue(failed.Id))
+			if err != nil || n < 0 || n >= len(chunk) {
+				continue
+			}
+			errs[chunk[n]] = awsEntryError(aws.StringValue(failed.Code), aws.StringValue(failed.Message), !aws.BoolValue(failed.SenderFault))
+		}
 	}
+	return errs
+}
 
-	_, err := s.svc.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+func (s *SQSSink) entry(ev *kube.EnhancedEvent) (*sqs.SendMessageBatchRequestEntry, error) {
+	toSend, err := serializeEventWithLayout(s.cfg.Layout, ev)
+	if err != nil {
+		return nil, err
+	}
+	entry := &sqs.SendMessageBatchRequestEntry{
 		MessageBody: aws.String(string(toSend)),
-		QueueUrl:    &s.queueURL,
-	})
+	}
+
+	if s.cfg.MessageGroupID != "" {
+		groupID, err := GetString(ev, s.cfg.MessageGroupID)
+		if err != nil {
+			return nil, err
+		}
+		entry.MessageGroupId = aws.String(truncate(groupID, sqsMaxIDLength))
+	}
+	if s.cfg.MessageDeduplicationID != "" {
+		dedupID, err := GetString(ev, s.cfg.MessageDeduplicationID)
+		if err != nil {
+			return nil, err
+		}
+		entry.MessageDeduplicationId = aws.String(truncate(dedupID, sqsMaxIDLength))
+	}
+	return entry, nil
+}
 
-	return err
+func (s *SQSSink) BatchConfig() batch.WriterConfig {
+	return s.cfg.Batch.WriterConfig(defaultAWSWriterConfig)
 }
 
 func (s *SQSSink) Close() {
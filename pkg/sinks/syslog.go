@@ -1,46 +1,373 @@
-//go:build !windows && !plan9
-
 package sinks
 
 import (
 	"context"
-	"encoding/json"
-	"log/syslog"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 )
 
+const (
+	SyslogFormatRFC3164 = "rfc3164"
+	SyslogFormatRFC5424 = "rfc5424"
+
+	SyslogFramingOctetCounting  = "octetCounting"
+	SyslogFramingNonTransparent = "nonTransparent"
+
+	DefaultSyslogMsgID            = "{{ .Reason }}"
+	DefaultSyslogStructuredDataID = "event@32473"
+)
+
+// DefaultSyslogStructuredData are the parameters of the structured data element when none are given
+var DefaultSyslogStructuredData = map[string]string{
+	"namespace": "{{ .InvolvedObject.Namespace }}",
+	"kind":      "{{ .InvolvedObject.Kind }}",
+	"name":      "{{ .InvolvedObject.Name }}",
+	"reason":    "{{ .Reason }}",
+	"type":      "{{ .Type }}",
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7, "uucp": 8, "cron": 9,
+	"authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21,
+	"local6": 22, "local7": 23,
+}
+
 type SyslogConfig struct {
+	// Network is udp, tcp, tls, unix or unixgram, the local syslog daemon is used when it is empty
 	Network string `yaml:"network"`
 	Address string `yaml:"address"`
-	Tag     string `yaml:"tag"`
+	// Tag is the name of the application in the messages, the name of the executable by default
+	Tag string `yaml:"tag"`
+	// Format is rfc3164, by default, or rfc5424
+	Format string `yaml:"format"`
+	// Facility is the name of the facility of the messages, local0 by default
+	Facility string `yaml:"facility"`
+	// Hostname is the host in the messages, the hostname by default
+	Hostname string `yaml:"hostname"`
+	// MsgID is a template of the MSGID of the rfc5424 messages, the reason of the event by default
+	MsgID string `yaml:"msgID"`
+	// StructuredData are the templates of the parameters of the structured data element of the rfc5424 messages, its
+	// id is StructuredDataID. The parameters rendered empty are left out.
+	StructuredData   map[string]string `yaml:"structuredData"`
+	StructuredDataID string            `yaml:"structuredDataID"`
+	// Framing of the rfc5424 messages over tcp and tls is octetCounting, by default, or nonTransparent to end them
+	// with a newline
+	Framing string        `yaml:"framing"`
+	TLS     TLS           `yaml:"tls"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Layout is the message, the event by default
+	Layout map[string]any `yaml:"layout"`
 }
 
 type SyslogSink struct {
-	sw *syslog.Writer
+	cfg       *SyslogConfig
+	tlsConfig *tls.Config
+	facility  int
+	hostname  string
+	pid       int
+
+	mu    sync.Mutex
+	conn  net.Conn
+	local bool
 }
 
 func NewSyslogSink(config *SyslogConfig) (Sink, error) {
-	w, err := syslog.Dial(config.Network, config.Address, syslog.LOG_LOCAL0, config.Tag)
-	if err != nil {
+	switch config.Format {
+	case "":
+		config.Format = SyslogFormatRFC3164
+	case SyslogFormatRFC3164, SyslogFormatRFC5424:
+	default:
+		return nil, fmt.Errorf("invalid syslog format %q: can be one of 'rfc3164' or 'rfc5424'", config.Format)
+	}
+	switch config.Network {
+	case "", "udp", "tcp", "tls", "unix", "unixgram":
+	default:
+		return nil, fmt.Errorf("invalid syslog network %q: can be one of 'udp', 'tcp', 'tls', 'unix' or 'unixgram'", config.Network)
+	}
+	if config.Network != "" && config.Address == "" {
+		return nil, errors.New("syslog address cannot be empty")
+	}
+	switch config.Framing {
+	case "":
+		config.Framing = SyslogFramingOctetCounting
+	case SyslogFramingOctetCounting, SyslogFramingNonTransparent:
+	default:
+		return nil, fmt.Errorf("invalid syslog framing %q: can be one of 'octetCounting' or 'nonTransparent'", config.Framing)
+	}
+	if config.Facility == "" {
+		config.Facility = "local0"
+	}
+	facility, ok := syslogFacilities[config.Facility]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog facility %q", config.Facility)
+	}
+	if config.Tag == "" {
+		config.Tag = filepath.Base(os.Args[0])
+	}
+	if config.MsgID == "" {
+		config.MsgID = DefaultSyslogMsgID
+	}
+	if config.StructuredData == nil {
+		config.StructuredData = DefaultSyslogStructuredData
+	}
+	if config.StructuredDataID == "" {
+		config.StructuredDataID = DefaultSyslogStructuredDataID
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	templates := map[string]string{"msgID": config.MsgID}
+	for name, text := range config.StructuredData {
+		templates["structuredData."+name] = text
+	}
+	for name, text := range templates {
+		if err := ValidateTemplate(text); err != nil {
+			return nil, fmt.Errorf("invalid syslog %s: %w", name, err)
+		}
+	}
+	if err := validateTemplates(config.Layout); err != nil {
+		return nil, fmt.Errorf("invalid syslog layout: %w", err)
+	}
+
+	w := &SyslogSink{cfg: config, facility: facility, hostname: config.Hostname, pid: os.Getpid()}
+	if w.hostname == "" {
+		w.hostname, _ = os.Hostname()
+	}
+	if config.Network == "tls" {
+		tlsConfig, err := setupTLS(&config.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup TLS: %w", err)
+		}
+		w.tlsConfig = tlsConfig
+	}
+
+	// The connection is checked once like before, it is connected again when writing fails
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.connect(context.Background()); err != nil {
 		return nil, err
 	}
-	return &SyslogSink{sw: w}, nil
+	return w, nil
 }
 
 func (w *SyslogSink) Close() {
-	w.sw.Close()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closeConn()
 }
 
 func (w *SyslogSink) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	if b, err := json.Marshal(ev); err == nil {
-		_, writeErr := w.sw.Write(b)
+	msg, err := serializeEventWithLayout(w.cfg.Layout, ev)
+	if err != nil {
+		return Permanent(err)
+	}
+	priority := w.facility*8 + syslogSeverity(ev.Type)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-		if writeErr != nil {
-			return writeErr
+	var b []byte
+	if w.cfg.Format == SyslogFormatRFC5424 {
+		b, err = w.rfc5424(ev, priority, msg)
+		if err != nil {
+			return Permanent(err)
 		}
 	} else {
+		b = w.rfc3164(priority, msg)
+	}
+
+	if w.conn != nil {
+		if err := w.write(ctx, b); err == nil {
+			return nil
+		}
+		w.closeConn()
+	}
+	if err := w.connect(ctx); err != nil {
+		return err
+	}
+	if err := w.write(ctx, b); err != nil {
+		w.closeConn()
 		return err
 	}
 	return nil
 }
+
+// syslogSeverity is the syslog severity of the event type, warning for the warnings and informational for the normal
+// events
+func syslogSeverity(eventType string) int {
+	switch eventType {
+	case "Warning":
+		return 4
+	case "Normal":
+		return 6
+	}
+	return 5
+}
+
+// rfc3164 formats the message like log/syslog did, the hostname is left out for the local daemon
+func (w *SyslogSink) rfc3164(priority int, msg []byte) []byte {
+	var sb strings.Builder
+	if w.local {
+		fmt.Fprintf(&sb, "<%d>%s %s[%d]: ", priority, time.Now().Format(time.Stamp), w.cfg.Tag, w.pid)
+	} else {
+		fmt.Fprintf(&sb, "<%d>%s %s %s[%d]: ", priority, time.Now().Format(time.RFC3339), w.hostname, w.cfg.Tag, w.pid)
+	}
+	sb.Write(msg)
+	if len(msg) == 0 || msg[len(msg)-1] != '\n' {
+		sb.WriteByte('\n')
+	}
+	return []byte(sb.String())
+}
+
+// rfc5424 formats the message with the time of the event and its structured data, and frames it for the stream
+// networks
+// see: https://datatracker.ietf.org/doc/html/rfc5424#section-6
+func (w *SyslogSink) rfc5424(ev *kube.EnhancedEvent, priority int, msg []byte) ([]byte, error) {
+	at := time.Now()
+	if ms := ev.GetTimestampMs(); ms > 0 {
+		at = time.UnixMilli(ms)
+	}
+	msgID, err := GetString(ev, w.cfg.MsgID)
+	if err != nil {
+		return nil, err
+	}
+	sd, err := w.structuredData(ev)
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<%d>1 %s %s %s %d %s %s ",
+		priority,
+		at.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(w.hostname, 255),
+		syslogHeaderField(w.cfg.Tag, 48),
+		w.pid,
+		syslogHeaderField(msgID, 32),
+		sd,
+	)
+	sb.Write(msg)
+	b := []byte(sb.String())
+
+	switch {
+	case w.cfg.Network != "tcp" && w.cfg.Network != "tls":
+		return b, nil
+	case w.cfg.Framing == SyslogFramingNonTransparent:
+		return append(b, '\n'), nil
+	}
+	// see: https://datatracker.ietf.org/doc/html/rfc6587#section-3.4.1
+	return append([]byte(strconv.Itoa(len(b))+" "), b...), nil
+}
+
+// structuredData renders the element of the parameters in the order of their names, or the nil value when they are
+// all empty
+func (w *SyslogSink) structuredData(ev *kube.EnhancedEvent) (string, error) {
+	names := make([]string, 0, len(w.cfg.StructuredData))
+	for name := range w.cfg.StructuredData {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		value, err := GetString(ev, w.cfg.StructuredData[name])
+		if err != nil {
+			return "", err
+		}
+		if value == "" {
+			continue
+		}
+		// The values escape the quotes, the backslashes and the closing brackets
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+		fmt.Fprintf(&sb, ` %s="%s"`, syslogName(name, 32), value)
+	}
+	if sb.Len() == 0 {
+		return "-", nil
+	}
+	return "[" + syslogName(w.cfg.StructuredDataID, 32) + sb.String() + "]", nil
+}
+
+// syslogHeaderField keeps the printable ASCII characters of a header field, up to its maximum length
+func syslogHeaderField(s string, n int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	return truncate(s, n)
+}
+
+// syslogName is a header field which can be the name of a structured data element or parameter
+func syslogName(s string, n int) string {
+	return syslogHeaderField(strings.Map(func(r rune) rune {
+		if r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, s), n)
+}
+
+// write writes the message, it must be called with the lock held
+func (w *SyslogSink) write(ctx context.Context, b []byte) error {
+	deadline := time.Now().Add(w.cfg.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := w.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	_, err := w.conn.Write(b)
+	return err
+}
+
+// connect connects to the server, or to the local daemon when there is no network. It must be called with the lock
+// held.
+func (w *SyslogSink) connect(ctx context.Context) error {
+	dialer := &net.Dialer{Timeout: w.cfg.Timeout}
+	var err error
+	switch w.cfg.Network {
+	case "":
+		for _, network := range []string{"unixgram", "unix"} {
+			for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+				if w.conn, err = dialer.DialContext(ctx, network, path); err == nil {
+					w.local = true
+					return nil
+				}
+			}
+		}
+		return errors.New("cannot connect to the local syslog")
+	case "tls":
+		w.conn, err = (&tls.Dialer{NetDialer: dialer, Config: w.tlsConfig}).DialContext(ctx, "tcp", w.cfg.Address)
+	default:
+		w.conn, err = dialer.DialContext(ctx, w.cfg.Network, w.cfg.Address)
+	}
+	if err != nil {
+		w.conn = nil
+		return fmt.Errorf("cannot connect to syslog %s: %w", w.cfg.Address, err)
+	}
+	return nil
+}
+
+// closeConn closes the connection, it must be called with the lock held
+func (w *SyslogSink) closeConn() {
+	if w.conn != nil {
+		_ = w.conn.Close()
+		w.conn = nil
+	}
+}
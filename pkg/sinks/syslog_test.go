@@ -0,0 +1,182 @@
+package sinks
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newSyslogEvent(eventType string) *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Type = eventType
+	ev.Reason = "BackOff"
+	ev.Message = "Back-off restarting failed container"
+	ev.InvolvedObject.Kind = "Pod"
+	ev.InvolvedObject.Name = "nginx"
+	ev.InvolvedObject.Namespace = `default"]`
+	ev.FirstTimestamp = metav1.NewTime(time.Date(2024, 1, 2, 3, 4, 5, 250000000, time.UTC))
+	return ev
+}
+
+// syslogListener accepts the stream connections and sends the octet counted frames they receive
+func syslogListener(t *testing.T, listener net.Listener) <-chan string {
+	frames := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					length, err := r.ReadString(' ')
+					if err != nil {
+						return
+					}
+					n, err := strconv.Atoi(strings.TrimSpace(length))
+					require.NoError(t, err)
+					frame := make([]byte, n)
+					if _, err := io.ReadFull(r, frame); err != nil {
+						return
+					}
+					frames <- string(frame)
+				}
+			}()
+		}
+	}()
+	return frames
+}
+
+func receiveSyslog(t *testing.T, frames <-chan string) string {
+	select {
+	case frame := <-frames:
+		return frame
+	case <-time.After(5 * time.Second):
+		t.Fatal("the message was not received")
+	}
+	return ""
+}
+
+func TestSyslogRFC5424OverTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	frames := syslogListener(t, listener)
+
+	sink, err := NewSyslogSink(&SyslogConfig{
+		Network:  "tcp",
+		Address:  listener.Addr().String(),
+		Tag:      "k8s.event",
+		Format:   SyslogFormatRFC5424,
+		Facility: "local3",
+		Hostname: "exporter",
+		Layout:   map[string]any{"message": "{{ .Message }}"},
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.Send(context.Background(), newSyslogEvent("Warning")))
+	pid := strconv.Itoa(os.Getpid())
+	assert.Equal(t,
+		`<156>1 2024-01-02T03:04:05.250000Z exporter k8s.event `+pid+` BackOff `+
+			`[event@32473 kind="Pod" name="nginx" namespace="default\"\]" reason="BackOff" type="Warning"] `+
+			`{"message":"Back-off restarting failed container"}`,
+		receiveSyslog(t, frames))
+
+	// The severity comes from the type of the event
+	require.NoError(t, sink.Send(context.Background(), newSyslogEvent("Normal")))
+	assert.True(t, strings.HasPrefix(receiveSyslog(t, frames), "<158>1 "))
+
+	// The sink connects again once the connection is lost
+	require.NoError(t, listener.Close())
+	listener, err = net.Listen("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer listener.Close()
+	frames = syslogListener(t, listener)
+	sink.(*SyslogSink).conn.Close()
+	require.NoError(t, sink.Send(context.Background(), newSyslogEvent("Normal")))
+	assert.True(t, strings.HasPrefix(receiveSyslog(t, frames), "<158>1 "))
+}
+
+func TestSyslogRFC5425OverTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(nil)
+	server.StartTLS()
+	certificates := server.TLS.Certificates
+	server.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certificates})
+	require.NoError(t, err)
+	defer listener.Close()
+	frames := syslogListener(t, listener)
+
+	sink, err := NewSyslogSink(&SyslogConfig{
+		Network:        "tls",
+		Address:        listener.Addr().String(),
+		Format:         SyslogFormatRFC5424,
+		TLS:            TLS{InsecureSkipVerify: true},
+		StructuredData: map[string]string{"cluster": "{{ .ClusterName }}"},
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.Send(context.Background(), newSyslogEvent("Warning")))
+	frame := receiveSyslog(t, frames)
+	// The facility is local0 by default and the structured data is nil when its parameters are empty
+	assert.True(t, strings.HasPrefix(frame, "<132>1 "))
+	assert.Contains(t, frame, " BackOff - {")
+}
+
+func TestSyslogRFC3164OverUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sink, err := NewSyslogSink(&SyslogConfig{
+		Network:  "udp",
+		Address:  conn.LocalAddr().String(),
+		Tag:      "k8s.event",
+		Hostname: "exporter",
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+
+	require.NoError(t, sink.Send(context.Background(), newSyslogEvent("Warning")))
+	buf := make([]byte, 65536)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	msg := string(buf[:n])
+	assert.True(t, strings.HasPrefix(msg, "<132>"))
+	assert.Contains(t, msg, " exporter k8s.event["+strconv.Itoa(os.Getpid())+"]: {")
+	assert.Contains(t, msg, `"reason":"BackOff"`)
+	assert.True(t, strings.HasSuffix(msg, "}\n"))
+}
+
+func TestSyslogConfigErrors(t *testing.T) {
+	for _, cfg := range []*SyslogConfig{
+		{Network: "tcp"},
+		{Network: "http", Address: "localhost:514"},
+		{Network: "udp", Address: "localhost:514", Format: "json"},
+		{Network: "udp", Address: "localhost:514", Facility: "local9"},
+		{Network: "udp", Address: "localhost:514", Framing: "nul"},
+		{Network: "udp", Address: "localhost:514", MsgID: "{{ .Reason"},
+	} {
+		_, err := NewSyslogSink(cfg)
+		assert.Error(t, err, "%+v", cfg)
+	}
+}
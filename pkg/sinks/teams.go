@@ -1,82 +1,155 @@
 package sinks
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"strings"
+	"time"
 
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 )
 
-type TeamsConfig struct {
-	Endpoint string            `yaml:"endpoint"`
-	Layout   map[string]any    `yaml:"layout"`
-	Headers  map[string]string `yaml:"headers"`
-}
+const (
+	TeamsFormatAdaptiveCard = "adaptiveCard"
+	TeamsFormatMessageCard  = "messageCard"
+	TeamsFormatRaw          = "raw"
+)
 
-func NewTeamsSink(cfg *TeamsConfig) (Sink, error) {
-	return &Teams{cfg: cfg}, nil
-}
+// teamsPlatform posts raw payloads, the connectors allow 4 requests per second
+var teamsPlatform = chatPlatform{name: "teams", rateLimit: 4, burst: 4}
 
-type Teams struct {
-	cfg *TeamsConfig
+// DefaultTeamsCard is the Adaptive Card of the events when no layout is given
+var DefaultTeamsCard = map[string]any{
+	"body": []any{
+		map[string]any{
+			"type":   "TextBlock",
+			"size":   "Medium",
+			"weight": "Bolder",
+			"wrap":   true,
+			"text":   "{{ .Reason }}: {{ .InvolvedObject.Kind }} {{ with .InvolvedObject.Namespace }}{{ . }}/{{ end }}{{ .InvolvedObject.Name }}",
+		},
+		map[string]any{
+			"type": "TextBlock",
+			"wrap": true,
+			"text": "{{ .Message }}",
+		},
+		map[string]any{
+			"type": "FactSet",
+			"facts": []any{
+				map[string]any{"title": "Type", "value": "{{ .Type }}"},
+				map[string]any{"title": "Cluster", "value": "{{ .ClusterName }}"},
+				map[string]any{"title": "Count", "value": "{{ .Count }}"},
+			},
+		},
+	},
 }
 
-func (w *Teams) Close() {
-	// No-op
+type TeamsConfig struct {
+	Endpoint string `yaml:"endpoint"`
+	// Format is adaptiveCard by default, posting the layout as an Adaptive Card in the message envelope of the
+	// Workflows webhooks. It is messageCard to post the layout as a legacy Office 365 connector card, or raw to post
+	// the layout as it is.
+	Format  string            `yaml:"format"`
+	Layout  map[string]any    `yaml:"layout"`
+	Headers map[string]string `yaml:"headers"`
+	// MaxRetries is the number of times the rate limited and failed messages are retried, 3 by default
+	MaxRetries int           `yaml:"maxRetries"`
+	Timeout    time.Duration `yaml:"timeout"`
 }
 
-func (w *Teams) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
-	event, err := serializeEventWithLayout(w.cfg.Layout, ev)
-	if err != nil {
-		return err
+func NewTeamsSink(cfg *TeamsConfig) (Sink, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("teams endpoint cannot be empty")
+	}
+	switch cfg.Format {
+	case "":
+		cfg.Format = TeamsFormatAdaptiveCard
+	case TeamsFormatAdaptiveCard, TeamsFormatMessageCard, TeamsFormatRaw:
+	default:
+		return nil, fmt.Errorf("invalid teams format %q: can be one of 'adaptiveCard', 'messageCard' or 'raw'", cfg.Format)
+	}
+	if err := validateTemplates(cfg.Layout); err != nil {
+		return nil, fmt.Errorf("invalid teams layout: %w", err)
 	}
 
-	var eventData map[string]any
-	json.Unmarshal([]byte(event), &eventData)
-	output := fmt.Sprintf("Event: %s \nStatus: %s \nMetadata: %s", eventData["message"], eventData["reason"], eventData["metadata"])
-
-	reqBody, err := json.Marshal(map[string]string{
-		"summary": "event",
-		"text":    string([]byte(output)),
+	client, err := newChatClient(teamsPlatform, &ChatConfig{
+		URL:        cfg.Endpoint,
+		Headers:    cfg.Headers,
+		Timeout:    cfg.Timeout,
+		MaxRetries: cfg.MaxRetries,
 	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// The legacy connectors respond with 200 when they are rate limited
+	// see: https://learn.microsoft.com/en-us/microsoftteams/platform/webhooks-and-connectors/how-to/connectors-using?tabs=cURL#rate-limiting-for-connectors
+	client.rateLimited = func(body []byte) bool {
+		return strings.Contains(string(body), "Microsoft Teams endpoint returned HTTP error 429")
 	}
+	return &Teams{cfg: cfg, chatClient: client}, nil
+}
 
-	req, err := http.NewRequest(http.MethodPost, w.cfg.Endpoint, bytes.NewReader(reqBody))
+type Teams struct {
+	cfg *TeamsConfig
+	*chatClient
+}
+
+func (w *Teams) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	reqBody, err := w.body(ev)
 	if err != nil {
 		return err
 	}
-	req.Header.Add("Content-Type", "application/json")
-	for k, v := range w.cfg.Headers {
-		req.Header.Add(k, v)
-	}
+	return w.post(ctx, w.cfg.Endpoint, json.RawMessage(reqBody))
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil
+// body renders the payload of the event in the configured format
+func (w *Teams) body(ev *kube.EnhancedEvent) ([]byte, error) {
+	switch w.cfg.Format {
+	case TeamsFormatRaw:
+		return serializeEventWithLayout(w.cfg.Layout, ev)
+	case TeamsFormatMessageCard:
+		if w.cfg.Layout != nil {
+			return serializeEventWithLayout(w.cfg.Layout, ev)
+		}
+		var eventData map[string]any
+		if err := json.Unmarshal(ev.ToJSON(), &eventData); err != nil {
+			return nil, err
+		}
+		output := fmt.Sprintf("Event: %s \nStatus: %s \nMetadata: %s", eventData["message"], eventData["reason"], eventData["metadata"])
+		return json.Marshal(map[string]string{
+			"summary": "event",
+			"text":    output,
+		})
 	}
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	message := string(body)
-
-	if err != nil {
-		return err
+	layout := w.cfg.Layout
+	if layout == nil {
+		layout = DefaultTeamsCard
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("not 200: %s", message)
+	card, err := convertLayoutTemplate(layout, ev)
+	if err != nil {
+		return nil, err
 	}
-	// see: https://learn.microsoft.com/en-us/microsoftteams/platform/webhooks-and-connectors/how-to/connectors-using?tabs=cURL#rate-limiting-for-connectors
-	if strings.Contains(message, "Microsoft Teams endpoint returned HTTP error 429") {
-		return fmt.Errorf("rate limited: %s", message)
+	for key, value := range map[string]string{
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+	} {
+		if _, ok := card[key]; !ok {
+			card[key] = value
+		}
 	}
 
-	return nil
+	return json.Marshal(map[string]any{
+		"type": "message",
+		"attachments": []any{
+			map[string]any{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"contentUrl":  nil,
+				"content":     card,
+			},
+		},
+	})
 }
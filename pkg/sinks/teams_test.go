@@ -2,12 +2,15 @@ package sinks
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTeams_Send(t *testing.T) {
@@ -15,9 +18,10 @@ func TestTeams_Send(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer ts.Close()
-	client := Teams{cfg: &TeamsConfig{Endpoint: ts.URL}}
+	client, err := NewTeamsSink(&TeamsConfig{Endpoint: ts.URL})
+	require.NoError(t, err)
 
-	err := client.Send(context.Background(), &kube.EnhancedEvent{})
+	err = client.Send(context.Background(), &kube.EnhancedEvent{})
 
 	assert.NoError(t, err)
 }
@@ -28,9 +32,115 @@ func TestTeams_Send_WhenTeamsReturnsRateLimited(t *testing.T) {
 		_, _ = w.Write([]byte("Webhook message delivery failed with error: Microsoft Teams endpoint returned HTTP error 429 with ContextId tcid=0"))
 	}))
 	defer ts.Close()
-	client := Teams{cfg: &TeamsConfig{Endpoint: ts.URL}}
+	client, err := NewTeamsSink(&TeamsConfig{Endpoint: ts.URL, MaxRetries: 1})
+	require.NoError(t, err)
 
-	err := client.Send(context.Background(), &kube.EnhancedEvent{})
+	err = client.Send(context.Background(), &kube.EnhancedEvent{})
 
 	assert.ErrorContains(t, err, "rate limited")
+	assert.False(t, IsPermanent(err))
+}
+
+func TestTeams_Send_AdaptiveCard(t *testing.T) {
+	var body map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	client, err := NewTeamsSink(&TeamsConfig{
+		Endpoint: ts.URL,
+		Headers:  map[string]string{"X-Api-Key": "secret"},
+		Layout: map[string]any{
+			"body": []any{
+				map[string]any{"type": "TextBlock", "text": "{{ .Reason }} {{ .InvolvedObject.Name }}"},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	ev := &kube.EnhancedEvent{}
+	ev.Reason = "BackOff"
+	ev.InvolvedObject.Name = "api-0"
+	require.NoError(t, client.Send(context.Background(), ev))
+
+	assert.Equal(t, map[string]any{
+		"type": "message",
+		"attachments": []any{
+			map[string]any{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"contentUrl":  nil,
+				"content": map[string]any{
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"body": []any{
+						map[string]any{"type": "TextBlock", "text": "BackOff api-0"},
+					},
+				},
+			},
+		},
+	}, body)
+}
+
+func TestTeams_Send_Formats(t *testing.T) {
+	var body map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+	}))
+	defer ts.Close()
+
+	ev := &kube.EnhancedEvent{}
+	ev.Reason = "BackOff"
+	ev.Message = "Back-off restarting failed container"
+
+	client, err := NewTeamsSink(&TeamsConfig{Endpoint: ts.URL, Format: TeamsFormatRaw, Layout: map[string]any{"reason": "{{ .Reason }}"}})
+	require.NoError(t, err)
+	require.NoError(t, client.Send(context.Background(), ev))
+	assert.Equal(t, map[string]any{"reason": "BackOff"}, body)
+
+	client, err = NewTeamsSink(&TeamsConfig{Endpoint: ts.URL, Format: TeamsFormatMessageCard})
+	require.NoError(t, err)
+	require.NoError(t, client.Send(context.Background(), ev))
+	assert.Equal(t, "event", body["summary"])
+	assert.Contains(t, body["text"], "Event: Back-off restarting failed container \nStatus: BackOff \nMetadata: ")
+}
+
+func TestTeams_Send_Errors(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	attempts := 0
+	status := http.StatusTooManyRequests
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("error"))
+	}))
+	client, err := NewTeamsSink(&TeamsConfig{Endpoint: ts.URL, MaxRetries: 2})
+	require.NoError(t, err)
+
+	err = client.Send(context.Background(), &kube.EnhancedEvent{})
+	assert.ErrorContains(t, err, "rate limited")
+	assert.Equal(t, 3, attempts, "the rate limited message is retried")
+
+	status = http.StatusBadRequest
+	err = client.Send(context.Background(), &kube.EnhancedEvent{})
+	assert.True(t, IsPermanent(err))
+
+	attempts = 0
+	status = http.StatusBadGateway
+	err = client.Send(context.Background(), &kube.EnhancedEvent{})
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+	assert.Equal(t, 3, attempts, "the failed message is retried")
+
+	// The transport errors are returned
+	ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = client.Send(ctx, &kube.EnhancedEvent{})
+	assert.Error(t, err)
 }
@@ -11,6 +11,16 @@ import (
 )
 
 func GetString(event *kube.EnhancedEvent, text string) (string, error) {
+	return renderTemplate(event, text)
+}
+
+// GetGroupString renders the text with a group of events, so that the template can summarize them by ranging over
+// .Events or using the helpers of EventGroup.
+func GetGroupString(group *EventGroup, text string) (string, error) {
+	return renderTemplate(group, text)
+}
+
+func renderTemplate(data any, text string) (string, error) {
 	tmpl, err := template.New("template").Funcs(sprig.TxtFuncMap()).Parse(text)
 	if err != nil {
 		slog.With(
@@ -21,8 +31,7 @@ func GetString(event *kube.EnhancedEvent, text string) (string, error) {
 	}
 
 	buf := new(bytes.Buffer)
-	// TODO: Should we send event directly or more events?
-	err = tmpl.Execute(buf, event)
+	err = tmpl.Execute(buf, data)
 	if err != nil {
 		slog.With(
 			"err", err,
@@ -109,3 +118,38 @@ func serializeEventWithLayout(layout map[string]any, ev *kube.EnhancedEvent) ([]
 	}
 	return toSend, nil
 }
+
+// ValidateTemplate checks whether the text can be parsed as a template without executing it, so that configuration
+// errors can be reported before any event is received.
+func ValidateTemplate(text string) error {
+	_, err := template.New("template").Funcs(sprig.TxtFuncMap()).Parse(text)
+	return err
+}
+
+// validateTemplates validates the templates in the strings of a layout, such as the nested maps and lists decoded from
+// the configuration
+func validateTemplates(value any) error {
+	switch v := value.(type) {
+	case string:
+		return ValidateTemplate(v)
+	case map[any]any:
+		for _, v := range v {
+			if err := validateTemplates(v); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, v := range v {
+			if err := validateTemplates(v); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, v := range v {
+			if err := validateTemplates(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package sinks
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"sync"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+)
+
+// Transform modifies an event before it is routed or sent to a receiver. Each transform does exactly one of the
+// operations below. The labels and annotations are the ones of the involved object, which the rules match and the
+// layouts use.
+type Transform struct {
+	// SetLabels sets the labels to the values, which are templates rendered with the event
+	SetLabels map[string]string `yaml:"setLabels"`
+	// RenameLabels renames the labels in the keys to the values
+	RenameLabels map[string]string `yaml:"renameLabels"`
+	DropLabels   []string          `yaml:"dropLabels"`
+	// DropAnnotations is useful for the noisy ones such as kubectl.kubernetes.io/last-applied-configuration
+	DropAnnotations []string `yaml:"dropAnnotations"`
+	// TruncateMessage is the maximum number of characters of the message
+	TruncateMessage int `yaml:"truncateMessage"`
+	// ReplaceMessage replaces the matches of a regular expression in the message
+	ReplaceMessage *ReplaceTransform `yaml:"replaceMessage"`
+}
+
+// ReplaceTransform replaces the matches of Regex with Replacement, which can refer to the groups such as ${1}
+type ReplaceTransform struct {
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+
+	once sync.Once
+	re   *regexp.Regexp
+	err  error
+}
+
+func (r *ReplaceTransform) regexp() (*regexp.Regexp, error) {
+	r.once.Do(func() {
+		r.re, r.err = regexp.Compile(r.Regex)
+	})
+	return r.re, r.err
+}
+
+func (t *Transform) Validate() error {
+	operations := 0
+	for _, set := range []bool{
+		t.SetLabels != nil,
+		t.RenameLabels != nil,
+		t.DropLabels != nil,
+		t.DropAnnotations != nil,
+		t.TruncateMessage != 0,
+		t.ReplaceMessage != nil,
+	} {
+		if set {
+			operations++
+		}
+	}
+	if operations != 1 {
+		return errors.New("a transform must have exactly one operation")
+	}
+
+	for key, value := range t.SetLabels {
+		if err := ValidateTemplate(value); err != nil {
+			return fmt.Errorf("invalid template for label %s: %w", key, err)
+		}
+	}
+	if t.TruncateMessage < 0 {
+		return errors.New("truncateMessage cannot be negative")
+	}
+	if t.ReplaceMessage != nil {
+		if _, err := t.ReplaceMessage.regexp(); err != nil {
+			return fmt.Errorf("invalid replaceMessage regex: %w", err)
+		}
+	}
+	return nil
+}
+
+// Transforms are applied in order
+type Transforms []Transform
+
+func (t Transforms) Validate() error {
+	for i := range t {
+		if err := t[i].Validate(); err != nil {
+			return fmt.Errorf("transform %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Apply returns a transformed copy of the event, the event itself is not modified since it is shared between the
+// receivers. Without any transforms, the event is returned as is.
+func (t Transforms) Apply(ev *kube.EnhancedEvent) *kube.EnhancedEvent {
+	if len(t) == 0 {
+		return ev
+	}
+
+	c := *ev
+	c.InvolvedObject.Labels = maps.Clone(ev.InvolvedObject.Labels)
+	c.InvolvedObject.Annotations = maps.Clone(ev.InvolvedObject.Annotations)
+	for i := range t {
+		t[i].apply(&c)
+	}
+	return &c
+}
+
+func (t *Transform) apply(ev *kube.EnhancedEvent) {
+	if len(t.SetLabels) > 0 {
+		if ev.InvolvedObject.Labels == nil {
+			ev.InvolvedObject.Labels = make(map[string]string, len(t.SetLabels))
+		}
+		// All the templates are rendered with the event before setting any of the labels
+		values := make(map[string]string, len(t.SetLabels))
+		for key, text := range t.SetLabels {
+			value, err := GetString(ev, text)
+			if err != nil {
+				slog.With("label", key, "err", err).Error("Cannot render the label, not setting it")
+				continue
+			}
+			values[key] = value
+		}
+		maps.Copy(ev.InvolvedObject.Labels, values)
+	}
+
+	for from, to := range t.RenameLabels {
+		if value, ok := ev.InvolvedObject.Labels[from]; ok {
+			delete(ev.InvolvedObject.Labels, from)
+			ev.InvolvedObject.Labels[to] = value
+		}
+	}
+
+	for _, key := range t.DropLabels {
+		delete(ev.InvolvedObject.Labels, key)
+	}
+
+	for _, key := range t.DropAnnotations {
+		delete(ev.InvolvedObject.Annotations, key)
+	}
+
+	if t.TruncateMessage > 0 {
+		ev.Message = truncate(ev.Message, t.TruncateMessage)
+	}
+
+	if t.ReplaceMessage != nil {
+		re, err := t.ReplaceMessage.regexp()
+		if err != nil {
+			slog.With("err", err).Error("Invalid replaceMessage regex, not replacing")
+			return
+		}
+		ev.Message = re.ReplaceAllString(ev.Message, t.ReplaceMessage.Replacement)
+	}
+}
+
+// truncate cuts the string to at most n characters
+func truncate(s string, n int) string {
+	count := 0
+	for i := range s {
+		if count == n {
+			return s[:i]
+		}
+		count++
+	}
+	return s
+}
@@ -0,0 +1,73 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransformsApply(t *testing.T) {
+	var transforms Transforms
+	err := yaml.Unmarshal([]byte(`
+- setLabels:
+    cluster: prod
+    owner: "{{ .InvolvedObject.Namespace }}-team"
+- renameLabels:
+    app: app.kubernetes.io/name
+- dropLabels: [pod-template-hash]
+- dropAnnotations: [kubectl.kubernetes.io/last-applied-configuration]
+- replaceMessage:
+    regex: "password=\\S+"
+    replacement: "password=***"
+- truncateMessage: 24
+`), &transforms)
+	require.NoError(t, err)
+	require.NoError(t, transforms.Validate())
+
+	ev := &kube.EnhancedEvent{}
+	ev.Message = "Connecting with password=hunter2 failed again"
+	ev.InvolvedObject.Namespace = "payments"
+	ev.InvolvedObject.Labels = map[string]string{"app": "api", "pod-template-hash": "abc"}
+	ev.InvolvedObject.Annotations = map[string]string{
+		"kubectl.kubernetes.io/last-applied-configuration": "{}",
+		"team": "payments",
+	}
+
+	transformed := transforms.Apply(ev)
+
+	assert.Equal(t, "Connecting with password", transformed.Message)
+	assert.Equal(t, map[string]string{
+		"cluster":                "prod",
+		"owner":                  "payments-team",
+		"app.kubernetes.io/name": "api",
+	}, transformed.InvolvedObject.Labels)
+	assert.Equal(t, map[string]string{"team": "payments"}, transformed.InvolvedObject.Annotations)
+
+	// The original event is shared with the other receivers, so it is left as is
+	assert.Equal(t, "Connecting with password=hunter2 failed again", ev.Message)
+	assert.Len(t, ev.InvolvedObject.Labels, 2)
+	assert.Len(t, ev.InvolvedObject.Annotations, 2)
+}
+
+func TestTransformsApplyWithoutTransforms(t *testing.T) {
+	ev := &kube.EnhancedEvent{}
+	assert.Same(t, ev, Transforms(nil).Apply(ev))
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "héll", truncate("héllo", 4))
+	assert.Equal(t, "héllo", truncate("héllo", 5))
+	assert.Equal(t, "héllo", truncate("héllo", 10))
+}
+
+func TestTransformValidate(t *testing.T) {
+	assert.Error(t, (&Transform{}).Validate())
+	assert.Error(t, (&Transform{TruncateMessage: 10, DropLabels: []string{"a"}}).Validate())
+	assert.Error(t, (&Transform{TruncateMessage: -1}).Validate())
+	assert.Error(t, (&Transform{SetLabels: map[string]string{"a": "{{ .Message"}}).Validate())
+	assert.Error(t, (&Transform{ReplaceMessage: &ReplaceTransform{Regex: "("}}).Validate())
+	assert.NoError(t, (&Transform{DropAnnotations: []string{"a"}}).Validate())
+}
@@ -2,37 +2,266 @@ package sinks
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	defaultWebhookTimeout         = 10 * time.Second
+	defaultWebhookMaxRetries      = 3
+	defaultWebhookSignatureHeader = "X-Signature"
+	defaultWebhookTimestampHeader = "X-Signature-Timestamp"
+)
+
+// The status codes of the responses considered successful and retryable unless configured, the others are fatal
+var (
+	defaultWebhookSuccess   = []string{"2xx"}
+	defaultWebhookRetryable = []string{"408", "429", "5xx"}
 )
 
 type WebhookConfig struct {
-	Endpoint string            `yaml:"endpoint"`
-	TLS      TLS               `yaml:"tls"`
-	Layout   map[string]any    `yaml:"layout"`
-	Headers  map[string]string `yaml:"headers"`
+	Endpoint string `yaml:"endpoint"`
+	// Method is the HTTP method of the requests, POST by default
+	Method  string            `yaml:"method"`
+	TLS     TLS               `yaml:"tls"`
+	Layout  map[string]any    `yaml:"layout"`
+	Headers map[string]string `yaml:"headers"`
+	// Timeout bounds a request including reading the response, 10s by default
+	Timeout time.Duration   `yaml:"timeout"`
+	Auth    *WebhookAuth    `yaml:"auth"`
+	Signing *WebhookSigning `yaml:"signing"`
+	// Gzip compresses the request bodies
+	Gzip      bool             `yaml:"gzip"`
+	Responses WebhookResponses `yaml:"responses"`
+	// MaxRetries is the number of times a request with a retryable response is retried with a backoff, or after its
+	// Retry-After delay, 3 by default. The batches are retried by the batch writer instead.
+	MaxRetries int `yaml:"maxRetries"`
+	// Batch sends the events in batches instead of a request per event
+	Batch *WebhookBatchConfig `yaml:"batch"`
+}
+
+// WebhookAuth authenticates the requests, only one of the schemes can be set
+type WebhookAuth struct {
+	Basic  *WebhookBasicAuth  `yaml:"basic"`
+	Bearer *WebhookBearerAuth `yaml:"bearer"`
+	OAuth2 *WebhookOAuth2     `yaml:"oauth2"`
+}
+
+type WebhookBasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// WebhookBearerAuth sends a bearer token. The TokenFile is read on every request, so that a rotated token, such as a
+// projected service account token, is picked up.
+type WebhookBearerAuth struct {
+	Token     string `yaml:"token"`
+	TokenFile string `yaml:"tokenFile"`
+}
+
+// WebhookOAuth2 fetches the tokens with the client credentials flow, a token is reused until it is about to expire
+type WebhookOAuth2 struct {
+	TokenURL       string            `yaml:"tokenURL"`
+	ClientID       string            `yaml:"clientId"`
+	ClientSecret   string            `yaml:"clientSecret"`
+	Scopes         []string          `yaml:"scopes"`
+	EndpointParams map[string]string `yaml:"endpointParams"`
+}
+
+// WebhookSigning signs the requests with an HMAC-SHA256 of the timestamp and the body, joined with a dot. The
+// signature is sent as sha256=<hex> in Header and the unix timestamp in TimestampHeader, so that the receiver can
+// verify the origin of the requests and reject the replayed ones.
+type WebhookSigning struct {
+	Secret          string `yaml:"secret"`
+	SecretFile      string `yaml:"secretFile"`
+	Header          string `yaml:"header"`
+	TimestampHeader string `yaml:"timestampHeader"`
+}
+
+// WebhookResponses classifies the status codes of the responses, as exact codes such as 409 or classes such as 5xx.
+// The codes neither successful nor retryable are fatal, the fatal ones take precedence over the retryable ones.
+type WebhookResponses struct {
+	Success   []string `yaml:"success"`
+	Retryable []string `yaml:"retryable"`
+	Fatal     []string `yaml:"fatal"`
+}
+
+var statusPattern = regexp.MustCompile(`^[1-5]([0-9]{2}|xx)$`)
+
+func (r *WebhookResponses) validate() error {
+	for _, patterns := range [][]string{r.Success, r.Retryable, r.Fatal} {
+		for _, p := range patterns {
+			if !statusPattern.MatchString(p) {
+				return fmt.Errorf("invalid webhook status code %q: must be a code such as 409 or a class such as 5xx", p)
+			}
+		}
+	}
+	return nil
+}
+
+func matchStatus(patterns []string, code int) bool {
+	status := strconv.Itoa(code)
+	for _, p := range patterns {
+		if p == status || (strings.HasSuffix(p, "xx") && p[0] == status[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// classify returns the error of a response: nil if successful, permanent if fatal
+func (r *WebhookResponses) classify(code int, body []byte) error {
+	success := r.Success
+	if success == nil {
+		success = defaultWebhookSuccess
+	}
+	retryable := r.Retryable
+	if retryable == nil {
+		retryable = defaultWebhookRetryable
+	}
+
+	if matchStatus(success, code) {
+		return nil
+	}
+	err := fmt.Errorf("not successful (%d) response: %s", code, string(body))
+	if matchStatus(r.Fatal, code) || !matchStatus(retryable, code) {
+		return Permanent(err)
+	}
+	return err
 }
 
 func NewWebhook(cfg *WebhookConfig) (Sink, error) {
+	w, err := newWebhook(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Batch != nil {
+		bw, err := newBatchWebhook(w)
+		if err != nil {
+			return nil, err
+		}
+		return bw, nil
+	}
+	return w, nil
+}
+
+func newWebhook(cfg *WebhookConfig) (*Webhook, error) {
+	switch strings.ToUpper(cfg.Method) {
+	case "":
+		cfg.Method = http.MethodPost
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		cfg.Method = strings.ToUpper(cfg.Method)
+	default:
+		return nil, fmt.Errorf("invalid webhook method: %s: can be one of 'POST', 'PUT' or 'PATCH'", cfg.Method)
+	}
+	if err := cfg.Responses.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultWebhookMaxRetries
+	}
+
 	tlsClientConfig, err := setupTLS(&cfg.TLS)
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup TLS: %w", err)
 	}
-	return &Webhook{cfg: cfg, transport: &http.Transport{
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultWebhookTimeout
+	}
+	transport := &http.Transport{
 		Proxy:           http.ProxyFromEnvironment,
 		TLSClientConfig: tlsClientConfig,
-	}}, nil
+	}
+	w := &Webhook{
+		cfg:       cfg,
+		transport: transport,
+		client:    &http.Client{Timeout: timeout, Transport: transport},
+	}
+
+	if err := w.setupAuth(); err != nil {
+		return nil, err
+	}
+	if cfg.Signing != nil {
+		w.signingSecret = []byte(cfg.Signing.Secret)
+		if cfg.Signing.SecretFile != "" {
+			secret, err := os.ReadFile(cfg.Signing.SecretFile)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read the webhook signing secret: %w", err)
+			}
+			w.signingSecret = bytes.TrimSpace(secret)
+		}
+		if len(w.signingSecret) == 0 {
+			return nil, errors.New("webhook signing requires a secret or a secretFile")
+		}
+	}
+	return w, nil
+}
+
+func (w *Webhook) setupAuth() error {
+	auth := w.cfg.Auth
+	if auth == nil {
+		return nil
+	}
+
+	schemes := 0
+	for _, set := range []bool{auth.Basic != nil, auth.Bearer != nil, auth.OAuth2 != nil} {
+		if set {
+			schemes++
+		}
+	}
+	if schemes > 1 {
+		return errors.New("only one of the webhook auth schemes can be set")
+	}
+
+	if auth.Bearer != nil && auth.Bearer.Token == "" && auth.Bearer.TokenFile == "" {
+		return errors.New("webhook bearer auth requires a token or a tokenFile")
+	}
+	if auth.OAuth2 != nil {
+		if auth.OAuth2.TokenURL == "" || auth.OAuth2.ClientID == "" {
+			return errors.New("webhook oauth2 requires a tokenURL and a clientId")
+		}
+		params := url.Values{}
+		for k, v := range auth.OAuth2.EndpointParams {
+			params.Set(k, v)
+		}
+		cc := &clientcredentials.Config{
+			ClientID:       auth.OAuth2.ClientID,
+			ClientSecret:   auth.OAuth2.ClientSecret,
+			TokenURL:       auth.OAuth2.TokenURL,
+			Scopes:         auth.OAuth2.Scopes,
+			EndpointParams: params,
+		}
+		// The tokens are fetched with the client of the webhook, so that they share its TLS configuration and timeout
+		w.tokenSource = cc.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, w.client))
+	}
+	return nil
 }
 
 type Webhook struct {
-	cfg       *WebhookConfig
-	transport *http.Transport
+	cfg           *WebhookConfig
+	transport     *http.Transport
+	client        *http.Client
+	tokenSource   oauth2.TokenSource
+	signingSecret []byte
 }
 
 func (w *Webhook) Close() {
@@ -51,11 +280,39 @@ func (w *Webhook) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
 	).
 		Debug("webhook request body")
 
-	req, err := http.NewRequest(http.MethodPost, w.cfg.Endpoint, bytes.NewReader(reqBody))
+	return withRetries(ctx, "webhook", w.cfg.MaxRetries, func() (time.Duration, error) {
+		return w.sendOnce(ctx, ev, reqBody, "application/json")
+	})
+}
+
+// send sends the body once, the headers are rendered with the given event
+func (w *Webhook) send(ctx context.Context, ev *kube.EnhancedEvent, reqBody []byte, contentType string) error {
+	_, err := w.sendOnce(ctx, ev, reqBody, contentType)
+	return err
+}
+
+// sendOnce sends the body, it returns the Retry-After delay of a retryable response or -1
+func (w *Webhook) sendOnce(ctx context.Context, ev *kube.EnhancedEvent, reqBody []byte, contentType string) (time.Duration, error) {
+	if w.cfg.Gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(reqBody); err != nil {
+			return -1, Permanent(err)
+		}
+		if err := gz.Close(); err != nil {
+			return -1, Permanent(err)
+		}
+		reqBody = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, w.cfg.Method, w.cfg.Endpoint, bytes.NewReader(reqBody))
 	if err != nil {
-		return err
+		return -1, Permanent(err)
+	}
+	req.Header.Add("Content-Type", contentType)
+	if w.cfg.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
 	}
-	req.Header.Add("Content-Type", "application/json")
 
 	for k, v := range w.cfg.Headers {
 		realValue, err := GetString(ev, v)
@@ -67,22 +324,70 @@ func (w *Webhook) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
 		}
 	}
 
-	client := http.DefaultClient
-	client.Transport = w.transport
-	resp, err := client.Do(req)
+	if err := w.authorize(req); err != nil {
+		return -1, err
+	}
+	if w.signingSecret != nil {
+		w.sign(req, reqBody, time.Now())
+	}
+
+	resp, err := w.client.Do(req)
 	if err != nil {
-		return err
+		return -1, err
 	}
 
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return -1, err
 	}
 
-	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-		return errors.New("not successful (2xx) response: " + string(body))
-	}
+	return retryAfter(resp.Header.Get("Retry-After"), -1), w.cfg.Responses.classify(resp.StatusCode, body)
+}
 
+func (w *Webhook) authorize(req *http.Request) error {
+	auth := w.cfg.Auth
+	switch {
+	case auth == nil:
+	case auth.Basic != nil:
+		req.SetBasicAuth(auth.Basic.Username, auth.Basic.Password)
+	case auth.Bearer != nil:
+		token := auth.Bearer.Token
+		if auth.Bearer.TokenFile != "" {
+			b, err := os.ReadFile(auth.Bearer.TokenFile)
+			if err != nil {
+				return fmt.Errorf("cannot read the webhook bearer token: %w", err)
+			}
+			token = strings.TrimSpace(string(b))
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case w.tokenSource != nil:
+		token, err := w.tokenSource.Token()
+		if err != nil {
+			return fmt.Errorf("cannot fetch the webhook oauth2 token: %w", err)
+		}
+		token.SetAuthHeader(req)
+	}
 	return nil
 }
+
+// sign sets the signature of the body sent, after the compression
+func (w *Webhook) sign(req *http.Request, body []byte, now time.Time) {
+	header := w.cfg.Signing.Header
+	if header == "" {
+		header = defaultWebhookSignatureHeader
+	}
+	timestampHeader := w.cfg.Signing.TimestampHeader
+	if timestampHeader == "" {
+		timestampHeader = defaultWebhookTimestampHeader
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	mac := hmac.New(sha256.New, w.signingSecret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(header, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+}
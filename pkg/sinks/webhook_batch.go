@@ -0,0 +1,116 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+)
+
+const defaultWebhookBatchMaxBytes = 1 << 20
+
+// defaultWebhookWriterConfig is used by the batching webhooks unless their batch is configured
+var defaultWebhookWriterConfig = batch.WriterConfig{
+	BatchSize:  100,
+	Interval:   time.Second,
+	MaxRetries: 3,
+	Timeout:    30 * time.Second,
+}
+
+// WebhookBatchConfig sends the events rendered with the layout in batches, as a JSON array or as newline-delimited
+// JSON. The batches are split to keep the bodies under MaxBytes, before the compression.
+type WebhookBatchConfig struct {
+	BatchConfig `yaml:",inline"`
+	// MaxBytes is the maximum size of a body, 1MiB by default
+	MaxBytes int `yaml:"maxBytes"`
+	// Format is array or ndjson, array by default
+	Format string `yaml:"format"`
+}
+
+// BatchWebhook is a webhook sending the events in batches
+type BatchWebhook struct {
+	*Webhook
+}
+
+func newBatchWebhook(w *Webhook) (*BatchWebhook, error) {
+	cfg := w.cfg.Batch
+	switch cfg.Format {
+	case "":
+		cfg.Format = "array"
+	case "array", "ndjson":
+	default:
+		return nil, fmt.Errorf("invalid webhook batch format: %s: can be one of 'array' or 'ndjson'", cfg.Format)
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultWebhookBatchMaxBytes
+	}
+	return &BatchWebhook{Webhook: w}, nil
+}
+
+func (w *BatchWebhook) Send(ctx context.Context, ev *kube.EnhancedEvent) error {
+	return w.SendBatch(ctx, []*kube.EnhancedEvent{ev})[0]
+}
+
+// SendBatch sends the events in as few requests as the maximum body size allows, the headers are rendered with the
+// first event of a request
+func (w *BatchWebhook) SendBatch(ctx context.Context, evs []*kube.EnhancedEvent) []error {
+	cfg := w.cfg.Batch
+	errs := make([]error, len(evs))
+	docs := make([][]byte, len(evs))
+	sizes := make([]int, len(evs))
+	included := make([]int, 0, len(evs))
+
+	// Every event is counted with its separator, a comma or a newline. An array has one comma less than events but
+	// takes two brackets.
+	maxBytes := cfg.MaxBytes
+	if cfg.Format == "array" {
+		maxBytes--
+	}
+	for i, ev := range evs {
+		doc, err := serializeEventWithLayout(w.cfg.Layout, ev)
+		if err != nil {
+			errs[i] = Permanent(err)
+			continue
+		}
+		if len(doc)+1 > maxBytes {
+			errs[i] = Permanent(fmt.Errorf("event of %d bytes exceeds the maximum body size", len(doc)))
+			continue
+		}
+		docs[i] = doc
+		sizes[i] = len(doc) + 1
+		included = append(included, i)
+	}
+
+	for _, chunk := range chunkBySize(included, sizes, len(evs), maxBytes) {
+		var body bytes.Buffer
+		contentType := "application/x-ndjson"
+		if cfg.Format == "array" {
+			contentType = "application/json"
+			body.WriteByte('[')
+		}
+		for n, i := range chunk {
+			if cfg.Format == "array" && n > 0 {
+				body.WriteByte(',')
+			}
+			body.Write(docs[i])
+			if cfg.Format == "ndjson" {
+				body.WriteByte('\n')
+			}
+		}
+		if cfg.Format == "array" {
+			body.WriteByte(']')
+		}
+
+		if err := w.send(ctx, evs[chunk[0]], body.Bytes(), contentType); err != nil {
+			setErrors(errs, chunk, err)
+		}
+	}
+	return errs
+}
+
+func (w *BatchWebhook) BatchConfig() batch.WriterConfig {
+	return w.cfg.Batch.WriterConfig(defaultWebhookWriterConfig)
+}
@@ -0,0 +1,382 @@
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/batch"
+	"github.com/resmoio/kubernetes-event-exporter/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newWebhookEvent() *kube.EnhancedEvent {
+	ev := &kube.EnhancedEvent{}
+	ev.Namespace = "default"
+	ev.Reason = "BackOff"
+	ev.Message = "Back-off restarting failed container"
+	return ev
+}
+
+func TestWebhookMethodAndHeaders(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	sink, err := NewWebhook(&WebhookConfig{
+		Endpoint: server.URL,
+		Method:   "put",
+		Headers:  map[string]string{"X-Namespace": "{{ .Namespace }}"},
+		Layout:   map[string]any{"reason": "{{ .Reason }}"},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, sink.Send(context.Background(), newWebhookEvent()))
+	assert.Equal(t, http.MethodPut, got.Method)
+	assert.Equal(t, "default", got.Header.Get("X-Namespace"))
+	assert.Equal(t, "application/json", got.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"reason":"BackOff"}`, string(body))
+}
+
+func TestWebhookAuth(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("from-file\n"), 0o600))
+
+	tokenRequests := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "https://events.example.com", r.PostForm.Get("audience"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"oauth-token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	for name, tc := range map[string]struct {
+		auth     *WebhookAuth
+		expected string
+	}{
+		"basic": {
+			auth:     &WebhookAuth{Basic: &WebhookBasicAuth{Username: "user", Password: "pass"}},
+			expected: "Basic dXNlcjpwYXNz",
+		},
+		"bearer": {
+			auth:     &WebhookAuth{Bearer: &WebhookBearerAuth{Token: "static"}},
+			expected: "Bearer static",
+		},
+		"bearer file": {
+			auth:     &WebhookAuth{Bearer: &WebhookBearerAuth{TokenFile: tokenFile}},
+			expected: "Bearer from-file",
+		},
+		"oauth2": {
+			auth: &WebhookAuth{OAuth2: &WebhookOAuth2{
+				TokenURL:       tokenServer.URL,
+				ClientID:       "exporter",
+				ClientSecret:   "secret",
+				EndpointParams: map[string]string{"audience": "https://events.example.com"},
+			}},
+			expected: "Bearer oauth-token",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			sink, err := NewWebhook(&WebhookConfig{Endpoint: server.URL, Auth: tc.auth})
+			require.NoError(t, err)
+
+			for range 2 {
+				require.NoError(t, sink.Send(context.Background(), newWebhookEvent()))
+				assert.Equal(t, tc.expected, authorization)
+			}
+		})
+	}
+	assert.Equal(t, 1, tokenRequests, "the oauth2 token is reused")
+}
+
+func TestWebhookSigningAndGzip(t *testing.T) {
+	var got *http.Request
+	var sent []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		sent, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	sink, err := NewWebhook(&WebhookConfig{
+		Endpoint: server.URL,
+		Gzip:     true,
+		Layout:   map[string]any{"reason": "{{ .Reason }}"},
+		Signing:  &WebhookSigning{Secret: "s3cr3t", Header: "X-Hub-Signature"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(context.Background(), newWebhookEvent()))
+
+	assert.Equal(t, "gzip", got.Header.Get("Content-Encoding"))
+	gz, err := gzip.NewReader(bytes.NewReader(sent))
+	require.NoError(t, err)
+	body, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"reason":"BackOff"}`, string(body))
+
+	timestamp := got.Header.Get("X-Signature-Timestamp")
+	require.NotEmpty(t, timestamp)
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(sent)
+	assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), got.Header.Get("X-Hub-Signature"))
+}
+
+func TestWebhookResponses(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	defaults, err := NewWebhook(&WebhookConfig{Endpoint: server.URL})
+	require.NoError(t, err)
+	configured, err := NewWebhook(&WebhookConfig{Endpoint: server.URL, Responses: WebhookResponses{
+		Success:   []string{"2xx", "409"},
+		Retryable: []string{"5xx", "404"},
+		Fatal:     []string{"501"},
+	}})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		sink      Sink
+		status    int
+		success   bool
+		permanent bool
+	}{
+		{sink: defaults, status: http.StatusAccepted, success: true},
+		{sink: defaults, status: http.StatusTooManyRequests},
+		{sink: defaults, status: http.StatusServiceUnavailable},
+		{sink: defaults, status: http.StatusBadRequest, permanent: true},
+		{sink: defaults, status: http.StatusConflict, permanent: true},
+		{sink: configured, status: http.StatusConflict, success: true},
+		{sink: configured, status: http.StatusNotFound},
+		{sink: configured, status: http.StatusNotImplemented, permanent: true},
+		{sink: configured, status: http.StatusTooManyRequests, permanent: true},
+	} {
+		status = tc.status
+		err := tc.sink.Send(context.Background(), newWebhookEvent())
+		if tc.success {
+			assert.NoError(t, err, tc.status)
+			continue
+		}
+		require.Error(t, err, tc.status)
+		assert.Equal(t, tc.permanent, IsPermanent(err), tc.status)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	var requests, failures int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	failures = 1
+	sink, err := NewWebhook(&WebhookConfig{Endpoint: server.URL})
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(context.Background(), newWebhookEvent()))
+	assert.Equal(t, 2, requests)
+
+	// The request fails once the retries are exhausted
+	requests, failures = 0, 5
+	sink, err = NewWebhook(&WebhookConfig{Endpoint: server.URL, MaxRetries: 1})
+	require.NoError(t, err)
+	err = sink.Send(context.Background(), newWebhookEvent())
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+	assert.Equal(t, 2, requests)
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	sink, err := NewWebhook(&WebhookConfig{Endpoint: server.URL, Timeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+
+	err = sink.Send(context.Background(), newWebhookEvent())
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+}
+
+func TestWebhookConfigErrors(t *testing.T) {
+	for name, cfg := range map[string]*WebhookConfig{
+		"invalid webhook method":       {Method: "DELETE"},
+		"invalid webhook status code":  {Responses: WebhookResponses{Retryable: []string{"50x"}}},
+		"only one of the webhook auth": {Auth: &WebhookAuth{Basic: &WebhookBasicAuth{}, Bearer: &WebhookBearerAuth{Token: "t"}}},
+		"requires a token":             {Auth: &WebhookAuth{Bearer: &WebhookBearerAuth{}}},
+		"requires a tokenURL":          {Auth: &WebhookAuth{OAuth2: &WebhookOAuth2{ClientID: "exporter"}}},
+		"requires a secret":            {Signing: &WebhookSigning{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewWebhook(cfg)
+			assert.ErrorContains(t, err, name)
+		})
+	}
+}
+
+type webhookRequests struct {
+	mu     sync.Mutex
+	bodies []string
+	types  []string
+	status int
+}
+
+func newWebhookRequests(t *testing.T) (*webhookRequests, *httptest.Server) {
+	reqs := &webhookRequests{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		reqs.mu.Lock()
+		defer reqs.mu.Unlock()
+		reqs.bodies = append(reqs.bodies, string(body))
+		reqs.types = append(reqs.types, r.Header.Get("Content-Type"))
+		if reqs.status != 0 {
+			w.WriteHeader(reqs.status)
+		}
+	}))
+	return reqs, server
+}
+
+func newWebhookEvents(reasons ...string) []*kube.EnhancedEvent {
+	evs := make([]*kube.EnhancedEvent, 0, len(reasons))
+	for _, reason := range reasons {
+		ev := newWebhookEvent()
+		ev.Reason = reason
+		evs = append(evs, ev)
+	}
+	return evs
+}
+
+func TestWebhookBatchArray(t *testing.T) {
+	reqs, server := newWebhookRequests(t)
+	defer server.Close()
+
+	sink, err := NewWebhook(&WebhookConfig{
+		Endpoint: server.URL,
+		Layout:   map[string]any{"reason": "{{ .Reason }}"},
+		// Two of the events fit in 42 bytes with the brackets and the comma
+		Batch: &WebhookBatchConfig{MaxBytes: 42},
+	})
+	require.NoError(t, err)
+	bs, ok := sink.(BatchSink)
+	require.True(t, ok)
+
+	errs := bs.SendBatch(context.Background(), newWebhookEvents("Pulled", "Created", "Started"))
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+	assert.Equal(t, []string{
+		`[{"reason":"Pulled"},{"reason":"Created"}]`,
+		`[{"reason":"Started"}]`,
+	}, reqs.bodies)
+	assert.Equal(t, []string{"application/json", "application/json"}, reqs.types)
+}
+
+func TestWebhookBatchNDJSON(t *testing.T) {
+	reqs, server := newWebhookRequests(t)
+	defer server.Close()
+
+	sink, err := NewWebhook(&WebhookConfig{
+		Endpoint: server.URL,
+		Layout:   map[string]any{"reason": "{{ .Reason }}"},
+		Batch:    &WebhookBatchConfig{Format: "ndjson"},
+	})
+	require.NoError(t, err)
+
+	errs := sink.(BatchSink).SendBatch(context.Background(), newWebhookEvents("Pulled", "Created"))
+	assert.Equal(t, []error{nil, nil}, errs)
+	assert.Equal(t, []string{"{\"reason\":\"Pulled\"}\n{\"reason\":\"Created\"}\n"}, reqs.bodies)
+	assert.Equal(t, []string{"application/x-ndjson"}, reqs.types)
+}
+
+func TestWebhookBatchErrors(t *testing.T) {
+	reqs, server := newWebhookRequests(t)
+	defer server.Close()
+	reqs.status = http.StatusServiceUnavailable
+
+	sink, err := NewWebhook(&WebhookConfig{
+		Endpoint: server.URL,
+		Layout:   map[string]any{"reason": "{{ .Reason }}"},
+		Batch:    &WebhookBatchConfig{MaxBytes: 30},
+	})
+	require.NoError(t, err)
+
+	errs := sink.(BatchSink).SendBatch(context.Background(), newWebhookEvents("Pulled", "ReasonTooLongToFitInTheBody"))
+	require.Len(t, errs, 2)
+	require.Error(t, errs[0])
+	assert.False(t, IsPermanent(errs[0]), "the batch is retried")
+	assert.True(t, IsPermanent(errs[1]), "the oversized event is dropped")
+	assert.Len(t, reqs.bodies, 1)
+}
+
+func TestWebhookBatchConfig(t *testing.T) {
+	cfg := &WebhookConfig{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+endpoint: http://localhost
+batch:
+  size: 50
+  interval: 5s
+  maxBytes: 2048
+`), cfg))
+
+	sink, err := NewWebhook(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, batch.WriterConfig{
+		BatchSize:  50,
+		Interval:   5 * time.Second,
+		MaxRetries: 3,
+		Timeout:    30 * time.Second,
+	}, sink.(BatchSink).BatchConfig())
+	assert.Equal(t, 2048, cfg.Batch.MaxBytes)
+	assert.Equal(t, "array", cfg.Batch.Format)
+
+	sink, err = NewWebhook(&WebhookConfig{Endpoint: "http://localhost"})
+	require.NoError(t, err)
+	_, isBatch := sink.(BatchSink)
+	assert.False(t, isBatch, "the events are sent one by one unless batched")
+
+	_, err = NewWebhook(&WebhookConfig{Batch: &WebhookBatchConfig{Format: "csv"}})
+	assert.ErrorContains(t, err, "invalid webhook batch format")
+}